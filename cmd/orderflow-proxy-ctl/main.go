@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/flashbots/go-utils/rpcclient"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/flashbots/tdx-orderflow-proxy/common"
+	"github.com/flashbots/tdx-orderflow-proxy/proxy"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2" // imports as package "cli"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+var errWrongOutput = errors.New("output should be table or json")
+
+var flags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "admin-url",
+		Value:   "http://127.0.0.1:8090",
+		Usage:   "address of the proxy metrics server that serves the admin API",
+		EnvVars: []string{"ADMIN_URL"},
+	},
+	&cli.StringFlag{
+		Name:    "admin-token",
+		Value:   "",
+		Usage:   "bearer token for the admin API",
+		EnvVars: []string{"ADMIN_TOKEN"},
+	},
+	&cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Value:   outputTable,
+		Usage:   "output format: table or json",
+		EnvVars: []string{"OUTPUT"},
+	},
+}
+
+func main() {
+	app := &cli.App{
+		Name:    "orderflow-proxy-ctl",
+		Usage:   "Inspect and control running receiver-proxy or sender-proxy using its admin API",
+		Flags:   flags,
+		Version: common.Version,
+		Before: func(cCtx *cli.Context) error {
+			output := cCtx.String("output")
+			if output != outputTable && output != outputJSON {
+				return errWrongOutput
+			}
+			return nil
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "peers",
+				Usage: "list and control peers",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "list peers with their health and queues",
+						Action: peersList,
+					},
+					{
+						Name:      "disable",
+						Usage:     "stop sharing orderflow to the peer",
+						ArgsUsage: "<name>",
+						Action: func(cCtx *cli.Context) error {
+							return setPeerDisabled(cCtx, true)
+						},
+					},
+					{
+						Name:      "enable",
+						Usage:     "resume sharing orderflow to the peer",
+						ArgsUsage: "<name>",
+						Action: func(cCtx *cli.Context) error {
+							return setPeerDisabled(cCtx, false)
+						},
+					},
+					{
+						Name:  "refresh",
+						Usage: "force peer list refresh from BuilderHub",
+						Action: func(cCtx *cli.Context) error {
+							return adminClient(cCtx).RefreshPeers(cCtx.Context)
+						},
+					},
+				},
+			},
+			{
+				Name:   "queues",
+				Usage:  "show internal queues",
+				Action: queues,
+			},
+			{
+				Name:  "archive",
+				Usage: "control orderflow archive",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "flush",
+						Usage: "force flush of the archive queue",
+						Action: func(cCtx *cli.Context) error {
+							return adminClient(cCtx).FlushArchive(cCtx.Context)
+						},
+					},
+				},
+			},
+			{
+				Name:  "loglevel",
+				Usage: "show or change log level",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "get",
+						Usage: "show current log level",
+						Action: func(cCtx *cli.Context) error {
+							level, err := adminClient(cCtx).LogLevel(cCtx.Context)
+							if err != nil {
+								return err
+							}
+							return printLogLevel(cCtx, level)
+						},
+					},
+					{
+						Name:      "set",
+						Usage:     "change log level",
+						ArgsUsage: "<debug|info|warn|error>",
+						Action: func(cCtx *cli.Context) error {
+							if cCtx.NArg() != 1 {
+								return cli.ShowSubcommandHelp(cCtx)
+							}
+							level, err := adminClient(cCtx).SetLogLevel(cCtx.Context, cCtx.Args().First())
+							if err != nil {
+								return err
+							}
+							return printLogLevel(cCtx, level)
+						},
+					},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "control reloadable config",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "reload",
+						Usage: "reload config file, same as sending SIGHUP to the proxy",
+						Action: func(cCtx *cli.Context) error {
+							return adminClient(cCtx).ReloadConfig(cCtx.Context)
+						},
+					},
+				},
+			},
+			{
+				Name:  "signer",
+				Usage: "orderflow signer of the proxy",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "show",
+						Usage:  "show signer address and its registration status",
+						Action: signerShow,
+					},
+				},
+			},
+			{
+				Name:  "send-test-order",
+				Usage: "send test bundle to the orderflow endpoint of the proxy",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "orderflow-endpoint",
+						Value:   "http://127.0.0.1:443",
+						Usage:   "user orderflow endpoint of the proxy",
+						EnvVars: []string{"ORDERFLOW_ENDPOINT"},
+					},
+					&cli.StringFlag{
+						Name:    "signer-private-key",
+						Value:   "",
+						Usage:   "signer of the request, random signer is used if empty",
+						EnvVars: []string{"SIGNER_PRIVATE_KEY"},
+					},
+					&cli.Uint64Flag{
+						Name:  "block-number",
+						Value: 0,
+						Usage: "target block of the bundle, if 0 the current block from rpc-endpoint is used",
+					},
+					&cli.StringFlag{
+						Name:    "rpc-endpoint",
+						Value:   "http://127.0.0.1:8545",
+						Usage:   "address of the node RPC that supports eth_blockNumber",
+						EnvVars: []string{"RPC_ENDPOINT"},
+					},
+					&cli.Uint64Flag{
+						Name:    "chain-id",
+						Value:   1,
+						Usage:   "chain id of the test transaction",
+						EnvVars: []string{"CHAIN_ID"},
+					},
+				},
+				Action: sendTestOrder,
+			},
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func adminClient(cCtx *cli.Context) *proxy.AdminClient {
+	return proxy.NewAdminClient(cCtx.String("admin-url"), cCtx.String("admin-token"))
+}
+
+// printOutput prints value as JSON or calls table to write table rows
+func printOutput(cCtx *cli.Context, value any, table func(w *tabwriter.Writer)) error {
+	if cCtx.String("output") == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+func peersList(cCtx *cli.Context) error {
+	peers, err := adminClient(cCtx).Peers(cCtx.Context)
+	if err != nil {
+		return err
+	}
+	return printOutput(cCtx, peers, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "NAME\tDISABLED\tHEALTHY\tQUEUE\tCAPACITY\tOLDEST(s)\tWORKERS\tINFLIGHT\tENDPOINT\tSIGNER\tERROR")
+		for _, peer := range peers {
+			fmt.Fprintf(w, "%s\t%t\t%t\t%d\t%d\t%.3f\t%d\t%d\t%s\t%s\t%s\n",
+				peer.Name, peer.Disabled, peer.Health.Healthy, peer.QueueLength, peer.QueueCapacity,
+				peer.QueueOldestItemAgeSeconds, peer.Workers, peer.InflightRequests,
+				peer.Config.SystemAPIAddress(), peer.Config.OrderflowProxy.EcdsaPubkeyAddress.Hex(), peer.Health.Error)
+		}
+	})
+}
+
+func setPeerDisabled(cCtx *cli.Context, disabled bool) error {
+	if cCtx.NArg() != 1 {
+		return cli.ShowSubcommandHelp(cCtx)
+	}
+	return adminClient(cCtx).SetPeerDisabled(cCtx.Context, cCtx.Args().First(), disabled)
+}
+
+func queues(cCtx *cli.Context) error {
+	queues, err := adminClient(cCtx).Queues(cCtx.Context)
+	if err != nil {
+		return err
+	}
+	return printOutput(cCtx, queues, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "NAME\tLENGTH\tCAPACITY\tOLDEST(s)")
+		for _, queue := range queues {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%.3f\n", queue.Name, queue.Length, queue.Capacity, queue.OldestItemAgeSeconds)
+		}
+	})
+}
+
+func printLogLevel(cCtx *cli.Context, level string) error {
+	return printOutput(cCtx, proxy.AdminLogLevel{Level: level}, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, level)
+	})
+}
+
+func signerShow(cCtx *cli.Context) error {
+	signer, err := adminClient(cCtx).Signer(cCtx.Context)
+	if err != nil {
+		return err
+	}
+	return printOutput(cCtx, signer, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "ADDRESS\tREGISTERED")
+		registered := "-"
+		if signer.Registered != nil {
+			registered = strconv.FormatBool(*signer.Registered)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", signer.Address.Hex(), registered)
+	})
+}
+
+func sendTestOrder(cCtx *cli.Context) error {
+	var (
+		signer *signature.Signer
+		err    error
+	)
+	if key := cCtx.String("signer-private-key"); key != "" {
+		signer, err = signature.NewSignerFromHexPrivateKey(key)
+	} else {
+		signer, err = signature.NewRandomSigner()
+	}
+	if err != nil {
+		return err
+	}
+
+	block := cCtx.Uint64("block-number")
+	if block == 0 {
+		block, err = proxy.NewBlockNumberSource(cCtx.String("rpc-endpoint")).BlockNumber()
+		if err != nil {
+			return err
+		}
+	}
+
+	testTx, err := common.NewTestTx(cCtx.Uint64("chain-id"), 0)
+	if err != nil {
+		return err
+	}
+
+	replacementUUID := uuid.New()
+	blockNumber := hexutil.Uint64(block)
+	bundleArgs := rpctypes.EthSendBundleArgs{
+		Txs:             []hexutil.Bytes{testTx},
+		ReplacementUUID: &replacementUUID,
+		BlockNumber:     &blockNumber,
+	}
+	bundleHash, _, err := bundleArgs.Validate()
+	if err != nil {
+		return err
+	}
+
+	client := rpcclient.NewClientWithOpts(cCtx.String("orderflow-endpoint"), &rpcclient.RPCClientOpts{
+		Signer: signer,
+	})
+	resp, err := client.Call(context.Background(), proxy.EthSendBundleMethod, bundleArgs)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s failed: %s", proxy.EthSendBundleMethod, resp.Error.Message)
+	}
+
+	result := struct {
+		Signer          string `json:"signer"`
+		BlockNumber     uint64 `json:"blockNumber"`
+		BundleHash      string `json:"bundleHash"`
+		ReplacementUUID string `json:"replacementUuid"`
+	}{signer.Address().Hex(), block, bundleHash.Hex(), replacementUUID.String()}
+	return printOutput(cCtx, result, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "SIGNER\tBLOCK\tBUNDLE HASH\tREPLACEMENT UUID")
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", result.Signer, result.BlockNumber, result.BundleHash, result.ReplacementUUID)
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/pprof"
 	"os"
@@ -39,6 +40,50 @@ var flags = []cli.Flag{
 		EnvVars: []string{"SYSTEM_LISTEN_ADDR"},
 	},
 
+	// TLS
+	&cli.StringFlag{
+		Name:    "tls-cert-path",
+		Value:   "",
+		Usage:   "path to the TLS certificate, generated if it doesn't exist (TLS is disabled if empty)",
+		EnvVars: []string{"TLS_CERT_PATH"},
+	},
+	&cli.StringFlag{
+		Name:    "tls-key-path",
+		Value:   "",
+		Usage:   "path to the TLS key, generated if it doesn't exist",
+		EnvVars: []string{"TLS_KEY_PATH"},
+	},
+	&cli.BoolFlag{
+		Name:    "user-tls",
+		Value:   true,
+		Usage:   "serve TLS on the user server if certificate is set",
+		EnvVars: []string{"USER_TLS"},
+	},
+	&cli.BoolFlag{
+		Name:    "system-tls",
+		Value:   true,
+		Usage:   "serve TLS on the system server if certificate is set",
+		EnvVars: []string{"SYSTEM_TLS"},
+	},
+	&cli.StringFlag{
+		Name:    "cert-listen-addr",
+		Value:   "",
+		Usage:   "address to listen on for serving TLS certificate on /cert (not served if empty)",
+		EnvVars: []string{"CERT_LISTEN_ADDR"},
+	},
+	&cli.DurationFlag{
+		Name:    "cert-duration",
+		Value:   proxy.DefaultTLSCertDuration,
+		Usage:   "generated certificate duration",
+		EnvVars: []string{"CERT_DURATION"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "cert-hosts",
+		Value:   cli.NewStringSlice(proxy.DefaultTLSCertHosts...),
+		Usage:   "generated certificate hosts",
+		EnvVars: []string{"CERT_HOSTS"},
+	},
+
 	// Connections to Builder, BuilderHub, RPC and block-processor
 	&cli.StringFlag{
 		Name:    "builder-endpoint",
@@ -52,6 +97,11 @@ var flags = []cli.Flag{
 		Usage:   "address to send /readyz to",
 		EnvVars: []string{"BUILDER_READY_ENDPOINT"},
 	},
+	&cli.StringSliceFlag{
+		Name:    "local-builder",
+		Usage:   "additional local builder as name:mode[:sample-percent]=endpoint, mode is primary, mirror or canary",
+		EnvVars: []string{"LOCAL_BUILDERS"},
+	},
 	&cli.StringFlag{
 		Name:    "rpc-endpoint",
 		Value:   "http://127.0.0.1:8545",
@@ -90,18 +140,124 @@ var flags = []cli.Flag{
 		Usage:   "Maximum size of the request body, if 0 default will be used",
 		EnvVars: []string{"MAX_REQUEST_BODY_SIZE_BYTES"},
 	},
+	&cli.Uint64Flag{
+		Name:    "chain-id",
+		Usage:   "Chain ID of accepted transactions (set 0 to disable the check)",
+		EnvVars: []string{"CHAIN_ID"},
+	},
+	&cli.IntFlag{
+		Name:    "max-tx-calldata-size-bytes",
+		Value:   proxy.DefaultTxMaxCalldataSizeBytes,
+		Usage:   "Maximum size of the transaction calldata",
+		EnvVars: []string{"MAX_TX_CALLDATA_SIZE_BYTES"},
+	},
+	&cli.IntFlag{
+		Name:    "max-tx-authorizations",
+		Value:   proxy.DefaultTxMaxAuthorizations,
+		Usage:   "Maximum number of EIP-7702 authorizations in a transaction",
+		EnvVars: []string{"MAX_TX_AUTHORIZATIONS"},
+	},
+	&cli.Uint64Flag{
+		Name:    "max-blocks-ahead",
+		Value:   proxy.DefaultMaxBlocksAhead,
+		Usage:   "Reject orders targeting blocks further than this from the current head",
+		EnvVars: []string{"MAX_BLOCKS_AHEAD"},
+	},
+	&cli.IntFlag{
+		Name:    "order-ledger-size",
+		Value:   proxy.DefaultOrderLedgerSize,
+		Usage:   "Number of bundle hashes, tx hashes and replacement uuids kept for flashbots_getOrderStatus",
+		EnvVars: []string{"ORDER_LEDGER_SIZE"},
+	},
+	&cli.StringFlag{
+		Name:    "order-ledger-file",
+		Value:   "",
+		Usage:   "file to keep order statuses between restarts (in memory only if empty)",
+		EnvVars: []string{"ORDER_LEDGER_FILE"},
+	},
+	&cli.DurationFlag{
+		Name:    "dedupe-window",
+		Value:   proxy.DefaultDedupeWindow,
+		Usage:   "how long unique keys of orders are remembered to drop duplicates from users and peers",
+		EnvVars: []string{"DEDUPE_WINDOW"},
+	},
+	&cli.IntFlag{
+		Name:    "dedupe-max-entries",
+		Value:   proxy.DefaultDedupeMaxEntries,
+		Usage:   "Maximum number of remembered unique keys of orders, the oldest keys are evicted before the window passes if it's reached",
+		EnvVars: []string{"DEDUPE_MAX_ENTRIES"},
+	},
+	&cli.StringFlag{
+		Name:    "dedupe-file",
+		Value:   "",
+		Usage:   "file to keep unique keys of orders between restarts (in memory only if empty)",
+		EnvVars: []string{"DEDUPE_FILE"},
+	},
+	&cli.IntFlag{
+		Name:    "max-batch-length",
+		Value:   proxy.DefaultMaxBatchLength,
+		Usage:   "Maximum number of requests in one JSON-RPC batch",
+		EnvVars: []string{"MAX_BATCH_LENGTH"},
+	},
+	&cli.Int64Flag{
+		Name:    "max-batch-size-bytes",
+		Value:   proxy.DefaultMaxBatchSizeBytes,
+		Usage:   "Maximum size of the JSON-RPC batch request body",
+		EnvVars: []string{"MAX_BATCH_SIZE_BYTES"},
+	},
 	&cli.IntFlag{
 		Name:    "connections-per-peer",
 		Value:   10,
-		Usage:   "Number of parallel connections for each peer and archival RPC",
+		Usage:   "Number of parallel connections for each archival RPC and maximum number for each peer",
 		EnvVars: []string{"CONN_PER_PEER"},
 	},
+	&cli.IntFlag{
+		Name:    "min-connections-per-peer",
+		Value:   0,
+		Usage:   "Minimum number of parallel connections for each peer, if set connections are adjusted up to connections-per-peer by queue length and RTT (0 to always use connections-per-peer)",
+		EnvVars: []string{"MIN_CONN_PER_PEER"},
+	},
+	&cli.StringFlag{
+		Name:    "peer-transport",
+		Value:   proxy.PeerTransportHTTP1,
+		Usage:   "transport used to share orders with peers, http1, http2 (requests of all connections-per-peer workers are multiplexed over one connection) or stream (orders are pipelined over one signed connection, http1 is used as fallback)",
+		EnvVars: []string{"PEER_TRANSPORT"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "peer-transport-override",
+		Usage:   "transport of the peer as name=transport, e.g. builder-eu=http2",
+		EnvVars: []string{"PEER_TRANSPORT_OVERRIDES"},
+	},
 	&cli.IntFlag{
 		Name:    flagMaxUserRPS,
 		Value:   0,
 		Usage:   "Maximum number of unique user requests per second (set 0 to disable)",
 		EnvVars: []string{"MAX_USER_RPS"},
 	},
+	&cli.StringFlag{
+		Name:    "replay-protection",
+		Value:   proxy.ReplayProtectionOptional,
+		Usage:   "replay protection of signed system requests: disabled, optional (accept legacy senders) or required",
+		EnvVars: []string{"REPLAY_PROTECTION"},
+	},
+	&cli.DurationFlag{
+		Name:    "replay-protection-max-clock-skew",
+		Value:   proxy.DefaultReplayProtectionMaxClockSkew,
+		Usage:   "maximum allowed difference between system request timestamp and local time",
+		EnvVars: []string{"REPLAY_PROTECTION_MAX_CLOCK_SKEW"},
+	},
+	&cli.StringFlag{
+		Name:    "replay-protection-file",
+		Value:   "",
+		Usage:   "file to keep signers that upgraded to replay protection between restarts (in memory only if empty)",
+		EnvVars: []string{"REPLAY_PROTECTION_FILE"},
+	},
+	&cli.StringSliceFlag{
+		Name:    "health-hard-dependencies",
+		Value:   cli.NewStringSlice(proxy.DefaultHealthHardDependencies...),
+		Usage:   "dependencies that make $metrics-addr/readyz fail (builderhub, archive, local_builder, eth_rpc, signer_registration), others are only reported",
+		EnvVars: []string{"HEALTH_HARD_DEPENDENCIES"},
+	},
 
 	// Logging, metrics and debug
 	&cli.StringFlag{
@@ -110,6 +266,29 @@ var flags = []cli.Flag{
 		Usage:   "address to listen on for Prometheus metrics (metrics are served on $metrics-addr/metrics)",
 		EnvVars: []string{"METRICS_ADDR"},
 	},
+	&cli.Float64SliceFlag{
+		Name:    "metrics-histogram-buckets",
+		Usage:   "upper bounds (in milliseconds) of latency histogram buckets, if not set VictoriaMetrics histograms with vmrange buckets are exported",
+		EnvVars: []string{"METRICS_HISTOGRAM_BUCKETS"},
+	},
+	&cli.BoolFlag{
+		Name:    "metrics-legacy-summaries",
+		Value:   true,
+		Usage:   "also export latency metrics as summaries with the old metric names",
+		EnvVars: []string{"METRICS_LEGACY_SUMMARIES"},
+	},
+	&cli.StringFlag{
+		Name:    "admin-token",
+		Value:   "",
+		Usage:   "bearer token for the admin API (served on $metrics-addr/admin/*), admin API is disabled if empty",
+		EnvVars: []string{"ADMIN_TOKEN"},
+	},
+	&cli.StringFlag{
+		Name:    "config-file",
+		Value:   "",
+		Usage:   "JSON file with reloadable config (logLevel, staticPeers, rateLimits, signers), reloaded on SIGHUP or with admin API",
+		EnvVars: []string{"CONFIG_FILE"},
+	},
 	&cli.BoolFlag{
 		Name:    "log-json",
 		Value:   false,
@@ -134,6 +313,11 @@ var flags = []cli.Flag{
 		Usage:   "add 'service' tag to logs",
 		EnvVars: []string{"LOG_SERVICE"},
 	},
+	&cli.StringSliceFlag{
+		Name:    "log-redact-allowlist",
+		Usage:   "log attribute keys that are never redacted (by default PEM blocks and long hex strings are truncated)",
+		EnvVars: []string{"LOG_REDACT_ALLOWLIST"},
+	},
 	&cli.BoolFlag{
 		Name:    "pprof",
 		Value:   false,
@@ -161,12 +345,16 @@ func runMain(cCtx *cli.Context) error {
 	logDebug := cCtx.Bool("log-debug")
 	logUID := cCtx.Bool("log-uid")
 	logService := cCtx.String("log-service")
+	logRedactAllowlist := cCtx.StringSlice("log-redact-allowlist")
 
+	logLevel := new(slog.LevelVar)
 	log := common.SetupLogger(&common.LoggingOpts{
-		Debug:   logDebug,
-		JSON:    logJSON,
-		Service: logService,
-		Version: common.Version,
+		Debug:           logDebug,
+		JSON:            logJSON,
+		Service:         logService,
+		Version:         common.Version,
+		RedactAllowlist: logRedactAllowlist,
+		LevelVar:        logLevel,
 	})
 
 	if logUID {
@@ -174,37 +362,14 @@ func runMain(cCtx *cli.Context) error {
 		log = log.With("uid", id.String())
 	}
 
+	proxy.ConfigureLatencyMetrics(proxy.LatencyMetricsConfig{
+		HistogramBuckets: cCtx.Float64Slice("metrics-histogram-buckets"),
+		LegacySummaries:  cCtx.Bool("metrics-legacy-summaries"),
+	})
+
 	exit := make(chan os.Signal, 1)
 	signal.Notify(exit, os.Interrupt, syscall.SIGTERM)
 
-	// metrics server
-	go func() {
-		metricsAddr := cCtx.String("metrics-addr")
-		usePprof := cCtx.Bool("pprof")
-		metricsMux := http.NewServeMux()
-		metricsMux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-			metrics.WritePrometheus(w, true)
-		})
-		if usePprof {
-			metricsMux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
-			metricsMux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
-			metricsMux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
-			metricsMux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
-			metricsMux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
-		}
-
-		metricsServer := &http.Server{
-			Addr:              metricsAddr,
-			ReadHeaderTimeout: 5 * time.Second,
-			Handler:           metricsMux,
-		}
-
-		err := metricsServer.ListenAndServe()
-		if err != nil {
-			log.Error("Failed to start metrics server", "err", err)
-		}
-	}()
-
 	builderEndpoint := cCtx.String("builder-endpoint")
 	rpcEndpoint := cCtx.String("rpc-endpoint")
 	builderReadyEndpoint := cCtx.String("builder-ready-endpoint")
@@ -214,9 +379,50 @@ func runMain(cCtx *cli.Context) error {
 	flashbotsSignerStr := cCtx.String("flashbots-orderflow-signer-address")
 	flashbotsSignerAddress := eth.HexToAddress(flashbotsSignerStr)
 	maxRequestBodySizeBytes := cCtx.Int64("max-request-body-size-bytes")
+	maxBatchLength := cCtx.Int("max-batch-length")
+	maxBatchSizeBytes := cCtx.Int64("max-batch-size-bytes")
 	connectionsPerPeer := cCtx.Int("connections-per-peer")
+	minConnectionsPerPeer := cCtx.Int("min-connections-per-peer")
 	archiveWorkerCount := cCtx.Int("archive-worker-count")
 	maxUserRPS := cCtx.Int(flagMaxUserRPS)
+	replayProtectionMode := cCtx.String("replay-protection")
+	replayProtectionMaxClockSkew := cCtx.Duration("replay-protection-max-clock-skew")
+	healthHardDependencies := cCtx.StringSlice("health-hard-dependencies")
+
+	var localBuilders []proxy.LocalBuilderConfig
+	for _, spec := range cCtx.StringSlice("local-builder") {
+		localBuilder, err := proxy.ParseLocalBuilderConfig(spec)
+		if err != nil {
+			log.Error("Failed to parse local builder", "spec", spec, "err", err)
+			return err
+		}
+		localBuilders = append(localBuilders, localBuilder)
+	}
+
+	peerTransportOverrides := make(map[string]string)
+	for _, spec := range cCtx.StringSlice("peer-transport-override") {
+		name, transport, err := proxy.ParsePeerTransportOverride(spec)
+		if err != nil {
+			log.Error("Failed to parse peer transport override", "spec", spec, "err", err)
+			return err
+		}
+		peerTransportOverrides[name] = transport
+	}
+
+	var tlsCertificate *proxy.TLSCertificate
+	if certPath := cCtx.String("tls-cert-path"); certPath != "" {
+		var err error
+		tlsCertificate, err = proxy.NewTLSCertificate(log, proxy.TLSCertificateConfig{
+			CertPath:     certPath,
+			KeyPath:      cCtx.String("tls-key-path"),
+			CertDuration: cCtx.Duration("cert-duration"),
+			CertHosts:    cCtx.StringSlice("cert-hosts"),
+		})
+		if err != nil {
+			log.Error("Failed to load TLS certificate", "err", err)
+			return err
+		}
+	}
 
 	proxyConfig := &proxy.ReceiverProxyConfig{
 		ReceiverProxyConstantConfig: proxy.ReceiverProxyConstantConfig{
@@ -228,11 +434,41 @@ func runMain(cCtx *cli.Context) error {
 		ArchiveEndpoint:          archiveEndpoint,
 		ArchiveConnections:       connectionsPerPeer,
 		BuilderReadyEndpoint:     builderReadyEndpoint,
+		LocalBuilders:            localBuilders,
 		EthRPC:                   rpcEndpoint,
 		MaxRequestBodySizeBytes:  maxRequestBodySizeBytes,
+		MaxBatchLength:           maxBatchLength,
+		MaxBatchSizeBytes:        maxBatchSizeBytes,
 		ConnectionsPerPeer:       connectionsPerPeer,
+		MinConnectionsPerPeer:    minConnectionsPerPeer,
 		MaxUserRPS:               maxUserRPS,
 		ArchiveWorkerCount:       archiveWorkerCount,
+		PeerTransport:            cCtx.String("peer-transport"),
+		PeerTransportOverrides:   peerTransportOverrides,
+
+		ReplayProtectionMode:         replayProtectionMode,
+		ReplayProtectionMaxClockSkew: replayProtectionMaxClockSkew,
+		ReplayProtectionFile:         cCtx.String("replay-protection-file"),
+
+		HealthHardDependencies: healthHardDependencies,
+
+		TxValidation: proxy.TxValidationConfig{
+			ChainID:              cCtx.Uint64("chain-id"),
+			MaxCalldataSizeBytes: cCtx.Int("max-tx-calldata-size-bytes"),
+			MaxAuthorizations:    cCtx.Int("max-tx-authorizations"),
+		},
+		MaxBlocksAhead:   cCtx.Uint64("max-blocks-ahead"),
+		OrderLedgerSize:  cCtx.Int("order-ledger-size"),
+		OrderLedgerFile:  cCtx.String("order-ledger-file"),
+		DedupeWindow:     cCtx.Duration("dedupe-window"),
+		DedupeMaxEntries: cCtx.Int("dedupe-max-entries"),
+		DedupeFile:       cCtx.String("dedupe-file"),
+		TLS: proxy.ReceiverTLSConfig{
+			Certificate:    tlsCertificate,
+			UserServer:     cCtx.Bool("user-tls"),
+			SystemServer:   cCtx.Bool("system-tls"),
+			CertListenAddr: cCtx.String("cert-listen-addr"),
+		},
 	}
 
 	instance, err := proxy.NewReceiverProxy(*proxyConfig)
@@ -241,6 +477,59 @@ func runMain(cCtx *cli.Context) error {
 		return err
 	}
 
+	reloader := proxy.NewConfigReloader(log, cCtx.String("config-file"), logLevel, instance)
+	if cCtx.String("config-file") != "" {
+		err = reloader.Reload()
+		if err != nil {
+			return err
+		}
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			_ = reloader.Reload()
+		}
+	}()
+
+	// metrics server
+	go func() {
+		metricsAddr := cCtx.String("metrics-addr")
+		usePprof := cCtx.Bool("pprof")
+		metricsMux := http.NewServeMux()
+		metricsMux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			metrics.WritePrometheus(w, true)
+		})
+		metricsMux.HandleFunc("/healthz", instance.HealthzHandler)
+		metricsMux.HandleFunc("/readyz", instance.ReadyzHandler)
+		if adminToken := cCtx.String("admin-token"); adminToken != "" {
+			adminHandler, err := proxy.NewAdminHandler(log, adminToken, instance, logLevel, reloader)
+			if err != nil {
+				log.Error("Failed to create admin API handler", "err", err)
+			} else {
+				metricsMux.Handle(proxy.AdminAPIPrefix+"/", adminHandler)
+			}
+		}
+		if usePprof {
+			metricsMux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+			metricsMux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+			metricsMux.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+			metricsMux.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+			metricsMux.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+		}
+
+		metricsServer := &http.Server{
+			Addr:              metricsAddr,
+			ReadHeaderTimeout: 5 * time.Second,
+			Handler:           metricsMux,
+		}
+
+		err := metricsServer.ListenAndServe()
+		if err != nil {
+			log.Error("Failed to start metrics server", "err", err)
+		}
+	}()
+
 	registerContext, registerCancel := context.WithCancel(context.Background())
 	go func() {
 		select {
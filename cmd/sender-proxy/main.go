@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/pprof"
 	"os"
@@ -43,6 +44,35 @@ var flags []cli.Flag = []cli.Flag{
 		Usage:   "Maximum size of the request body, if 0 default will be used",
 		EnvVars: []string{"MAX_REQUEST_BODY_SIZE_BYTES"},
 	},
+	&cli.Uint64Flag{
+		Name:    "chain-id",
+		Usage:   "Chain ID of accepted transactions (set 0 to disable the check)",
+		EnvVars: []string{"CHAIN_ID"},
+	},
+	&cli.IntFlag{
+		Name:    "max-tx-calldata-size-bytes",
+		Value:   proxy.DefaultTxMaxCalldataSizeBytes,
+		Usage:   "Maximum size of the transaction calldata",
+		EnvVars: []string{"MAX_TX_CALLDATA_SIZE_BYTES"},
+	},
+	&cli.IntFlag{
+		Name:    "max-tx-authorizations",
+		Value:   proxy.DefaultTxMaxAuthorizations,
+		Usage:   "Maximum number of EIP-7702 authorizations in a transaction",
+		EnvVars: []string{"MAX_TX_AUTHORIZATIONS"},
+	},
+	&cli.Uint64Flag{
+		Name:    "max-blocks-ahead",
+		Value:   proxy.DefaultMaxBlocksAhead,
+		Usage:   "Reject orders targeting blocks further than this from the current head",
+		EnvVars: []string{"MAX_BLOCKS_AHEAD"},
+	},
+	&cli.StringFlag{
+		Name:    "rpc-endpoint",
+		Value:   "",
+		Usage:   "address of the node RPC that supports eth_blockNumber, used to reject stale orders (disabled if empty)",
+		EnvVars: []string{"RPC_ENDPOINT"},
+	},
 	&cli.IntFlag{
 		Name:    "connections-per-peer",
 		Value:   10,
@@ -57,6 +87,29 @@ var flags []cli.Flag = []cli.Flag{
 		Usage:   "address to listen on for Prometheus metrics (metrics are served on $metrics-addr/metrics)",
 		EnvVars: []string{"METRICS_ADDR"},
 	},
+	&cli.Float64SliceFlag{
+		Name:    "metrics-histogram-buckets",
+		Usage:   "upper bounds (in milliseconds) of latency histogram buckets, if not set VictoriaMetrics histograms with vmrange buckets are exported",
+		EnvVars: []string{"METRICS_HISTOGRAM_BUCKETS"},
+	},
+	&cli.BoolFlag{
+		Name:    "metrics-legacy-summaries",
+		Value:   true,
+		Usage:   "also export latency metrics as summaries with the old metric names",
+		EnvVars: []string{"METRICS_LEGACY_SUMMARIES"},
+	},
+	&cli.StringFlag{
+		Name:    "admin-token",
+		Value:   "",
+		Usage:   "bearer token for the admin API (served on $metrics-addr/admin/*), admin API is disabled if empty",
+		EnvVars: []string{"ADMIN_TOKEN"},
+	},
+	&cli.StringFlag{
+		Name:    "config-file",
+		Value:   "",
+		Usage:   "JSON file with reloadable config (logLevel, staticPeers), reloaded on SIGHUP or with admin API",
+		EnvVars: []string{"CONFIG_FILE"},
+	},
 	&cli.BoolFlag{
 		Name:    "log-json",
 		Value:   false,
@@ -81,6 +134,11 @@ var flags []cli.Flag = []cli.Flag{
 		Usage:   "add 'service' tag to logs",
 		EnvVars: []string{"LOG_SERVICE"},
 	},
+	&cli.StringSliceFlag{
+		Name:    "log-redact-allowlist",
+		Usage:   "log attribute keys that are never redacted (by default PEM blocks and long hex strings are truncated)",
+		EnvVars: []string{"LOG_REDACT_ALLOWLIST"},
+	},
 	&cli.BoolFlag{
 		Name:    "pprof",
 		Value:   false,
@@ -99,12 +157,16 @@ func main() {
 			logDebug := cCtx.Bool("log-debug")
 			logUID := cCtx.Bool("log-uid")
 			logService := cCtx.String("log-service")
+			logRedactAllowlist := cCtx.StringSlice("log-redact-allowlist")
 
+			logLevel := new(slog.LevelVar)
 			log := common.SetupLogger(&common.LoggingOpts{
-				Debug:   logDebug,
-				JSON:    logJSON,
-				Service: logService,
-				Version: common.Version,
+				Debug:           logDebug,
+				JSON:            logJSON,
+				Service:         logService,
+				Version:         common.Version,
+				RedactAllowlist: logRedactAllowlist,
+				LevelVar:        logLevel,
 			})
 
 			if logUID {
@@ -112,6 +174,11 @@ func main() {
 				log = log.With("uid", id.String())
 			}
 
+			proxy.ConfigureLatencyMetrics(proxy.LatencyMetricsConfig{
+				HistogramBuckets: cCtx.Float64Slice("metrics-histogram-buckets"),
+				LegacySummaries:  cCtx.Bool("metrics-legacy-summaries"),
+			})
+
 			exit := make(chan os.Signal, 1)
 			signal.Notify(exit, os.Interrupt, syscall.SIGTERM)
 
@@ -134,6 +201,14 @@ func main() {
 				BuilderConfigHubEndpoint: builderConfigHubEndpoint,
 				MaxRequestBodySizeBytes:  maxRequestBodySizeBytes,
 				ConnectionsPerPeer:       connectionsPerPeer,
+
+				TxValidation: proxy.TxValidationConfig{
+					ChainID:              cCtx.Uint64("chain-id"),
+					MaxCalldataSizeBytes: cCtx.Int("max-tx-calldata-size-bytes"),
+					MaxAuthorizations:    cCtx.Int("max-tx-authorizations"),
+				},
+				EthRPC:         cCtx.String("rpc-endpoint"),
+				MaxBlocksAhead: cCtx.Uint64("max-blocks-ahead"),
 			}
 
 			instance, err := proxy.NewSenderProxy(*proxyConfig)
@@ -142,6 +217,21 @@ func main() {
 				return err
 			}
 
+			reloader := proxy.NewConfigReloader(log, cCtx.String("config-file"), logLevel, instance)
+			if cCtx.String("config-file") != "" {
+				err = reloader.Reload()
+				if err != nil {
+					return err
+				}
+			}
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			go func() {
+				for range hup {
+					_ = reloader.Reload()
+				}
+			}()
+
 			listenAddr := cCtx.String("listen-address")
 			servers, err := proxy.StartSenderServers(instance, listenAddr)
 			if err != nil {
@@ -166,6 +256,14 @@ func main() {
 					}
 					w.WriteHeader(http.StatusOK)
 				})
+				if adminToken := cCtx.String("admin-token"); adminToken != "" {
+					adminHandler, err := proxy.NewAdminHandler(log, adminToken, instance, logLevel, reloader)
+					if err != nil {
+						log.Error("Failed to create admin API handler", "err", err)
+					} else {
+						metricsMux.Handle(proxy.AdminAPIPrefix+"/", adminHandler)
+					}
+				}
 				if usePprof {
 					metricsMux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
 					metricsMux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
@@ -56,6 +56,9 @@ func main() {
 		Name:  "test-tx-sender",
 		Usage: "send test transactions",
 		Flags: flags,
+		Commands: []*cli.Command{
+			transportBenchmarkCommand,
+		},
 		Action: func(cCtx *cli.Context) error {
 			orderflowSigner, err := signature.NewRandomSigner()
 			if err != nil {
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flashbots/go-utils/signature"
+	utils_tls "github.com/flashbots/go-utils/tls"
+	"github.com/flashbots/tdx-orderflow-proxy/proxy"
+	"github.com/urfave/cli/v2" // imports as package "cli"
+	"golang.org/x/net/http2"
+)
+
+var transportBenchmarkCommand = &cli.Command{
+	Name:  "transport-benchmark",
+	Usage: "compare peer transports against a local TLS server with simulated round trip time",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "rtt",
+			Value: time.Millisecond * 80,
+			Usage: "simulated round trip time between peers",
+		},
+		&cli.StringSliceFlag{
+			Name:  "transports",
+			Value: cli.NewStringSlice(proxy.PeerTransportHTTP1, proxy.PeerTransportHTTP2),
+			Usage: "transports to compare",
+		},
+		&cli.IntFlag{
+			Name:  "workers",
+			Value: 10,
+			Usage: "number of peer workers (connections-per-peer)",
+		},
+		&cli.IntFlag{
+			Name:  "requests",
+			Value: 5000,
+			Usage: "number of requests sent with each transport",
+		},
+		&cli.IntFlag{
+			Name:  "request-size",
+			Value: 2000,
+			Usage: "size of the request body in bytes",
+		},
+	},
+	Action: func(cCtx *cli.Context) error {
+		return runTransportBenchmark(cCtx.StringSlice("transports"), cCtx.Duration("rtt"), cCtx.Int("workers"), cCtx.Int("requests"), cCtx.Int("request-size"))
+	},
+}
+
+func runTransportBenchmark(transports []string, rtt time.Duration, workers, requests, requestSize int) error {
+	certPEM, keyPEM, err := utils_tls.GenerateTLS(time.Hour, []string{"127.0.0.1", "localhost"})
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	delayed := &delayedListener{Listener: listener, delay: rtt}
+
+	//nolint:gosec
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":null}`))
+		}),
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	err = http2.ConfigureServer(server, &http2.Server{MaxConcurrentStreams: proxy.HTTP2DefaultMaxConcurrentStreams})
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := server.ServeTLS(delayed, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Failed while listening to server", "error", err)
+		}
+	}()
+	defer server.Close()
+
+	signer, err := signature.NewRandomSigner()
+	if err != nil {
+		return err
+	}
+	body := []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":0,"method":"eth_sendRawTransaction","params":["0x%s"]}`, strings.Repeat("00", requestSize/2)))
+	signatureHeader, err := signer.Create(body)
+	if err != nil {
+		return err
+	}
+	request := proxy.PeerRequest{Body: body, SignatureHeader: signatureHeader}
+	endpoint := "https://" + listener.Addr().String()
+
+	slog.Info("Transport benchmark started", "rtt", rtt, "workers", workers, "requests", requests, "requestSize", len(body))
+	for _, transportName := range transports {
+		transport, err := proxy.NewPeerTransport(transportName, endpoint, certPEM, workers)
+		if err != nil {
+			return err
+		}
+		acceptedBefore := delayed.accepted.Load()
+		err = benchmarkTransport(transportName, transport, request, workers, requests)
+		transport.Close()
+		if err != nil {
+			return err
+		}
+		slog.Info("Connections opened", "transport", transportName, "connections", delayed.accepted.Load()-acceptedBefore)
+	}
+	return nil
+}
+
+func benchmarkTransport(name string, transport proxy.PeerTransport, request proxy.PeerRequest, workers, requests int) error {
+	// connections are opened before measuring so the results show steady state latency
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = transport.Send(request)
+		}()
+	}
+	wg.Wait()
+
+	var (
+		mu     sync.Mutex
+		values = make([]float64, 0, requests)
+		failed int
+	)
+	countPerWorker := requests / workers
+	start := time.Now()
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range countPerWorker {
+				sentAt := time.Now()
+				err := transport.Send(request)
+				diff := float64(time.Since(sentAt).Microseconds()) / 1000.0
+				mu.Lock()
+				if err != nil {
+					failed += 1
+				} else {
+					values = append(values, diff)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	sendingTime := time.Since(start)
+
+	if len(values) == 0 {
+		return fmt.Errorf("all requests of %s transport failed", name)
+	}
+	slices.Sort(values)
+	p50 := values[(len(values)*50)/100]
+	p99 := values[(len(values)*99)/100]
+	p100 := values[len(values)-1]
+	rps := float64(len(values)) / sendingTime.Seconds()
+
+	slog.Info("Results", "transport", name, "p50", p50, "p99", p99, "p100", p100, "totalReq", len(values), "failed", failed, "averageRPS", rps)
+	return nil
+}
+
+// delayedListener adds latency to the data written by the server, so every request-response round trip
+// and every handshake flight is delayed as if the peer was far away
+type delayedListener struct {
+	net.Listener
+	delay    time.Duration
+	accepted atomic.Int64
+}
+
+func (l *delayedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.accepted.Add(1)
+	c := &delayedConn{
+		Conn:   conn,
+		delay:  l.delay,
+		writes: make(chan delayedWrite, 1024),
+		done:   make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+type delayedWrite struct {
+	data []byte
+	at   time.Time
+}
+
+// delayedConn delivers written data after the delay without limiting throughput
+type delayedConn struct {
+	net.Conn
+	delay     time.Duration
+	writes    chan delayedWrite
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *delayedConn) Write(b []byte) (int, error) {
+	data := append([]byte(nil), b...)
+	select {
+	case c.writes <- delayedWrite{data: data, at: time.Now().Add(c.delay)}:
+		return len(b), nil
+	case <-c.done:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *delayedConn) run() {
+	for {
+		select {
+		case w := <-c.writes:
+			time.Sleep(time.Until(w.at))
+			_, err := c.Conn.Write(w.data)
+			if err != nil {
+				_ = c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *delayedConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
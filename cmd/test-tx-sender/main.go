@@ -11,6 +11,7 @@ import (
 	"github.com/flashbots/go-utils/rpcclient"
 	"github.com/flashbots/go-utils/rpctypes"
 	"github.com/flashbots/go-utils/signature"
+	"github.com/flashbots/tdx-orderflow-proxy/common"
 	"github.com/flashbots/tdx-orderflow-proxy/proxy"
 	"github.com/google/uuid"
 	"github.com/urfave/cli/v2" // imports as package "cli"
@@ -36,13 +37,14 @@ var flags []cli.Flag = []cli.Flag{
 		Usage:   "address of the node RPC that supports eth_blockNumber",
 		EnvVars: []string{"RPC_ENDPOINT"},
 	},
+	&cli.Uint64Flag{
+		Name:    "chain-id",
+		Value:   1,
+		Usage:   "chain id of the test transaction",
+		EnvVars: []string{"CHAIN_ID"},
+	},
 }
 
-// test tx
-
-// tx with hash 0x40614141bf0c512efcaa2e742f79ce5e654c6658d5de77ca4f1154b5b52ae13a
-var testTx = hexutil.MustDecode("0x1234")
-
 func main() {
 	app := &cli.App{
 		Name:  "test-tx-sender",
@@ -71,6 +73,11 @@ func main() {
 			}
 			slog.Info("Current block number", "block", block)
 
+			testTx, err := common.NewTestTx(cCtx.Uint64("chain-id"), 0)
+			if err != nil {
+				return err
+			}
+
 			// send eth_sendRawTransactions
 			resp, err := client.Call(context.Background(), "eth_sendRawTransaction", hexutil.Bytes(testTx))
 			if err != nil {
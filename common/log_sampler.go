@@ -0,0 +1,132 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// LogSampler collapses repeated log messages. The first message for the key is logged immediately,
+// repeats within the interval are only counted and logged periodically as a summary with the count.
+// A nil sampler logs every message.
+type LogSampler struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	entries map[logSamplerKey]*logSamplerEntry
+
+	stop chan struct{}
+}
+
+type logSamplerKey struct {
+	key   string
+	level slog.Level
+	msg   string
+}
+
+type logSamplerEntry struct {
+	logger     *slog.Logger
+	windowEnds time.Time
+	repeated   int64
+}
+
+func NewLogSampler(interval time.Duration) *LogSampler {
+	s := &LogSampler{
+		interval: interval,
+		entries:  make(map[logSamplerKey]*logSamplerEntry),
+		stop:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *LogSampler) Stop() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+}
+
+func (s *LogSampler) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			s.Flush()
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
+
+// Flush logs summaries for messages that were repeated and forgets messages that were not repeated for the whole interval
+func (s *LogSampler) Flush() {
+	now := time.Now()
+	type summary struct {
+		key   logSamplerKey
+		entry logSamplerEntry
+	}
+	var summaries []summary
+
+	s.mu.Lock()
+	for key, entry := range s.entries {
+		if entry.repeated > 0 {
+			summaries = append(summaries, summary{key, *entry})
+			entry.repeated = 0
+			entry.windowEnds = now.Add(s.interval)
+		} else if now.After(entry.windowEnds) {
+			delete(s.entries, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sum := range summaries {
+		sum.entry.logger.Log(context.Background(), sum.key.level, sum.key.msg,
+			slog.String("sampleKey", sum.key.key),
+			slog.Int64("repeated", sum.entry.repeated),
+			slog.Duration("sampleInterval", s.interval),
+		)
+	}
+}
+
+// Log logs the message if it was not logged for the given key, level and message during the current interval
+func (s *LogSampler) Log(logger *slog.Logger, level slog.Level, key, msg string, args ...any) {
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	if s == nil {
+		logger.Log(context.Background(), level, msg, args...)
+		return
+	}
+
+	k := logSamplerKey{key: key, level: level, msg: msg}
+	s.mu.Lock()
+	entry, ok := s.entries[k]
+	if ok {
+		entry.repeated += 1
+		s.mu.Unlock()
+		return
+	}
+	s.entries[k] = &logSamplerEntry{
+		logger:     logger,
+		windowEnds: time.Now().Add(s.interval),
+	}
+	s.mu.Unlock()
+
+	logger.Log(context.Background(), level, msg, args...)
+}
+
+func (s *LogSampler) Error(logger *slog.Logger, key, msg string, args ...any) {
+	s.Log(logger, slog.LevelError, key, msg, args...)
+}
+
+func (s *LogSampler) Warn(logger *slog.Logger, key, msg string, args ...any) {
+	s.Log(logger, slog.LevelWarn, key, msg, args...)
+}
+
+func (s *LogSampler) Debug(logger *slog.Logger, key, msg string, args ...any) {
+	s.Log(logger, slog.LevelDebug, key, msg, args...)
+}
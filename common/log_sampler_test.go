@@ -0,0 +1,69 @@
+package common
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogSampler(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, nil))
+
+	sampler := NewLogSampler(time.Hour)
+	defer sampler.Stop()
+
+	for range 1000 {
+		sampler.Error(log, "peer-1", "Peer is stalling on requests")
+		sampler.Error(log, "peer-2", "Peer is stalling on requests")
+	}
+	sampler.Debug(log, "peer-1", "Not enabled")
+	require.Equal(t, 2, strings.Count(buf.String(), "\n"))
+
+	sampler.Flush()
+	out := buf.String()
+	require.Equal(t, 4, strings.Count(out, "\n"))
+	require.Contains(t, out, "sampleKey=peer-1 repeated=999")
+	require.Contains(t, out, "sampleKey=peer-2 repeated=999")
+	require.NotContains(t, out, "Not enabled")
+
+	// nothing was repeated since the last flush
+	sampler.Flush()
+	require.Equal(t, out, buf.String())
+}
+
+func TestLogSamplerMessages(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, nil))
+
+	sampler := NewLogSampler(time.Hour)
+	defer sampler.Stop()
+
+	// different messages and levels with the same key are not collapsed
+	sampler.Error(log, "peer-1", "Peer is stalling on requests")
+	sampler.Error(log, "peer-1", "Error while sending request to the peer")
+	sampler.Warn(log, "peer-1", "Error while sending request to the peer")
+	sampler.Error(log, "peer-1", "Error while sending request to the peer")
+	out := buf.String()
+	require.Equal(t, 3, strings.Count(out, "\n"))
+	require.Contains(t, out, "Peer is stalling on requests")
+	require.Contains(t, out, "level=WARN msg=\"Error while sending request to the peer\"")
+
+	sampler.Flush()
+	require.Contains(t, buf.String(), "level=ERROR msg=\"Error while sending request to the peer\" sampleKey=peer-1 repeated=1")
+}
+
+func TestLogSamplerNil(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&buf, nil))
+
+	var sampler *LogSampler
+	sampler.Error(log, "peer-1", "Peer is stalling on requests")
+	sampler.Error(log, "peer-1", "Peer is stalling on requests")
+	require.Equal(t, 2, strings.Count(buf.String(), "\n"))
+	sampler.Stop()
+}
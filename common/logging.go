@@ -11,19 +11,30 @@ type LoggingOpts struct {
 	JSON    bool
 	Service string
 	Version string
+	// RedactAllowlist is a list of attribute keys that are logged without redaction
+	RedactAllowlist []string
+	// LevelVar is optional, if set it's used as a log level so it can be changed at runtime
+	LevelVar *slog.LevelVar
 }
 
 func SetupLogger(opts *LoggingOpts) (log *slog.Logger) {
-	logLevel := slog.LevelInfo
+	logLevel := opts.LevelVar
+	if logLevel == nil {
+		logLevel = new(slog.LevelVar)
+	}
 	if opts.Debug {
-		logLevel = slog.LevelDebug
+		logLevel.Set(slog.LevelDebug)
+	} else {
+		logLevel.Set(slog.LevelInfo)
 	}
 
+	var handler slog.Handler
 	if opts.JSON {
-		log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})
 	} else {
-		log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+		handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})
 	}
+	log = slog.New(NewRedactingHandler(handler, opts.RedactAllowlist))
 
 	if opts.Service != "" {
 		log = log.With("service", opts.Service)
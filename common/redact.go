@@ -0,0 +1,138 @@
+package common
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+var (
+	// RedactMaxHexLength is a maximum length of the hex string (including 0x prefix) that is logged as is,
+	// longer strings (raw transactions, calldata) are truncated
+	RedactMaxHexLength = 200
+	// RedactMaxStringLength is a maximum length of any logged string value
+	RedactMaxStringLength = 4096
+
+	redactKeepPrefix = 10
+	redactKeepSuffix = 8
+
+	pemBlockRegexp = regexp.MustCompile(`-----BEGIN ([A-Z0-9 ]+)-----[\s\S]*?(-----END [A-Z0-9 ]+-----|$)`)
+	longHexRegexp  = regexp.MustCompile(`0x[0-9a-fA-F]+`)
+)
+
+// RedactingHandler is a slog.Handler wrapper that removes PEM blocks and truncates long hex strings and
+// long values before passing the record to the next handler.
+// Attributes with keys from allowlist are passed unchanged.
+type RedactingHandler struct {
+	next      slog.Handler
+	allowlist []string
+}
+
+func NewRedactingHandler(next slog.Handler, allowlist []string) *RedactingHandler {
+	return &RedactingHandler{
+		next:      next,
+		allowlist: allowlist,
+	}
+}
+
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, RedactString(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.redactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		redacted = append(redacted, h.redactAttr(attr))
+	}
+	return &RedactingHandler{
+		next:      h.next.WithAttrs(redacted),
+		allowlist: h.allowlist,
+	}
+}
+
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{
+		next:      h.next.WithGroup(name),
+		allowlist: h.allowlist,
+	}
+}
+
+func (h *RedactingHandler) redactAttr(attr slog.Attr) slog.Attr {
+	if slices.Contains(h.allowlist, attr.Key) {
+		return attr
+	}
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, RedactString(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, 0, len(group))
+		for _, groupAttr := range group {
+			redacted = append(redacted, h.redactAttr(groupAttr))
+		}
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindAny:
+		return slog.Attr{Key: attr.Key, Value: redactAny(value)}
+	default:
+		return slog.Attr{Key: attr.Key, Value: value}
+	}
+}
+
+func redactAny(value slog.Value) slog.Value {
+	var str string
+	switch v := value.Any().(type) {
+	case []byte:
+		if len(v) <= RedactMaxHexLength/2 {
+			return value
+		}
+		return slog.StringValue(redactHex("0x" + hex.EncodeToString(v)))
+	case error:
+		str = v.Error()
+	case fmt.Stringer:
+		str = v.String()
+	default:
+		str = fmt.Sprintf("%+v", v)
+	}
+	redacted := RedactString(str)
+	if redacted == str {
+		return value
+	}
+	return slog.StringValue(redacted)
+}
+
+// RedactString replaces PEM blocks and truncates long hex strings and the string itself
+func RedactString(str string) string {
+	if len(str) <= RedactMaxHexLength && len(str) <= RedactMaxStringLength {
+		return str
+	}
+	str = pemBlockRegexp.ReplaceAllStringFunc(str, func(block string) string {
+		match := pemBlockRegexp.FindStringSubmatch(block)
+		return "[REDACTED " + match[1] + ", " + strconv.Itoa(len(block)) + " bytes]"
+	})
+	str = longHexRegexp.ReplaceAllStringFunc(str, redactHex)
+	if len(str) > RedactMaxStringLength {
+		str = str[:RedactMaxStringLength] + "...[TRUNCATED " + strconv.Itoa(len(str)-RedactMaxStringLength) + " bytes]"
+	}
+	return str
+}
+
+func redactHex(str string) string {
+	if len(str) <= RedactMaxHexLength {
+		return str
+	}
+	return str[:redactKeepPrefix] + "..." + str[len(str)-redactKeepSuffix:] + "[" + strconv.Itoa((len(str)-2)/2) + " bytes]"
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testCertPEM = `-----BEGIN CERTIFICATE-----
+MIIBlTCCATugAwIBAgIRAKQwW0mCn0Rn2mP7bn4h3lYwCgYIKoZIzj0EAwIwDzEN
+MAsGA1UEChMEQWNtZTAeFw0yNDEwMjgxMjAwMDBaFw0yNTEwMjgxMjAwMDBaMA8x
+DTALBgNVBAoTBEFjbWUwWTATBgcqhkjOPQIBBggqhkjOPQMBBwNCAAQx4G2RmnXK
+-----END CERTIFICATE-----`
+
+func TestRedactingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(NewRedactingHandler(slog.NewTextHandler(&buf, nil), []string{"allowed"}))
+
+	longHex := "0x" + strings.Repeat("ab", 500)
+
+	log.Info("test",
+		slog.String("cert", testCertPEM),
+		slog.String("tx", longHex),
+		slog.String("hash", "0x40614141bf0c512efcaa2e742f79ce5e654c6658d5de77ca4f1154b5b52ae13a"),
+		slog.Any("error", errors.New("failed to process "+longHex)),
+		slog.Any("raw", []byte(strings.Repeat("a", 1000))),
+		slog.String("allowed", longHex),
+	)
+
+	out := buf.String()
+	require.NotContains(t, out, "MIIBlTCCATugAwIBAgIRAKQwW0mCn0Rn2mP7bn4h3lYwCgYIKoZIzj0EAwIwDzEN")
+	require.Contains(t, out, "cert=\"[REDACTED CERTIFICATE")
+	require.Contains(t, out, `tx="0xabababab...abababab[500 bytes]"`)
+	require.Contains(t, out, "hash=0x40614141bf0c512efcaa2e742f79ce5e654c6658d5de77ca4f1154b5b52ae13a")
+	require.Contains(t, out, "error=\"failed to process 0xabababab...abababab[500 bytes]\"")
+	require.Contains(t, out, `raw="0x61616161...61616161[1000 bytes]"`)
+	require.Contains(t, out, "allowed="+longHex)
+}
@@ -0,0 +1,35 @@
+package common
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// testTxPrivateKey is a well known key without funds, it's used only to create valid test txs
+const testTxPrivateKey = "c7589782d55a642c8ced7794ddcb24b62d4ebefbb81001034cb46545ff80e39e"
+
+// NewTestTx creates signed empty transfer to the zero address, it passes proxy validation but can't be included
+func NewTestTx(chainID, nonce uint64) (hexutil.Bytes, error) {
+	privateKey, err := crypto.HexToECDSA(testTxPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	chainIDInt := new(big.Int).SetUint64(chainID)
+	tx, err := types.SignNewTx(privateKey, types.LatestSignerForChainID(chainIDInt), &types.DynamicFeeTx{
+		ChainID:   chainIDInt,
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(1),
+		Gas:       21000,
+		To:        &common.Address{},
+		Value:     big.NewInt(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx.MarshalBinary()
+}
@@ -0,0 +1,307 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/goccy/go-json"
+)
+
+const AdminAPIPrefix = "/admin"
+
+var (
+	errAdminNotSupported = errors.New("not supported by this proxy")
+	errAdminUnauthorized = errors.New("unauthorized")
+	errAdminEmptyToken   = errors.New("admin API token is empty")
+	errAdminUnknownPeer  = errors.New("unknown peer")
+)
+
+// AdminPeerInfo is a state of the peer returned by the admin API
+type AdminPeerInfo struct {
+	Name   string           `json:"name"`
+	Config ConfighubBuilder `json:"config"`
+	// Transport is PeerTransportHTTP1, PeerTransportHTTP2 or PeerTransportStream
+	Transport string           `json:"transport"`
+	Disabled  bool             `json:"disabled"`
+	Health    DependencyStatus `json:"health"`
+
+	QueueLength               int     `json:"queueLength"`
+	QueueCapacity             int     `json:"queueCapacity"`
+	QueueOldestItemAgeSeconds float64 `json:"queueOldestItemAgeSeconds"`
+	Workers                   int     `json:"workers"`
+	InflightRequests          int64   `json:"inflightRequests"`
+}
+
+// AdminDedupeCacheStats are stats of the cache used to deduplicate requests
+type AdminDedupeCacheStats struct {
+	Name       string  `json:"name"`
+	Size       int     `json:"size"`
+	Capacity   int     `json:"capacity"`
+	TTLSeconds float64 `json:"ttlSeconds"`
+	Hits       uint64  `json:"hits"`
+	Misses     uint64  `json:"misses"`
+}
+
+// AdminQueueInfo is a state of the internal queue
+type AdminQueueInfo struct {
+	Name                 string  `json:"name"`
+	Length               int     `json:"length"`
+	Capacity             int     `json:"capacity"`
+	OldestItemAgeSeconds float64 `json:"oldestItemAgeSeconds"`
+}
+
+// AdminSignerInfo describes the signer used by the proxy to sign orderflow
+type AdminSignerInfo struct {
+	Address common.Address `json:"address"`
+	// Registered is set only by the receiver proxy that registers the signer on BuilderHub
+	Registered *bool `json:"registered,omitempty"`
+}
+
+// AdminLogLevel is a body of the log level request and response
+type AdminLogLevel struct {
+	Level string `json:"level"`
+}
+
+// AdminError is returned by the admin API on failures
+type AdminError struct {
+	Error string `json:"error"`
+}
+
+// AdminBackend is implemented by the receiver and the sender proxy
+type AdminBackend interface {
+	AdminPeers() []AdminPeerInfo
+	AdminRefreshPeers() error
+	AdminSetPeerDisabled(name string, disabled bool) error
+	AdminFlushArchive(ctx context.Context) error
+	AdminDedupeStats() []AdminDedupeCacheStats
+	AdminQueues() []AdminQueueInfo
+	AdminSigner() AdminSignerInfo
+}
+
+// NewAdminHandler creates handler for the admin API served under AdminAPIPrefix,
+// every request must have `Authorization: Bearer <token>` header. logLevel and reloader can be nil if not supported.
+func NewAdminHandler(log *slog.Logger, token string, backend AdminBackend, logLevel *slog.LevelVar, reloader *ConfigReloader) (http.Handler, error) {
+	if token == "" {
+		return nil, errAdminEmptyToken
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+AdminAPIPrefix+"/peers", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, http.StatusOK, backend.AdminPeers())
+	})
+	mux.HandleFunc("POST "+AdminAPIPrefix+"/peers/refresh", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminResult(w, backend.AdminRefreshPeers())
+	})
+	mux.HandleFunc("POST "+AdminAPIPrefix+"/peers/{name}/disable", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		err := backend.AdminSetPeerDisabled(name, true)
+		if err != nil {
+			writeAdminResult(w, err)
+			return
+		}
+		log.Info("Sharing to peer disabled with admin API", slog.String("peer", name))
+		writeAdminResult(w, nil)
+	})
+	mux.HandleFunc("POST "+AdminAPIPrefix+"/peers/{name}/enable", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		err := backend.AdminSetPeerDisabled(name, false)
+		if err != nil {
+			writeAdminResult(w, err)
+			return
+		}
+		log.Info("Sharing to peer enabled with admin API", slog.String("peer", name))
+		writeAdminResult(w, nil)
+	})
+	mux.HandleFunc("POST "+AdminAPIPrefix+"/archive/flush", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminResult(w, backend.AdminFlushArchive(r.Context()))
+	})
+	mux.HandleFunc("GET "+AdminAPIPrefix+"/dedupe", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, http.StatusOK, backend.AdminDedupeStats())
+	})
+	mux.HandleFunc("GET "+AdminAPIPrefix+"/queues", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, http.StatusOK, backend.AdminQueues())
+	})
+	mux.HandleFunc("GET "+AdminAPIPrefix+"/signer", func(w http.ResponseWriter, r *http.Request) {
+		writeAdminJSON(w, http.StatusOK, backend.AdminSigner())
+	})
+	mux.HandleFunc("GET "+AdminAPIPrefix+"/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		if logLevel == nil {
+			writeAdminResult(w, errAdminNotSupported)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, AdminLogLevel{Level: strings.ToLower(logLevel.Level().String())})
+	})
+	mux.HandleFunc("POST "+AdminAPIPrefix+"/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		if logLevel == nil {
+			writeAdminResult(w, errAdminNotSupported)
+			return
+		}
+		var req AdminLogLevel
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeAdminJSON(w, http.StatusBadRequest, AdminError{Error: err.Error()})
+			return
+		}
+		var level slog.Level
+		err = level.UnmarshalText([]byte(req.Level))
+		if err != nil {
+			writeAdminJSON(w, http.StatusBadRequest, AdminError{Error: err.Error()})
+			return
+		}
+		logLevel.Set(level)
+		log.Info("Log level changed with admin API", slog.String("level", level.String()))
+		writeAdminJSON(w, http.StatusOK, AdminLogLevel{Level: strings.ToLower(level.String())})
+	})
+	mux.HandleFunc("POST "+AdminAPIPrefix+"/config/reload", func(w http.ResponseWriter, r *http.Request) {
+		if reloader == nil {
+			writeAdminResult(w, errAdminNotSupported)
+			return
+		}
+		err := reloader.Reload()
+		if err != nil {
+			writeAdminJSON(w, http.StatusBadRequest, AdminError{Error: err.Error()})
+			return
+		}
+		writeAdminResult(w, nil)
+	})
+
+	expectedAuth := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expectedAuth) != 1 {
+			writeAdminJSON(w, http.StatusUnauthorized, AdminError{Error: errAdminUnauthorized.Error()})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}), nil
+}
+
+func writeAdminResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		writeAdminJSON(w, http.StatusOK, struct{}{})
+	case errors.Is(err, errAdminNotSupported):
+		writeAdminJSON(w, http.StatusNotImplemented, AdminError{Error: err.Error()})
+	case errors.Is(err, errAdminUnknownPeer):
+		writeAdminJSON(w, http.StatusNotFound, AdminError{Error: err.Error()})
+	default:
+		writeAdminJSON(w, http.StatusInternalServerError, AdminError{Error: err.Error()})
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, code int, value any) {
+	res, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(res)
+}
+
+func (prx *ReceiverProxy) AdminPeers() []AdminPeerInfo {
+	return prx.sharing.PeersInfo()
+}
+
+func (prx *ReceiverProxy) AdminRefreshPeers() error {
+	return prx.RequestNewPeers()
+}
+
+func (prx *ReceiverProxy) AdminSetPeerDisabled(name string, disabled bool) error {
+	return prx.sharing.SetPeerDisabled(name, disabled)
+}
+
+func (prx *ReceiverProxy) AdminFlushArchive(ctx context.Context) error {
+	return prx.FlushArchiveQueue(ctx)
+}
+
+func (prx *ReceiverProxy) AdminDedupeStats() []AdminDedupeCacheStats {
+	return []AdminDedupeCacheStats{
+		{
+			Name:       "request_unique_keys",
+			Size:       prx.requestDedupe.Len(),
+			Capacity:   prx.requestDedupe.maxEntries,
+			TTLSeconds: prx.requestDedupe.window.Seconds(),
+			Hits:       prx.requestDedupe.hits.Load(),
+			Misses:     prx.requestDedupe.misses.Load(),
+		},
+		{
+			Name:       "replacement_nonces",
+			Size:       prx.replacementNonceRLU.Len(),
+			Capacity:   replacementNonceSize,
+			TTLSeconds: replacementNonceTTL.Seconds(),
+			Hits:       prx.replacementNonceHits.Load(),
+			Misses:     prx.replacementNonceMisses.Load(),
+		},
+	}
+}
+
+func (prx *ReceiverProxy) AdminQueues() []AdminQueueInfo {
+	queues := []AdminQueueInfo{
+		{Name: "share", Length: len(prx.shareQueue), Capacity: cap(prx.shareQueue)},
+		{Name: "archive", Length: len(prx.archiveQueue), Capacity: cap(prx.archiveQueue)},
+		{Name: "archive_workers", Length: len(prx.archiveWorkersQueue), Capacity: cap(prx.archiveWorkersQueue)},
+	}
+	for _, builder := range prx.localBuilders {
+		queues = append(queues, builder.queue.queueInfo())
+	}
+	return append(queues, peerQueues(prx.sharing.PeersInfo())...)
+}
+
+func (prx *ReceiverProxy) AdminSigner() AdminSignerInfo {
+	registered := prx.signerRegistered.Load()
+	return AdminSignerInfo{Address: prx.OrderflowSigner.Address(), Registered: &registered}
+}
+
+func peerQueues(peers []AdminPeerInfo) []AdminQueueInfo {
+	queues := make([]AdminQueueInfo, 0, len(peers))
+	for _, peer := range peers {
+		queues = append(queues, AdminQueueInfo{
+			Name:                 "peer:" + peer.Name,
+			Length:               peer.QueueLength,
+			Capacity:             peer.QueueCapacity,
+			OldestItemAgeSeconds: peer.QueueOldestItemAgeSeconds,
+		})
+	}
+	return queues
+}
+
+func (prx *SenderProxy) AdminPeers() []AdminPeerInfo {
+	return prx.sharing.PeersInfo()
+}
+
+func (prx *SenderProxy) AdminRefreshPeers() error {
+	select {
+	case prx.PeerUpdateForce <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (prx *SenderProxy) AdminSetPeerDisabled(name string, disabled bool) error {
+	return prx.sharing.SetPeerDisabled(name, disabled)
+}
+
+func (prx *SenderProxy) AdminFlushArchive(context.Context) error {
+	return errAdminNotSupported
+}
+
+func (prx *SenderProxy) AdminDedupeStats() []AdminDedupeCacheStats {
+	return []AdminDedupeCacheStats{}
+}
+
+func (prx *SenderProxy) AdminQueues() []AdminQueueInfo {
+	queues := []AdminQueueInfo{
+		{Name: "share", Length: len(prx.shareQueue), Capacity: cap(prx.shareQueue)},
+	}
+	return append(queues, peerQueues(prx.sharing.PeersInfo())...)
+}
+
+func (prx *SenderProxy) AdminSigner() AdminSignerInfo {
+	return AdminSignerInfo{Address: prx.OrderflowSigner.Address()}
+}
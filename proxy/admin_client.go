@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+var AdminClientTimeout = time.Second * 30
+
+// AdminClient talks to the admin API of the running proxy
+type AdminClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewAdminClient creates client for the admin API, baseURL is the metrics server address e.g. http://127.0.0.1:8090
+func NewAdminClient(baseURL, token string) *AdminClient {
+	return &AdminClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: AdminClientTimeout},
+	}
+}
+
+func (c *AdminClient) Peers(ctx context.Context) ([]AdminPeerInfo, error) {
+	var res []AdminPeerInfo
+	err := c.do(ctx, http.MethodGet, "/peers", nil, &res)
+	return res, err
+}
+
+func (c *AdminClient) RefreshPeers(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/peers/refresh", nil, nil)
+}
+
+func (c *AdminClient) SetPeerDisabled(ctx context.Context, name string, disabled bool) error {
+	action := "enable"
+	if disabled {
+		action = "disable"
+	}
+	return c.do(ctx, http.MethodPost, "/peers/"+url.PathEscape(name)+"/"+action, nil, nil)
+}
+
+func (c *AdminClient) Queues(ctx context.Context) ([]AdminQueueInfo, error) {
+	var res []AdminQueueInfo
+	err := c.do(ctx, http.MethodGet, "/queues", nil, &res)
+	return res, err
+}
+
+func (c *AdminClient) FlushArchive(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/archive/flush", nil, nil)
+}
+
+func (c *AdminClient) DedupeStats(ctx context.Context) ([]AdminDedupeCacheStats, error) {
+	var res []AdminDedupeCacheStats
+	err := c.do(ctx, http.MethodGet, "/dedupe", nil, &res)
+	return res, err
+}
+
+func (c *AdminClient) LogLevel(ctx context.Context) (string, error) {
+	var res AdminLogLevel
+	err := c.do(ctx, http.MethodGet, "/loglevel", nil, &res)
+	return res.Level, err
+}
+
+func (c *AdminClient) SetLogLevel(ctx context.Context, level string) (string, error) {
+	var res AdminLogLevel
+	err := c.do(ctx, http.MethodPost, "/loglevel", AdminLogLevel{Level: level}, &res)
+	return res.Level, err
+}
+
+func (c *AdminClient) Signer(ctx context.Context) (AdminSignerInfo, error) {
+	var res AdminSignerInfo
+	err := c.do(ctx, http.MethodGet, "/signer", nil, &res)
+	return res, err
+}
+
+func (c *AdminClient) ReloadConfig(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/config/reload", nil, nil)
+}
+
+func (c *AdminClient) do(ctx context.Context, method, path string, body, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+AdminAPIPrefix+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var adminErr AdminError
+		if json.Unmarshal(respBody, &adminErr) == nil && adminErr.Error != "" {
+			return fmt.Errorf("admin API returned status %d: %s", resp.StatusCode, adminErr.Error)
+		}
+		return fmt.Errorf("admin API returned status %d", resp.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
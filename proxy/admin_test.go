@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAPI(t *testing.T) {
+	defer func() {
+		proxiesFlushQueue()
+		for {
+			select {
+			case <-time.After(time.Millisecond * 100):
+				expectNoRequest(t, archiveServerRequests)
+				return
+			case <-archiveServerRequests:
+			}
+		}
+	}()
+
+	logLevel := new(slog.LevelVar)
+	handler, err := NewAdminHandler(proxies[0].proxy.Log, "secret", proxies[0].proxy, logLevel, nil)
+	require.NoError(t, err)
+
+	adminRequest := func(method, path, token, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusUnauthorized, adminRequest(http.MethodGet, "/admin/peers", "wrong", "").Code)
+
+	builderHubPeers = nil
+	for i := range proxies {
+		testAddBuilderhubPeer(t, i)
+	}
+	proxiesUpdatePeers(t)
+
+	rr := adminRequest(http.MethodPost, "/admin/peers/proxy:1/disable", "secret", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = adminRequest(http.MethodGet, "/admin/peers", "secret", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var peers []AdminPeerInfo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &peers))
+	require.Len(t, peers, 2)
+	for _, peer := range peers {
+		require.Equal(t, peer.Name == "proxy:1", peer.Disabled)
+		require.Equal(t, ShareWorkerQueueSize, peer.QueueCapacity)
+		require.Equal(t, peer.Name, peer.Config.Name)
+	}
+
+	client, err := RPCClientWithCertAndSigner(proxies[0].localServerEndpoint, proxies[0].PublicCertPEM, flashbotsSigner, 1)
+	require.NoError(t, err)
+	resp, err := client.Call(context.Background(), MevSendBundleMethod, &rpctypes.MevSendBundleArgs{
+		Version:   "v0.1",
+		Inclusion: rpctypes.MevBundleInclusion{BlockNumber: 10},
+		Body:      []rpctypes.MevBundleBody{{Tx: createTestTx(2)}},
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	expectRequest(t, proxies[0].localBuilderRequests)
+	expectNoRequest(t, proxies[1].localBuilderRequests)
+	expectRequest(t, proxies[2].localBuilderRequests)
+
+	rr = adminRequest(http.MethodPost, "/admin/peers/proxy:1/enable", "secret", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	for _, peer := range proxies[0].proxy.sharing.PeersInfo() {
+		require.False(t, peer.Disabled)
+	}
+
+	// peers that are not in the peer list can't be disabled
+	rr = adminRequest(http.MethodPost, "/admin/peers/proxy:typo/disable", "secret", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	rr = adminRequest(http.MethodPost, "/admin/peers/proxy:typo/enable", "secret", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.Len(t, proxies[0].proxy.sharing.PeersInfo(), 2)
+
+	rr = adminRequest(http.MethodGet, "/admin/dedupe", "secret", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), `"name":"request_unique_keys"`)
+
+	rr = adminRequest(http.MethodPost, "/admin/loglevel", "secret", `{"level":"warn"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, slog.LevelWarn, logLevel.Level())
+	rr = adminRequest(http.MethodPost, "/admin/loglevel", "secret", `{"level":"verbose"}`)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = adminRequest(http.MethodPost, "/admin/archive/flush", "secret", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	adminClient := NewAdminClient(server.URL, "secret")
+
+	queues, err := adminClient.Queues(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "share", queues[0].Name)
+	require.Len(t, queues, 6)
+
+	signer, err := adminClient.Signer(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, proxies[0].proxy.OrderflowSigner.Address(), signer.Address)
+	require.NotNil(t, signer.Registered)
+
+	_, err = NewAdminClient(server.URL, "wrong").Peers(context.Background())
+	require.ErrorContains(t, err, errAdminUnauthorized.Error())
+}
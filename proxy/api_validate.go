@@ -3,6 +3,7 @@ package proxy
 import (
 	"errors"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/flashbots/go-utils/rpctypes"
 )
 
@@ -18,6 +19,9 @@ var (
 	errVersionNotSet                = errors.New("version field should be set")
 	errInvalidVersion               = errors.New("invalid version")
 	errMoreThanOneRefundTxHash      = errors.New("no more than one refund tx hash is allowed")
+	errPrivateTxEmpty               = errors.New("tx field should be set")
+	errPrivateTxHashEmpty           = errors.New("txHash field should be set")
+	errPrivateTxRefundPercent       = errors.New("refund percents should be between 0 and 100 in total")
 )
 
 // EnsureReplacementUUID updates bundle with consistent replacement uuid value (falling back to `uuid` field if needed)
@@ -95,18 +99,58 @@ func ValidateEthCancelBundle(args *rpctypes.EthCancelBundleArgs, publicEndpoint
 	return nil
 }
 
-func ValidateMevSendBundle(args *rpctypes.MevSendBundleArgs, publicEndpoint bool) error {
+// ValidateMevSendBundle returns hash of the bundle, it is empty for cancellations
+func ValidateMevSendBundle(args *rpctypes.MevSendBundleArgs, publicEndpoint bool) (common.Hash, error) {
 	// @perf it calculates hash
-	_, err := args.Validate()
+	hash, err := args.Validate()
 	if err != nil {
-		return err
+		return common.Hash{}, err
 	}
 
 	if !publicEndpoint {
 		if args.Metadata != nil {
-			return errLocalEndpointSbundleMetadata
+			return common.Hash{}, errLocalEndpointSbundleMetadata
 		}
 	}
 
+	return hash, nil
+}
+
+func ValidateEthSendPrivateTransaction(args *EthSendPrivateTransactionArgs, publicEndpoint bool) error {
+	if !publicEndpoint {
+		if args.SigningAddress != nil {
+			return errSigningAddress
+		}
+	}
+
+	if len(args.Tx) == 0 {
+		return errPrivateTxEmpty
+	}
+
+	if args.Preferences != nil && args.Preferences.Validity != nil {
+		total := 0
+		for _, refund := range args.Preferences.Validity.Refund {
+			if refund.Percent < 0 {
+				return errPrivateTxRefundPercent
+			}
+			total += refund.Percent
+		}
+		if total > 100 {
+			return errPrivateTxRefundPercent
+		}
+	}
+
+	return nil
+}
+
+func ValidateEthCancelPrivateTransaction(args *EthCancelPrivateTransactionArgs, publicEndpoint bool) error {
+	if !publicEndpoint {
+		if args.SigningAddress != nil {
+			return errSigningAddress
+		}
+	}
+	if args.TxHash == (common.Hash{}) {
+		return errPrivateTxHashEmpty
+	}
 	return nil
 }
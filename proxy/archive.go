@@ -4,15 +4,22 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"sync/atomic"
 	"time"
 
+	"github.com/VictoriaMetrics/metrics"
 	"github.com/cenkalti/backoff"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/flashbots/go-utils/rpcclient"
 	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/flashbots/tdx-orderflow-proxy/common"
 )
 
-const NewOrderEventsMethod = "flashbots_newOrderEvents"
+const (
+	NewOrderEventsMethod = "flashbots_newOrderEvents"
+
+	archiveLogSampleKey = "archive"
+)
 
 var (
 	// ArchiveBatchSize is a maximum size of the batch to send to the archive
@@ -30,12 +37,18 @@ var (
 )
 
 type ArchiveQueue struct {
-	log               *slog.Logger
-	queue             chan *ParsedRequest
+	name       string
+	log        *slog.Logger
+	logSampler *common.LogSampler
+	queue      chan *ParsedRequest
+	// queueAge should be updated by the producers of the queue
+	queueAge          *queueAgeTracker
+	workersQueue      chan *ParsedRequest
 	flushQueue        chan struct{}
 	archiveClient     rpcclient.RPCClient
 	blockNumberSource *BlockNumberSource
 	workerCount       int
+	health            *dependencyHealth
 }
 
 func (aq *ArchiveQueue) Run() {
@@ -44,17 +57,30 @@ func (aq *ArchiveQueue) Run() {
 		workerCount = aq.workerCount
 	}
 	workers := make([]*archiveQueueWorker, 0, workerCount)
-	workersQueue := make(chan *ParsedRequest, ArchiveWorkerQueueSize)
+	workersQueue := aq.workersQueue
+
+	metricsSet := metrics.NewSet()
+	workersQueueAge := &queueAgeTracker{}
+	metricsName := queueMetricsName("archive", aq.name)
+	registerQueueGauges(metricsSet, metricsName, aq.queue, aq.queueAge)
+	registerQueueGauges(metricsSet, metricsName+"_workers", workersQueue, workersQueueAge)
+
 	for w := range workerCount {
 		worker := &archiveQueueWorker{
 			log:           aq.log.With(slog.Int("worker", w)),
+			logSampler:    aq.logSampler,
 			archiveClient: aq.archiveClient,
 			queue:         workersQueue,
+			queueAge:      workersQueueAge,
 			flushQueue:    make(chan struct{}),
+			health:        aq.health,
 		}
+		registerArchiveWorkerGauges(metricsSet, metricsName, w, &worker.pending)
 		go worker.runWorker()
 		workers = append(workers, worker)
 	}
+	metrics.RegisterSet(metricsSet)
+	defer metrics.UnregisterSet(metricsSet, true)
 	aq.log.Info("Started archival workers", slog.Int("workers", workerCount))
 	defer func() {
 		for _, worker := range workers {
@@ -90,11 +116,13 @@ func (aq *ArchiveQueue) Run() {
 			if !more {
 				return
 			}
+			aq.queueAge.dequeued(req, aq.queue)
 			archiveEventsProcessedTotalCounter.Inc()
 			processedReq, err := aq.updateParsedRequest(req)
 			if err != nil {
-				aq.log.Error("Failed to prepare request for archive", slog.Any("error", err))
+				aq.logSampler.Error(aq.log, archiveLogSampleKey, "Failed to prepare request for archive", slog.Any("error", err))
 				archiveEventsProcessedErrCounter.Inc()
+				req.ledgerEntry.record(DeliveryDestinationArchive, DeliveryStatusFailed, err)
 				continue
 			}
 			if processedReq == nil {
@@ -102,8 +130,10 @@ func (aq *ArchiveQueue) Run() {
 			}
 			select {
 			case workersQueue <- processedReq:
+				workersQueueAge.enqueued()
 			default:
-				aq.log.Error("Archive workers are stalling")
+				aq.logSampler.Error(aq.log, archiveLogSampleKey, "Archive workers are stalling")
+				processedReq.ledgerEntry.record(DeliveryDestinationArchive, DeliveryStatusDropped, nil)
 			}
 		}
 	}
@@ -140,6 +170,35 @@ func (aq *ArchiveQueue) updateParsedRequest(input *ParsedRequest) (*ParsedReques
 			method:         input.method,
 			receivedAt:     input.receivedAt,
 			mevSendBundle:  &mevSendBundle,
+			ledgerEntry:    input.ledgerEntry,
+		}
+	}
+	if input.ethSendPrivateTransaction != nil {
+		privateTx := input.ethSendPrivateTransaction
+		block, err := aq.blockNumberSource.BlockNumber()
+		if err != nil {
+			return nil, err
+		}
+		var mevSendBundle rpctypes.MevSendBundleArgs
+		mevSendBundle.Version = "v0.1"
+		mevSendBundle.Inclusion.BlockNumber = hexutil.Uint64(block)
+		mevSendBundle.Inclusion.MaxBlock = hexutil.Uint64(privateTx.maxBlock(block))
+		mevSendBundle.Body = []rpctypes.MevBundleBody{{Tx: &privateTx.Tx}}
+		if privateTx.Preferences != nil && privateTx.Preferences.Validity != nil {
+			mevSendBundle.Validity.RefundConfig = privateTx.Preferences.Validity.Refund
+		}
+		signer := input.signer
+		mevSendBundle.Metadata = &rpctypes.MevBundleMetadata{
+			Signer: &signer,
+		}
+
+		input = &ParsedRequest{
+			systemEndpoint: input.systemEndpoint,
+			signer:         input.signer,
+			method:         input.method,
+			receivedAt:     input.receivedAt,
+			mevSendBundle:  &mevSendBundle,
+			ledgerEntry:    input.ledgerEntry,
 		}
 	}
 	return input, nil
@@ -147,9 +206,14 @@ func (aq *ArchiveQueue) updateParsedRequest(input *ParsedRequest) (*ParsedReques
 
 type archiveQueueWorker struct {
 	log           *slog.Logger
+	logSampler    *common.LogSampler
 	archiveClient rpcclient.RPCClient
 	queue         chan *ParsedRequest
+	queueAge      *queueAgeTracker
 	flushQueue    chan struct{}
+	health        *dependencyHealth
+	// pending is a size of the pending batch
+	pending atomic.Int64
 }
 
 func (aqw *archiveQueueWorker) close() {
@@ -167,6 +231,7 @@ func (aqw *archiveQueueWorker) runWorker() {
 		if needFlush {
 			aqw.flush(pendingBatch)
 			pendingBatch = nil
+			aqw.pending.Store(0)
 			needFlush = false
 		}
 		select {
@@ -174,7 +239,9 @@ func (aqw *archiveQueueWorker) runWorker() {
 			if !more {
 				return
 			}
+			aqw.queueAge.dequeued(req, aqw.queue)
 			pendingBatch = append(pendingBatch, req)
+			aqw.pending.Store(int64(len(pendingBatch)))
 			if len(pendingBatch) > ArchiveBatchSize {
 				needFlush = true
 			}
@@ -189,6 +256,7 @@ func (aqw *archiveQueueWorker) runWorker() {
 
 func (aqw *archiveQueueWorker) flush(batch []*ParsedRequest) {
 	args := FlashbotsNewOrderEventsArgs{}
+	archived := make([]*ParsedRequest, 0, len(batch))
 	for _, request := range batch {
 		event := ArchiveEvent{}
 		metadata := ArchiveEventMetadata{
@@ -209,12 +277,19 @@ func (aqw *archiveQueueWorker) flush(batch []*ParsedRequest) {
 				Params:   request.ethCancelBundle,
 				Metadata: &metadata,
 			}
+		} else if request.ethCancelPrivateTransaction != nil {
+			event.EthCancelPrivateTransaction = &ArchiveEventEthCancelPrivateTransaction{
+				Params:   request.ethCancelPrivateTransaction,
+				Metadata: &metadata,
+			}
 		} else {
-			aqw.log.Error("Incorrect request for orderflow archival", slog.String("method", request.method))
+			aqw.logSampler.Error(aqw.log, archiveLogSampleKey, "Incorrect request for orderflow archival", slog.String("method", request.method))
 			archiveEventsProcessedErrCounter.Inc()
+			request.ledgerEntry.record(DeliveryDestinationArchive, DeliveryStatusFailed, nil)
 			continue
 		}
 		args.OrderEvents = append(args.OrderEvents, event)
+		archived = append(archived, request)
 	}
 	if len(args.OrderEvents) == 0 {
 		return
@@ -231,27 +306,34 @@ func (aqw *archiveQueueWorker) flush(batch []*ParsedRequest) {
 
 		start := time.Now()
 		res, err := aqw.archiveClient.Call(ctx, NewOrderEventsMethod, args)
-		archiveEventsRPCDuration.Update(float64(time.Since(start).Milliseconds()))
+		timeArchiveRPCDuration(time.Since(start))
 
 		if err != nil {
-			aqw.log.Error("Error while making RPC request to archive", slog.Any("error", err))
+			aqw.logSampler.Error(aqw.log, archiveLogSampleKey, "Error while making RPC request to archive", slog.Any("error", err))
 			archiveEventsRPCErrors.Inc()
 			return err
 		}
 		if res != nil && res.Error != nil {
-			aqw.log.Error("Archive returned error", slog.Any("error", res.Error))
+			aqw.logSampler.Error(aqw.log, archiveLogSampleKey, "Archive returned error", slog.Any("error", res.Error))
 			archiveEventsRPCErrors.Inc()
 			return errArchiveReturnedError
 		}
 		return nil
 	}, exp)
 
+	status := DeliveryStatusDelivered
 	if err != nil {
-		aqw.log.Error("Failed to submit batch to the archive", slog.Any("error", err))
+		aqw.logSampler.Error(aqw.log, archiveLogSampleKey, "Failed to submit batch to the archive", slog.Any("error", err))
+		aqw.health.failure(err)
+		status = DeliveryStatusFailed
 	} else {
+		aqw.health.success()
 		aqw.log.Info("Successfully submitted batch to the archive")
 		archiveEventsRPCSentCounter.AddInt64(int64(len(args.OrderEvents)))
 	}
+	for _, request := range archived {
+		request.ledgerEntry.record(DeliveryDestinationArchive, status, err)
+	}
 }
 
 type FlashbotsNewOrderEventsArgs struct {
@@ -262,6 +344,8 @@ type ArchiveEvent struct {
 	EthSendBundle   *ArchiveEventEthSendBundle   `json:"eth_sendBundle,omitempty"`
 	MevSendBundle   *ArchiveEventMevSendBundle   `json:"mev_sendBundle,omitempty"`
 	EthCancelBundle *ArchiveEventEthCancelBundle `json:"eth_cancelBundle,omitempty"`
+
+	EthCancelPrivateTransaction *ArchiveEventEthCancelPrivateTransaction `json:"eth_cancelPrivateTransaction,omitempty"`
 }
 
 type ArchiveEventMetadata struct {
@@ -283,3 +367,8 @@ type ArchiveEventEthCancelBundle struct {
 	Params   *rpctypes.EthCancelBundleArgs `json:"params"`
 	Metadata *ArchiveEventMetadata         `json:"metadata"`
 }
+
+type ArchiveEventEthCancelPrivateTransaction struct {
+	Params   *EthCancelPrivateTransactionArgs `json:"params"`
+	Metadata *ArchiveEventMetadata            `json:"metadata"`
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/go-utils/jsonrpc"
+	"github.com/flashbots/go-utils/rpcserver"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/goccy/go-json"
+)
+
+const (
+	jsonRPCParseErrorCode    = -32700
+	jsonRPCInternalErrorCode = -32603
+
+	DefaultMaxBatchLength    = 100
+	DefaultMaxBatchSizeBytes = int64(10 * 1024 * 1024) // 10 MB
+)
+
+var (
+	errBatchEmpty       = errors.New("empty batch")
+	errBatchTooLong     = errors.New("batch has too many requests")
+	errBatchTooBig      = errors.New("batch body too big")
+	errBatchBodyTooBig  = errors.New("request body too big")
+	errBatchMalformed   = errors.New("batch is not a valid JSON array")
+	errBatchNotVerified = errors.New("batch signature is invalid")
+)
+
+type (
+	batchSignerKey       struct{}
+	rawJSONRPCRequestKey struct{}
+	requestBodyKey       struct{}
+)
+
+// readRequestBody reads the body of the request up to maxSizeBytes and keeps it in the context of the returned request,
+// so the middlewares and the handler don't read and buffer the same body again
+func readRequestBody(w http.ResponseWriter, r *http.Request, maxSizeBytes int64) ([]byte, *http.Request, error) {
+	if body, ok := r.Context().Value(requestBodyKey{}).([]byte); ok {
+		return body, r, nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSizeBytes))
+	if err != nil {
+		return nil, r, err
+	}
+	_ = r.Body.Close()
+	r = r.WithContext(context.WithValue(r.Context(), requestBodyKey{}, body))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, r, nil
+}
+
+// rawJSONRPCRequest returns body of the JSON-RPC request as received, for batch elements it's the element
+func rawJSONRPCRequest(ctx context.Context) []byte {
+	raw, _ := ctx.Value(rawJSONRPCRequestKey{}).([]byte)
+	return raw
+}
+
+// requestSigner returns signer of the request, for batch elements it's the signer of the whole batch
+func requestSigner(ctx context.Context) common.Address {
+	if signer, ok := ctx.Value(batchSignerKey{}).(common.Address); ok {
+		return signer
+	}
+	return rpcserver.GetSigner(ctx)
+}
+
+type JSONRPCBatchOpts struct {
+	ServerName              string
+	MaxRequestBodySizeBytes int64
+	// MaxBatchLength is the max number of requests in one batch, if 0 DefaultMaxBatchLength is used
+	MaxBatchLength int
+	// MaxBatchSizeBytes is the max size of the whole batch body, if 0 DefaultMaxBatchSizeBytes is used
+	MaxBatchSizeBytes int64
+}
+
+// JSONRPCBatchHandler accepts JSON-RPC batch arrays in addition to single requests.
+// The signature header is verified over the whole batch body, then each element is passed to elementHandler
+// (that must not verify signature itself) with the batch signer in the context. Responses are returned
+// as an array in the order of requests. Single requests are passed to the handler untouched.
+type JSONRPCBatchHandler struct {
+	opts           JSONRPCBatchOpts
+	handler        http.Handler
+	elementHandler http.Handler
+}
+
+func NewJSONRPCBatchHandler(handler, elementHandler http.Handler, opts JSONRPCBatchOpts) *JSONRPCBatchHandler {
+	if opts.MaxRequestBodySizeBytes == 0 {
+		opts.MaxRequestBodySizeBytes = DefaultMaxRequestBodySizeBytes
+	}
+	if opts.MaxBatchLength == 0 {
+		opts.MaxBatchLength = DefaultMaxBatchLength
+	}
+	if opts.MaxBatchSizeBytes == 0 {
+		opts.MaxBatchSizeBytes = DefaultMaxBatchSizeBytes
+	}
+	return &JSONRPCBatchHandler{
+		opts:           opts,
+		handler:        handler,
+		elementHandler: elementHandler,
+	}
+}
+
+func (h *JSONRPCBatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	body, r, err := readRequestBody(w, r, h.opts.MaxRequestBodySizeBytes)
+	if err != nil {
+		writeJSONRPCError(w, nil, jsonRPCInvalidRequestCode, errBatchBodyTooBig.Error())
+		return
+	}
+
+	if !isJSONArray(body) {
+		r = r.WithContext(context.WithValue(r.Context(), rawJSONRPCRequestKey{}, body))
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if int64(len(body)) > h.opts.MaxBatchSizeBytes {
+		incBatchRejected(h.opts.ServerName, "too_big")
+		writeJSONRPCError(w, nil, jsonRPCInvalidRequestCode, errBatchTooBig.Error())
+		return
+	}
+
+	signer, err := signature.Verify(r.Header.Get(signature.HTTPHeader), body)
+	if err != nil {
+		incBatchRejected(h.opts.ServerName, "signature")
+		writeJSONRPCError(w, nil, jsonRPCInvalidRequestCode, errors.Join(errBatchNotVerified, err).Error())
+		return
+	}
+
+	var elements []json.RawMessage
+	err = json.Unmarshal(body, &elements)
+	if err != nil {
+		incBatchRejected(h.opts.ServerName, "malformed")
+		writeJSONRPCError(w, nil, jsonRPCParseErrorCode, errBatchMalformed.Error())
+		return
+	}
+	if len(elements) == 0 {
+		incBatchRejected(h.opts.ServerName, "empty")
+		writeJSONRPCError(w, nil, jsonRPCInvalidRequestCode, errBatchEmpty.Error())
+		return
+	}
+	if len(elements) > h.opts.MaxBatchLength {
+		incBatchRejected(h.opts.ServerName, "too_long")
+		writeJSONRPCError(w, nil, jsonRPCInvalidRequestCode, errBatchTooLong.Error())
+		return
+	}
+	observeBatchLength(h.opts.ServerName, len(elements))
+
+	// elements are processed sequentially so e.g. bundle and its cancellation in one batch keep their order
+	ctx := context.WithValue(r.Context(), batchSignerKey{}, signer)
+	res := make([]byte, 0, 64*len(elements))
+	res = append(res, '[')
+	for i, element := range elements {
+		if i > 0 {
+			res = append(res, ',')
+		}
+		elementReq := r.Clone(context.WithValue(ctx, rawJSONRPCRequestKey{}, []byte(element)))
+		elementReq.Body = io.NopCloser(bytes.NewReader(element))
+		elementReq.ContentLength = int64(len(element))
+
+		rw := &batchElementResponseWriter{header: make(http.Header)}
+		h.elementHandler.ServeHTTP(rw, elementReq)
+		elementRes := bytes.TrimSpace(rw.body.Bytes())
+		if len(elementRes) == 0 {
+			elementRes, _ = json.Marshal(jsonrpc.JSONRPCResponse{
+				Error:   &jsonrpc.JSONRPCError{Code: jsonRPCInternalErrorCode, Message: "empty response"},
+				Version: "2.0",
+			})
+		}
+		res = append(res, elementRes...)
+	}
+	res = append(res, ']')
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(res)
+}
+
+func isJSONArray(body []byte) bool {
+	body = bytes.TrimLeft(body, " \t\r\n")
+	return len(body) > 0 && body[0] == '['
+}
+
+// batchElementResponseWriter collects JSON-RPC response of one batch element or of the request with RPCError
+type batchElementResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	code   int
+}
+
+func (w *batchElementResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchElementResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *batchElementResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flashbots/go-utils/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONRPCBatch(t *testing.T) {
+	defer func() {
+		proxiesFlushQueue()
+		for {
+			select {
+			case <-time.After(time.Millisecond * 100):
+				expectNoRequest(t, archiveServerRequests)
+				return
+			case <-archiveServerRequests:
+			}
+		}
+	}()
+
+	builderHubPeers = nil
+	proxiesUpdatePeers(t)
+
+	signer, err := signature.NewSignerFromHexPrivateKey("0xd63b3c447fdea415a05e4c0b859474d14105a88178efdf350bc9f7b05be3cc58")
+	require.NoError(t, err)
+
+	sendUserRequest := func(body, signatureHeader string) []map[string]any {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		req.Header.Set(signature.HTTPHeader, signatureHeader)
+		rr := httptest.NewRecorder()
+		proxies[0].proxy.UserHandler.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var res []map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+			// batch is rejected as a whole with a single error
+			var single map[string]any
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &single))
+			return []map[string]any{single}
+		}
+		return res
+	}
+	sign := func(body string) string {
+		t.Helper()
+		header, err := signer.Create([]byte(body))
+		require.NoError(t, err)
+		return header
+	}
+
+	body := `[
+		{"method":"eth_sendBundle","params":[{"blockNumber":"0x7d0"}],"id":1,"jsonrpc":"2.0"},
+		{"method":"eth_unknownMethod","params":[],"id":2,"jsonrpc":"2.0"},
+		{"method":"eth_sendBundle","params":[{"blockNumber":"0x7d1"}],"id":3,"jsonrpc":"2.0"}
+	]`
+	// user requests wait for the local builder so its requests are read while the batch is processed
+	builderRequests := make(chan *RequestData, 2)
+	go func() {
+		for range 2 {
+			builderRequests <- <-proxies[0].localBuilderRequests
+		}
+	}()
+	res := sendUserRequest(body, sign(body))
+	require.Len(t, res, 3)
+	require.EqualValues(t, 1, res[0]["id"])
+	require.Nil(t, res[0]["error"])
+	require.EqualValues(t, 2, res[1]["id"])
+	require.NotNil(t, res[1]["error"])
+	require.EqualValues(t, 3, res[2]["id"])
+	require.Nil(t, res[2]["error"])
+
+	// every element is signed by the batch signer
+	for _, block := range []string{"0x7d0", "0x7d1"} {
+		builderRequest := expectRequest(t, builderRequests)
+		require.Contains(t, builderRequest.body, `"blockNumber":"`+block+`"`)
+		require.Contains(t, builderRequest.body, `"signingAddress":"`+strings.ToLower(signer.Address().Hex())+`"`)
+	}
+	expectNoRequest(t, proxies[0].localBuilderRequests)
+
+	// signature must cover the whole batch
+	res = sendUserRequest(body, sign(`[{"method":"eth_sendBundle","params":[{"blockNumber":"0x7d0"}],"id":1,"jsonrpc":"2.0"}]`))
+	require.Len(t, res, 1)
+	require.Contains(t, res[0]["error"].(map[string]any)["message"], errBatchNotVerified.Error())
+
+	res = sendUserRequest(`[]`, sign(`[]`))
+	require.Contains(t, res[0]["error"].(map[string]any)["message"], errBatchEmpty.Error())
+
+	elements := make([]string, DefaultMaxBatchLength+1)
+	for i := range elements {
+		elements[i] = `{"method":"eth_sendBundle","params":[{"blockNumber":"0x7d2"}],"id":1,"jsonrpc":"2.0"}`
+	}
+	body = "[" + strings.Join(elements, ",") + "]"
+	res = sendUserRequest(body, sign(body))
+	require.Contains(t, res[0]["error"].(map[string]any)["message"], errBatchTooLong.Error())
+	expectNoRequest(t, proxies[0].localBuilderRequests)
+}
+
+// onceReader fails if the body is read again after it was fully consumed
+type onceReader struct {
+	r    *bytes.Reader
+	done bool
+}
+
+func (o *onceReader) Read(p []byte) (int, error) {
+	if o.done {
+		return 0, errors.New("body is read twice")
+	}
+	n, err := o.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		o.done = true
+	}
+	return n, err
+}
+
+func (o *onceReader) Close() error { return nil }
+
+func TestReadRequestBodyOnce(t *testing.T) {
+	body := []byte(`{"method":"eth_sendBundle","params":[],"id":0,"jsonrpc":"2.0"}`)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Body = &onceReader{r: bytes.NewReader(body)}
+	rr := httptest.NewRecorder()
+
+	// the middleware reads the body, the handler gets the same bytes from the context
+	read, req, err := readRequestBody(rr, req, 1<<20)
+	require.NoError(t, err)
+	require.Equal(t, body, read)
+	again, _, err := readRequestBody(rr, req, 1<<20)
+	require.NoError(t, err)
+	require.Same(t, &read[0], &again[0])
+
+	// the handler that reads the body directly still gets it
+	direct, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, direct)
+}
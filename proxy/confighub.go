@@ -4,7 +4,10 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"log/slog"
@@ -44,6 +47,41 @@ func (b *ConfighubBuilder) TLSCert() string {
 	return b.OrderflowProxy.TLSCert
 }
 
+// ConfighubBuilderSummary is a compact representation of the peer used for logging
+type ConfighubBuilderSummary struct {
+	Name            string `json:"name"`
+	Address         string `json:"address"`
+	Endpoint        string `json:"endpoint"`
+	CertFingerprint string `json:"cert_fingerprint"`
+}
+
+func (b *ConfighubBuilder) Summary() ConfighubBuilderSummary {
+	return ConfighubBuilderSummary{
+		Name:            b.Name,
+		Address:         b.OrderflowProxy.EcdsaPubkeyAddress.String(),
+		Endpoint:        b.SystemAPIAddress(),
+		CertFingerprint: CertFingerprint([]byte(b.TLSCert())),
+	}
+}
+
+func PeersSummary(peers []ConfighubBuilder) []ConfighubBuilderSummary {
+	result := make([]ConfighubBuilderSummary, 0, len(peers))
+	for _, peer := range peers {
+		result = append(result, peer.Summary())
+	}
+	return result
+}
+
+// CertFingerprint returns hex encoded sha256 of the first certificate in the PEM, empty string if there is no certificate
+func CertFingerprint(certPEM []byte) string {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return ""
+	}
+	fingerprint := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(fingerprint[:])
+}
+
 type BuilderConfigHub struct {
 	log      *slog.Logger
 	endpoint string
@@ -109,6 +147,6 @@ func (b *BuilderConfigHub) Builders(internal bool) (result []ConfighubBuilder, e
 	if err != nil {
 		return nil, err
 	}
-	b.log.Info("Received list of peers from confighub", slog.Bool("internalEndpoint", internal), slog.Any("peers", result))
+	b.log.Info("Received list of peers from confighub", slog.Bool("internalEndpoint", internal), slog.Any("peers", PeersSummary(result)))
 	return
 }
@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+)
+
+var (
+	// DefaultDedupeWindow is how long unique keys of orders are remembered
+	DefaultDedupeWindow = time.Second * 12
+	// DefaultDedupeMaxEntries bounds memory of the dedupe, the oldest keys are evicted before the window passes if it's reached
+	DefaultDedupeMaxEntries = 1_000_000
+
+	// dedupeShards is a number of independently locked parts of the dedupe, keys are spread by their bytes
+	dedupeShards = 64
+	// dedupeBuckets is a number of time buckets in the window, keys expire with the bucket granularity
+	dedupeBuckets = 12
+
+	errDedupeMaxEntries = errors.New("dedupe max entries should be at least the number of shards")
+)
+
+// origins of deduplicated orders
+const (
+	DedupeOriginUser = "user"
+	DedupeOriginPeer = "peer"
+)
+
+// dedupeEntry keeps method and origin of the key so evictions can be reported by them
+type dedupeEntry struct {
+	method string
+	origin string
+}
+
+type dedupeBucket struct {
+	// slot is the start of the bucket in bucket widths since unix epoch
+	slot    int64
+	entries map[uuid.UUID]dedupeEntry
+}
+
+type dedupeShard struct {
+	mu sync.Mutex
+	// buckets is a ring of the window indexed by slot
+	buckets []dedupeBucket
+	count   int
+}
+
+// RequestDedupe remembers unique keys of orders for the time window so duplicates from users and peers are not
+// forwarded again. Keys are kept in time buckets that are dropped as a whole when they leave the window,
+// so the size depends on the order rate instead of a fixed number of entries.
+type RequestDedupe struct {
+	window      time.Duration
+	bucketWidth time.Duration
+	maxEntries  int
+	shards      []dedupeShard
+
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	metrics *dedupeMetricHandles
+
+	// path is a file the dedupe is saved to, if empty dedupe is in memory only
+	path   string
+	saveMu sync.Mutex
+}
+
+// NewRequestDedupe creates the dedupe, if path is set keys saved before restart are loaded from it
+func NewRequestDedupe(window time.Duration, maxEntries int, path string) (*RequestDedupe, error) {
+	if window <= 0 {
+		window = DefaultDedupeWindow
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultDedupeMaxEntries
+	}
+	if maxEntries < dedupeShards {
+		return nil, fmt.Errorf("%w: %d", errDedupeMaxEntries, dedupeShards)
+	}
+	d := &RequestDedupe{
+		window:      window,
+		bucketWidth: max(window/time.Duration(dedupeBuckets), time.Millisecond),
+		maxEntries:  maxEntries,
+		shards:      make([]dedupeShard, dedupeShards),
+		metrics:     newDedupeMetricHandles(),
+		path:        path,
+	}
+	for i := range d.shards {
+		d.shards[i].buckets = make([]dedupeBucket, dedupeBuckets)
+		for j := range d.shards[i].buckets {
+			d.shards[i].buckets[j].entries = make(map[uuid.UUID]dedupeEntry)
+		}
+	}
+	if path != "" {
+		err := d.load(time.Now())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Seen returns true if the key was seen in the window, otherwise the key is remembered
+func (d *RequestDedupe) Seen(key uuid.UUID, method, origin string) bool {
+	return d.seen(key, method, origin, time.Now())
+}
+
+func (d *RequestDedupe) seen(key uuid.UUID, method, origin string, now time.Time) bool {
+	slot := d.slot(now)
+	shard := d.shard(key)
+	shard.mu.Lock()
+	found := shard.contains(key, slot)
+	if !found {
+		d.add(shard, key, dedupeEntry{method: method, origin: origin}, slot)
+	}
+	shard.mu.Unlock()
+
+	counters := d.metrics.counters(method, origin)
+	if found {
+		d.hits.Add(1)
+		counters.hits.Inc()
+	} else {
+		d.misses.Add(1)
+		counters.misses.Inc()
+	}
+	return found
+}
+
+// Len returns the number of keys in the window
+func (d *RequestDedupe) Len() int {
+	slot := d.slot(time.Now())
+	count := 0
+	for i := range d.shards {
+		shard := &d.shards[i]
+		shard.mu.Lock()
+		for j := range shard.buckets {
+			if shard.inWindow(&shard.buckets[j], slot) {
+				count += len(shard.buckets[j].entries)
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return count
+}
+
+func (d *RequestDedupe) slot(now time.Time) int64 {
+	return now.UnixNano() / int64(d.bucketWidth)
+}
+
+func (d *RequestDedupe) shard(key uuid.UUID) *dedupeShard {
+	return &d.shards[binary.LittleEndian.Uint64(key[:8])%uint64(len(d.shards))] //nolint:gosec
+}
+
+func (s *dedupeShard) inWindow(bucket *dedupeBucket, slot int64) bool {
+	age := slot - bucket.slot
+	return age >= 0 && age < int64(len(s.buckets))
+}
+
+func (s *dedupeShard) contains(key uuid.UUID, slot int64) bool {
+	for i := range s.buckets {
+		bucket := &s.buckets[i]
+		if !s.inWindow(bucket, slot) {
+			continue
+		}
+		if _, ok := bucket.entries[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// add puts the key to the bucket of the slot, the bucket is reused if it's left from the previous turn of the ring.
+// If the shard is full the oldest bucket is evicted, the bucket of the slot is never evicted so a burst keeps
+// its own keys, if only it is left the key is not remembered and counted as evicted.
+func (d *RequestDedupe) add(s *dedupeShard, key uuid.UUID, entry dedupeEntry, slot int64) {
+	bucket := &s.buckets[slot%int64(len(s.buckets))]
+	if bucket.slot != slot {
+		s.count -= len(bucket.entries)
+		clear(bucket.entries)
+		bucket.slot = slot
+	}
+	if s.count >= d.maxEntries/len(d.shards) && !d.evictOldest(s, slot) {
+		d.metrics.counters(entry.method, entry.origin).evictions.Inc()
+		return
+	}
+	bucket.entries[key] = entry
+	s.count++
+}
+
+// evictOldest drops the oldest bucket of the shard other than the bucket of the slot, evicted keys are reported
+// by method and origin. It returns false if there is no such bucket.
+func (d *RequestDedupe) evictOldest(s *dedupeShard, slot int64) bool {
+	var oldest *dedupeBucket
+	for i := range s.buckets {
+		bucket := &s.buckets[i]
+		if len(bucket.entries) == 0 || bucket.slot == slot {
+			continue
+		}
+		if oldest == nil || bucket.slot < oldest.slot {
+			oldest = bucket
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	if s.inWindow(oldest, slot) {
+		for _, entry := range oldest.entries {
+			d.metrics.counters(entry.method, entry.origin).evictions.Inc()
+		}
+	}
+	s.count -= len(oldest.entries)
+	clear(oldest.entries)
+	return true
+}
+
+// dedupeSnapshotEntry is a key saved to the dedupe file
+type dedupeSnapshotEntry struct {
+	Key uuid.UUID `json:"key"`
+	// AddedAt is a unix millisecond timestamp of the key bucket
+	AddedAt int64  `json:"addedAt"`
+	Method  string `json:"method"`
+	Origin  string `json:"origin"`
+}
+
+// Save writes keys in the window to the file as JSON lines, it does nothing if the file is not set
+func (d *RequestDedupe) Save() error {
+	if d == nil || d.path == "" {
+		return nil
+	}
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	slot := d.slot(time.Now())
+	for i := range d.shards {
+		shard := &d.shards[i]
+		shard.mu.Lock()
+		for j := range shard.buckets {
+			bucket := &shard.buckets[j]
+			if !shard.inWindow(bucket, slot) {
+				continue
+			}
+			addedAt := time.Duration(bucket.slot * int64(d.bucketWidth)).Milliseconds()
+			for key, entry := range bucket.entries {
+				err = enc.Encode(dedupeSnapshotEntry{Key: key, AddedAt: addedAt, Method: entry.method, Origin: entry.origin})
+				if err != nil {
+					break
+				}
+			}
+		}
+		shard.mu.Unlock()
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path)
+}
+
+func (d *RequestDedupe) load(now time.Time) error {
+	file, err := os.Open(d.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	nowSlot := d.slot(now)
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for dec.More() {
+		var entry dedupeSnapshotEntry
+		err = dec.Decode(&entry)
+		if err != nil {
+			return err
+		}
+		slot := d.slot(time.UnixMilli(entry.AddedAt))
+		age := nowSlot - slot
+		if age < 0 || age >= int64(dedupeBuckets) {
+			continue
+		}
+		shard := d.shard(entry.Key)
+		if shard.contains(entry.Key, nowSlot) {
+			continue
+		}
+		d.add(shard, entry.Key, dedupeEntry{method: entry.Method, origin: entry.Origin}, slot)
+	}
+	return nil
+}
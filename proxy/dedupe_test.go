@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestDedupe(t *testing.T) {
+	now := time.Now()
+
+	t.Run("window", func(t *testing.T) {
+		dedupe, err := NewRequestDedupe(12*time.Second, 0, "")
+		require.NoError(t, err)
+		key := uuid.New()
+
+		require.False(t, dedupe.seen(key, EthSendBundleMethod, DedupeOriginUser, now))
+		require.True(t, dedupe.seen(key, EthSendBundleMethod, DedupeOriginPeer, now.Add(time.Second)))
+		require.True(t, dedupe.seen(key, EthSendBundleMethod, DedupeOriginPeer, now.Add(11*time.Second)))
+		require.False(t, dedupe.seen(key, EthSendBundleMethod, DedupeOriginPeer, now.Add(12*time.Second)), "key left the window")
+		require.Equal(t, uint64(2), dedupe.hits.Load())
+		require.Equal(t, uint64(2), dedupe.misses.Load())
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		// more keys than fit in the window, the oldest are evicted early
+		small, err := NewRequestDedupe(12*time.Second, dedupeShards, "")
+		require.NoError(t, err)
+		keys := make([]uuid.UUID, 0, 1000)
+		for i := range 1000 {
+			key := uuid.New()
+			keys = append(keys, key)
+			require.False(t, small.seen(key, EthSendRawTransactionMethod, DedupeOriginUser, now.Add(time.Duration(i)*time.Millisecond*10)))
+		}
+		require.LessOrEqual(t, small.Len(), 2*dedupeShards)
+		require.False(t, small.seen(keys[0], EthSendRawTransactionMethod, DedupeOriginUser, now.Add(10*time.Second)))
+	})
+
+	t.Run("burst", func(t *testing.T) {
+		// burst that fills the shard keeps its own keys
+		burst, err := NewRequestDedupe(12*time.Second, 2*dedupeShards, "")
+		require.NoError(t, err)
+		shard := burst.shard(uuid.New())
+		var sameShard []uuid.UUID
+		for len(sameShard) < 3 {
+			if k := uuid.New(); burst.shard(k) == shard {
+				sameShard = append(sameShard, k)
+			}
+		}
+		for _, k := range sameShard {
+			require.False(t, burst.seen(k, EthSendBundleMethod, DedupeOriginUser, now))
+		}
+		require.True(t, burst.seen(sameShard[0], EthSendBundleMethod, DedupeOriginUser, now))
+		require.True(t, burst.seen(sameShard[1], EthSendBundleMethod, DedupeOriginUser, now))
+	})
+
+	t.Run("config error", func(t *testing.T) {
+		_, err := NewRequestDedupe(time.Second, dedupeShards-1, "")
+		require.ErrorIs(t, err, errDedupeMaxEntries)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		// keys are restored after restart
+		dedupePath := path.Join(t.TempDir(), "dedupe.jsonl")
+		key := uuid.New()
+		saved, err := NewRequestDedupe(0, 0, dedupePath)
+		require.NoError(t, err)
+		require.False(t, saved.Seen(key, EthSendBundleMethod, DedupeOriginUser))
+		require.NoError(t, saved.Save())
+		restored, err := NewRequestDedupe(0, 0, dedupePath)
+		require.NoError(t, err)
+		require.Equal(t, 1, restored.Len())
+		require.True(t, restored.Seen(key, EthSendBundleMethod, DedupeOriginPeer))
+	})
+}
@@ -0,0 +1,315 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// dependencies reported by /healthz and /readyz
+const (
+	HealthDependencyBuilderHub         = "builderhub"
+	HealthDependencyArchive            = "archive"
+	HealthDependencyLocalBuilder       = "local_builder"
+	HealthDependencyEthRPC             = "eth_rpc"
+	HealthDependencySignerRegistration = "signer_registration"
+)
+
+const (
+	healthStatusOK       = "ok"
+	healthStatusDegraded = "degraded"
+	healthStatusFailing  = "failing"
+)
+
+var (
+	// DefaultHealthHardDependencies are dependencies that make the proxy not ready when they are failing
+	DefaultHealthHardDependencies = []string{HealthDependencyLocalBuilder, HealthDependencySignerRegistration}
+
+	healthDependencies = []string{
+		HealthDependencyBuilderHub,
+		HealthDependencyArchive,
+		HealthDependencyLocalBuilder,
+		HealthDependencyEthRPC,
+		HealthDependencySignerRegistration,
+	}
+
+	// HealthPollInterval is how often local builder readiness and eth RPC head are polled
+	HealthPollInterval = time.Second * 2
+	// HealthPollTimeout is a timeout of a single poll request
+	HealthPollTimeout = time.Second * 2
+	// HealthPeerListMaxAge is the maximum age of the peer list fetched from BuilderHub
+	HealthPeerListMaxAge = peerUpdateTime * 3
+	// HealthEthHeadMaxAge is the maximum time eth RPC head can stay the same
+	HealthEthHeadMaxAge = time.Second * 60
+	// HealthArchiveMaxBacklog is the maximum number of requests waiting to be sent to the archive
+	HealthArchiveMaxBacklog = ArchiveWorkerQueueSize / 2
+)
+
+// DependencyStatus is a status of a single dependency in the health report
+type DependencyStatus struct {
+	Healthy     bool           `json:"healthy"`
+	Hard        bool           `json:"hard"`
+	Error       string         `json:"error,omitempty"`
+	LastSuccess *time.Time     `json:"lastSuccess,omitempty"`
+	Details     map[string]any `json:"details,omitempty"`
+}
+
+// HealthReport is returned by /healthz and /readyz
+// status is "ok", "degraded" when only soft dependencies are failing or "failing" when any hard dependency is failing
+type HealthReport struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+func validateHealthDependencies(deps []string) error {
+	for _, dep := range deps {
+		if !slices.Contains(healthDependencies, dep) {
+			return fmt.Errorf("unknown health dependency %q, expected one of %v", dep, healthDependencies)
+		}
+	}
+	return nil
+}
+
+// dependencyHealth remembers results of the last checks of the dependency
+type dependencyHealth struct {
+	mu          sync.RWMutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   error
+}
+
+func (d *dependencyHealth) success() {
+	d.mu.Lock()
+	d.lastSuccess = time.Now()
+	d.mu.Unlock()
+}
+
+func (d *dependencyHealth) failure(err error) {
+	d.mu.Lock()
+	d.lastFailure = time.Now()
+	d.lastError = err
+	d.mu.Unlock()
+}
+
+// status is healthy if the last check succeeded not earlier than maxAge ago (maxAge == 0 means no limit),
+// if neverCheckedOK is set dependency that was never used is considered healthy
+func (d *dependencyHealth) status(maxAge time.Duration, neverCheckedOK bool) DependencyStatus {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var status DependencyStatus
+	if !d.lastSuccess.IsZero() {
+		lastSuccess := d.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	switch {
+	case d.lastFailure.After(d.lastSuccess):
+		status.Error = d.lastError.Error()
+	case d.lastSuccess.IsZero():
+		if neverCheckedOK && d.lastFailure.IsZero() {
+			status.Healthy = true
+		} else {
+			status.Error = "no successful check yet"
+		}
+	case maxAge > 0 && time.Since(d.lastSuccess) > maxAge:
+		status.Error = fmt.Sprintf("last successful check was more than %s ago", maxAge)
+	default:
+		status.Healthy = true
+	}
+	return status
+}
+
+// ethHeadHealth tracks when eth RPC head changed the last time
+type ethHeadHealth struct {
+	dependencyHealth
+	headMu        sync.RWMutex
+	head          uint64
+	headUpdatedAt time.Time
+}
+
+func (e *ethHeadHealth) updateHead(head uint64) {
+	e.headMu.Lock()
+	if head != e.head {
+		e.head = head
+		e.headUpdatedAt = time.Now()
+	}
+	e.headMu.Unlock()
+	e.success()
+}
+
+func (e *ethHeadHealth) status() DependencyStatus {
+	status := e.dependencyHealth.status(HealthEthHeadMaxAge, false)
+	e.headMu.RLock()
+	defer e.headMu.RUnlock()
+	if e.headUpdatedAt.IsZero() {
+		return status
+	}
+	headAge := time.Since(e.headUpdatedAt)
+	status.Details = map[string]any{
+		"head":           e.head,
+		"headAgeSeconds": headAge.Seconds(),
+	}
+	if status.Healthy && headAge > HealthEthHeadMaxAge {
+		status.Healthy = false
+		status.Error = fmt.Sprintf("head did not change for more than %s", HealthEthHeadMaxAge)
+	}
+	return status
+}
+
+// runHealthPoller polls local builder readiness and eth RPC head in background so probes don't hit them directly
+func (prx *ReceiverProxy) runHealthPoller() {
+	ticker := time.NewTicker(HealthPollInterval)
+	defer ticker.Stop()
+	for {
+		prx.pollBuilderReadiness()
+		prx.pollEthHead()
+		select {
+		case <-prx.healthPollerClose:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (prx *ReceiverProxy) pollBuilderReadiness() {
+	for _, builder := range prx.localBuilders {
+		if builder.config.ReadyEndpoint != "" {
+			prx.pollLocalBuilderReadiness(builder)
+		}
+	}
+}
+
+func (prx *ReceiverProxy) pollLocalBuilderReadiness(builder *localBuilder) {
+	ctx, cancel := context.WithTimeout(context.Background(), HealthPollTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, builder.config.ReadyEndpoint+"/readyz", nil)
+	if err != nil {
+		builder.readiness.failure(err)
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		prx.logSampler.Warn(prx.Log, HealthDependencyLocalBuilder+":"+builder.config.Name, "Failed to check builder readiness",
+			slog.String("builder", builder.config.Name), slog.Any("error", err))
+		builder.readiness.failure(err)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		builder.readiness.failure(fmt.Errorf("builder /readyz returned status %d", resp.StatusCode))
+		return
+	}
+	builder.readiness.success()
+}
+
+func (prx *ReceiverProxy) pollEthHead() {
+	err := prx.blockNumberSource.UpdateCachedBlockNumber()
+	if err != nil {
+		prx.ethHead.failure(err)
+		return
+	}
+	head, err := prx.blockNumberSource.BlockNumber()
+	if err != nil {
+		prx.ethHead.failure(err)
+		return
+	}
+	prx.ethHead.updateHead(head)
+}
+
+// builderReadinessStatus is healthy when all primary local builders are ready, mirror and canary builders are not checked
+func (prx *ReceiverProxy) builderReadinessStatus() DependencyStatus {
+	status := DependencyStatus{Healthy: true}
+	builders := make(map[string]any, len(prx.localBuilders))
+	for _, builder := range prx.localBuilders {
+		if builder.config.Mode != LocalBuilderModePrimary {
+			continue
+		}
+		builderStatus := builder.readinessStatus()
+		builders[builder.config.Name] = builderStatus
+		if !builderStatus.Healthy && status.Healthy {
+			status.Healthy = false
+			status.Error = builder.config.Name + ": " + builderStatus.Error
+		}
+	}
+	status.Details = map[string]any{"builders": builders}
+	return status
+}
+
+// HealthReport checks all dependencies using cached state, it does not make any requests
+func (prx *ReceiverProxy) HealthReport() HealthReport {
+	deps := make(map[string]DependencyStatus, len(healthDependencies))
+
+	builderHub := prx.builderHubHealth.status(HealthPeerListMaxAge, false)
+	prx.peersMu.RLock()
+	builderHub.Details = map[string]any{"peers": len(prx.lastFetchedPeers)}
+	prx.peersMu.RUnlock()
+	deps[HealthDependencyBuilderHub] = builderHub
+
+	archive := prx.archiveHealth.status(0, true)
+	backlog := len(prx.archiveQueue) + len(prx.archiveWorkersQueue)
+	archive.Details = map[string]any{"backlog": backlog}
+	if archive.Healthy && backlog > HealthArchiveMaxBacklog {
+		archive.Healthy = false
+		archive.Error = fmt.Sprintf("backlog is bigger than %d", HealthArchiveMaxBacklog)
+	}
+	deps[HealthDependencyArchive] = archive
+
+	deps[HealthDependencyLocalBuilder] = prx.builderReadinessStatus()
+	deps[HealthDependencyEthRPC] = prx.ethHead.status()
+
+	signer := DependencyStatus{
+		Healthy: prx.signerRegistered.Load(),
+		Details: map[string]any{"address": prx.OrderflowSigner.Address().Hex()},
+	}
+	if !signer.Healthy {
+		signer.Error = "signer is not registered on BuilderHub"
+	}
+	deps[HealthDependencySignerRegistration] = signer
+
+	report := HealthReport{Status: healthStatusOK, Dependencies: deps}
+	for name, dep := range deps {
+		dep.Hard = slices.Contains(prx.healthHardDependencies, name)
+		deps[name] = dep
+		if dep.Healthy {
+			continue
+		}
+		if dep.Hard {
+			report.Status = healthStatusFailing
+		} else if report.Status == healthStatusOK {
+			report.Status = healthStatusDegraded
+		}
+	}
+	return report
+}
+
+// HealthzHandler is a liveness probe, it always returns 200 with the dependency report
+func (prx *ReceiverProxy) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, prx.HealthReport(), http.StatusOK)
+}
+
+// ReadyzHandler returns 503 if any of the hard dependencies is failing
+func (prx *ReceiverProxy) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := prx.HealthReport()
+	code := http.StatusOK
+	if report.Status == healthStatusFailing {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealthReport(w, report, code)
+}
+
+func writeHealthReport(w http.ResponseWriter, report HealthReport, code int) {
+	res, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(res)
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthReport(t *testing.T) {
+	prx := proxies[0].proxy
+	defer prx.signerRegistered.Store(prx.signerRegistered.Load())
+
+	readyz := func() (int, HealthReport) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		prx.ReadyzHandler(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var report HealthReport
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+		return rr.Code, report
+	}
+
+	// eth rpc is not set in tests so it's a failing soft dependency
+	prx.signerRegistered.Store(true)
+	code, report := readyz()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, healthStatusDegraded, report.Status)
+	require.False(t, report.Dependencies[HealthDependencyEthRPC].Healthy)
+	require.False(t, report.Dependencies[HealthDependencyEthRPC].Hard)
+	require.True(t, report.Dependencies[HealthDependencyBuilderHub].Healthy)
+	require.True(t, report.Dependencies[HealthDependencyArchive].Healthy)
+	require.True(t, report.Dependencies[HealthDependencySignerRegistration].Hard)
+
+	prx.signerRegistered.Store(false)
+	code, report = readyz()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, healthStatusFailing, report.Status)
+	require.False(t, report.Dependencies[HealthDependencySignerRegistration].Healthy)
+
+	// liveness does not depend on dependencies
+	rr := httptest.NewRecorder()
+	prx.HealthzHandler(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+}
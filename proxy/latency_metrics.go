@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// LatencyMetricsConfig configures how latency metrics are exported
+type LatencyMetricsConfig struct {
+	// HistogramBuckets are upper bounds of Prometheus compatible histogram buckets (in milliseconds)
+	// if empty VictoriaMetrics histograms with `vmrange` buckets are used
+	HistogramBuckets []float64
+	// LegacySummaries also exports latency metrics as summaries with the old metric names
+	LegacySummaries bool
+}
+
+var (
+	latencyMetricsConfig LatencyMetricsConfig
+	latencyObservers     sync.Map // full metric name -> *latencyObserver
+)
+
+// ConfigureLatencyMetrics should be called before proxies are created
+func ConfigureLatencyMetrics(config LatencyMetricsConfig) {
+	buckets := slices.Clone(config.HistogramBuckets)
+	slices.Sort(buckets)
+	config.HistogramBuckets = slices.Compact(buckets)
+	latencyMetricsConfig = config
+}
+
+// latencyObserver updates all enabled representations of the latency metric
+type latencyObserver struct {
+	summary         *metrics.Summary
+	vmHistogram     *metrics.Histogram
+	bucketHistogram *bucketHistogram
+}
+
+// getLatencyObserver returns observer for metric `name{labels}`,
+// histogram is exported as `name_histogram{labels}` so it does not collide with legacy summary
+func getLatencyObserver(name, labels string) *latencyObserver {
+	key := name + "{" + labels + "}"
+	if observer, ok := latencyObservers.Load(key); ok {
+		return observer.(*latencyObserver) //nolint:forcetypeassert
+	}
+
+	observer := &latencyObserver{}
+	if latencyMetricsConfig.LegacySummaries {
+		observer.summary = metrics.GetOrCreateSummary(key)
+	}
+	histogramName := name + "_histogram"
+	if len(latencyMetricsConfig.HistogramBuckets) > 0 {
+		observer.bucketHistogram = newBucketHistogram(histogramName, labels, latencyMetricsConfig.HistogramBuckets)
+	} else {
+		observer.vmHistogram = metrics.GetOrCreateHistogram(histogramName + "{" + labels + "}")
+	}
+
+	actual, _ := latencyObservers.LoadOrStore(key, observer)
+	return actual.(*latencyObserver) //nolint:forcetypeassert
+}
+
+func (o *latencyObserver) Update(millis float64) {
+	if o.summary != nil {
+		o.summary.Update(millis)
+	}
+	if o.vmHistogram != nil {
+		o.vmHistogram.Update(millis)
+	}
+	if o.bucketHistogram != nil {
+		o.bucketHistogram.Update(millis)
+	}
+}
+
+// bucketHistogram is Prometheus compatible histogram with fixed buckets built from counters
+// `name_bucket{labels,le="..."}`, `name_sum{labels}` and `name_count{labels}`
+type bucketHistogram struct {
+	upperBounds []float64
+	// buckets are cumulative, the last one is +Inf
+	buckets []*metrics.Counter
+	sum     *metrics.FloatCounter
+	count   *metrics.Counter
+}
+
+func newBucketHistogram(name, labels string, upperBounds []float64) *bucketHistogram {
+	labelsPrefix := labels
+	if labelsPrefix != "" {
+		labelsPrefix += ","
+	}
+	h := &bucketHistogram{
+		upperBounds: upperBounds,
+		buckets:     make([]*metrics.Counter, 0, len(upperBounds)+1),
+		sum:         metrics.GetOrCreateFloatCounter(name + "_sum{" + labels + "}"),
+		count:       metrics.GetOrCreateCounter(name + "_count{" + labels + "}"),
+	}
+	for _, ub := range upperBounds {
+		le := strconv.FormatFloat(ub, 'g', -1, 64)
+		h.buckets = append(h.buckets, metrics.GetOrCreateCounter(name+"_bucket{"+labelsPrefix+`le="`+le+`"}`))
+	}
+	h.buckets = append(h.buckets, metrics.GetOrCreateCounter(name+"_bucket{"+labelsPrefix+`le="+Inf"}`))
+	return h
+}
+
+func (h *bucketHistogram) Update(value float64) {
+	for i, ub := range h.upperBounds {
+		if value <= ub {
+			h.buckets[i].Inc()
+		}
+	}
+	h.buckets[len(h.buckets)-1].Inc()
+	h.sum.Add(value)
+	h.count.Inc()
+}
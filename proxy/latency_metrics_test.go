@@ -0,0 +1,25 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketHistogram(t *testing.T) {
+	h := newBucketHistogram("test_latency_histogram", `peer="a"`, []float64{1, 10})
+	h.Update(0.5)
+	h.Update(5)
+	h.Update(50)
+
+	var buf bytes.Buffer
+	metrics.WritePrometheus(&buf, false)
+	out := buf.String()
+	require.Contains(t, out, `test_latency_histogram_bucket{peer="a",le="1"} 1`)
+	require.Contains(t, out, `test_latency_histogram_bucket{peer="a",le="10"} 2`)
+	require.Contains(t, out, `test_latency_histogram_bucket{peer="a",le="+Inf"} 3`)
+	require.Contains(t, out, `test_latency_histogram_sum{peer="a"} 55.5`)
+	require.Contains(t, out, `test_latency_histogram_count{peer="a"} 3`)
+}
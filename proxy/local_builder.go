@@ -0,0 +1,370 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/flashbots/tdx-orderflow-proxy/common"
+)
+
+// modes of the local builders
+const (
+	// LocalBuilderModePrimary builders count toward errors and readiness, orders are held while they are not ready
+	LocalBuilderModePrimary = "primary"
+	// LocalBuilderModeMirror builders receive all orders, delivery is fire-and-forget
+	LocalBuilderModeMirror = "mirror"
+	// LocalBuilderModeCanary builders receive a sample of orders, delivery is fire-and-forget
+	LocalBuilderModeCanary = "canary"
+)
+
+var (
+	// LocalBuilderQueueSize is how many orders are held while the local builder is not ready
+	LocalBuilderQueueSize = 10000
+	// LocalBuilderReadyCheckInterval is how often readiness is checked while orders are held
+	LocalBuilderReadyCheckInterval = time.Millisecond * 100
+	// LocalBuilderMaxDeliveryAttempts limits how many times the order is sent if the builder can't be reached
+	LocalBuilderMaxDeliveryAttempts = 3
+
+	errLocalBuilderNoName        = errors.New("local builder name should be set")
+	errLocalBuilderNoEndpoint    = errors.New("local builder endpoint should be set")
+	errLocalBuilderMode          = errors.New("local builder mode should be primary, mirror or canary")
+	errLocalBuilderSamplePercent = errors.New("canary builder sample percent should be in (0, 100]")
+	errLocalBuilderReadyEndpoint = errors.New("only primary builder can have ready endpoint")
+	errLocalBuilderDuplicateName = errors.New("duplicate local builder name")
+	errLocalBuilderInvalidSpec   = errors.New("local builder should be specified as name:mode[:sample-percent]=endpoint")
+)
+
+// LocalBuilderConfig is a local destination of the orderflow
+type LocalBuilderConfig struct {
+	Name     string
+	Endpoint string
+	// Mode is one of LocalBuilderModePrimary (default), LocalBuilderModeMirror or LocalBuilderModeCanary
+	Mode string
+	// ReadyEndpoint is polled with /readyz, orders are held while it's failing (primary builders only)
+	ReadyEndpoint string
+	// SamplePercent of the orders is sent to the canary builder
+	SamplePercent float64
+}
+
+func (c *LocalBuilderConfig) validate() error {
+	if c.Name == "" {
+		return errLocalBuilderNoName
+	}
+	if c.Endpoint == "" {
+		return errLocalBuilderNoEndpoint
+	}
+	switch c.Mode {
+	case LocalBuilderModePrimary:
+	case LocalBuilderModeMirror, LocalBuilderModeCanary:
+		if c.ReadyEndpoint != "" {
+			return errLocalBuilderReadyEndpoint
+		}
+	default:
+		return errLocalBuilderMode
+	}
+	if c.Mode == LocalBuilderModeCanary && (c.SamplePercent <= 0 || c.SamplePercent > 100) {
+		return errLocalBuilderSamplePercent
+	}
+	return nil
+}
+
+// ParseLocalBuilderConfig parses local builder from "name:mode[:sample-percent]=endpoint",
+// e.g. "rbuilder-canary:canary:10=http://127.0.0.1:8646"
+func ParseLocalBuilderConfig(spec string) (LocalBuilderConfig, error) {
+	left, endpoint, ok := strings.Cut(spec, "=")
+	if !ok {
+		return LocalBuilderConfig{}, errLocalBuilderInvalidSpec
+	}
+	parts := strings.Split(left, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return LocalBuilderConfig{}, errLocalBuilderInvalidSpec
+	}
+	config := LocalBuilderConfig{
+		Name:     parts[0],
+		Mode:     parts[1],
+		Endpoint: endpoint,
+	}
+	if len(parts) == 3 {
+		percent, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return LocalBuilderConfig{}, errors.Join(errLocalBuilderSamplePercent, err)
+		}
+		config.SamplePercent = percent
+	}
+	return config, config.validate()
+}
+
+func validateLocalBuilders(builders []LocalBuilderConfig) error {
+	names := make(map[string]struct{}, len(builders))
+	for _, builder := range builders {
+		err := builder.validate()
+		if err != nil {
+			return fmt.Errorf("local builder %q: %w", builder.Name, err)
+		}
+		if _, ok := names[builder.Name]; ok {
+			return fmt.Errorf("%w: %s", errLocalBuilderDuplicateName, builder.Name)
+		}
+		names[builder.Name] = struct{}{}
+	}
+	return nil
+}
+
+// localBuilder is a local destination with its own queue, client, metrics and readiness
+type localBuilder struct {
+	config    LocalBuilderConfig
+	queue     *LocalBuilderQueue
+	readiness dependencyHealth
+}
+
+func newLocalBuilder(logger *slog.Logger, logSampler *common.LogSampler, config LocalBuilderConfig, workers int, staleFilter *StaleFilter) (*localBuilder, error) {
+	builder := &localBuilder{config: config}
+	var (
+		ready    func() bool
+		notReady func(err error)
+	)
+	if config.ReadyEndpoint != "" {
+		ready = builder.ready
+		notReady = builder.readiness.failure
+	}
+	queue, err := NewLocalBuilderQueue(logger, logSampler, config, workers, staleFilter, ready, notReady)
+	if err != nil {
+		return nil, err
+	}
+	builder.queue = queue
+	return builder, nil
+}
+
+// SendRequest queues the order, mirror and canary deliveries are not visible in the order status
+func (b *localBuilder) SendRequest(req *ParsedRequest) {
+	switch b.config.Mode {
+	case LocalBuilderModePrimary:
+		b.queue.SendRequest(req)
+		return
+	case LocalBuilderModeCanary:
+		if rand.Float64()*100 >= b.config.SamplePercent { //nolint:gosec
+			return
+		}
+	}
+	untracked := *req
+	untracked.ledgerEntry = nil
+	b.queue.SendRequest(&untracked)
+}
+
+// readinessStatus is cached result of /readyz, builders without ready endpoint are always ready
+func (b *localBuilder) readinessStatus() DependencyStatus {
+	if b.config.ReadyEndpoint == "" {
+		return DependencyStatus{Healthy: true, Details: map[string]any{"disabled": true}}
+	}
+	return b.readiness.status(HealthPollInterval*3, false)
+}
+
+func (b *localBuilder) ready() bool {
+	return b.readinessStatus().Healthy
+}
+
+// LocalBuilderQueue delivers orders to the local builder in background.
+// While the builder is not ready orders are held in the queue and replayed in order once it's ready again,
+// orders that expired while waiting are skipped. Orders are taken from the queue by a single dispatcher,
+// it hands them to the workers while the builder is ready and sends held orders itself one by one.
+type LocalBuilderQueue struct {
+	log         *slog.Logger
+	peer        *shareQueuePeer
+	staleFilter *StaleFilter
+	// ready reports if the builder can accept orders, if nil the builder is always considered ready
+	ready func() bool
+	// notReady is called when the builder can't be reached so orders are held until it's ready again,
+	// if nil failed orders are not retried
+	notReady func(err error)
+	// retrying is a number of workers retrying an order, the dispatcher holds the following orders meanwhile
+	retrying atomic.Int64
+	close    chan struct{}
+}
+
+func NewLocalBuilderQueue(logger *slog.Logger, logSampler *common.LogSampler, config LocalBuilderConfig, workers int, staleFilter *StaleFilter, ready func() bool, notReady func(err error)) (*LocalBuilderQueue, error) {
+	if workers <= 0 {
+		workers = 1
+	}
+	transport, err := NewPeerTransport(PeerTransportHTTP1, config.Endpoint, nil, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := &shareQueuePeer{
+		ch:      make(chan *ParsedRequest, LocalBuilderQueueSize),
+		name:    config.Name,
+		workers: newPeerWorkers(workers, workers),
+		closed:  make(chan struct{}),
+		client:  &shareQueuePeerClient{transport: transport},
+
+		sendErrorLogLevel: slog.LevelDebug,
+		logSampler:        logSampler,
+	}
+	if config.Mode == LocalBuilderModePrimary {
+		peer.sendErrorLogLevel = slog.LevelWarn
+	}
+	peer.metricsSet = newShareQueuePeerMetrics(peer)
+	peer.metrics = newShareQueuePeerMetricHandles(config.Name)
+
+	q := &LocalBuilderQueue{
+		log:         logger.With(slog.String("peer", config.Name)),
+		peer:        peer,
+		staleFilter: staleFilter,
+		ready:       ready,
+		notReady:    notReady,
+		close:       make(chan struct{}),
+	}
+	peer.workers.current.Store(int64(workers))
+	go q.dispatch(workers)
+	return q, nil
+}
+
+// SendRequest queues the order, it is dropped if the queue is full
+func (q *LocalBuilderQueue) SendRequest(req *ParsedRequest) {
+	q.peer.SendRequest(q.log, req)
+}
+
+func (q *LocalBuilderQueue) queueInfo() AdminQueueInfo {
+	return AdminQueueInfo{
+		Name:                 "local_builder:" + q.peer.name,
+		Length:               len(q.peer.ch),
+		Capacity:             cap(q.peer.ch),
+		OldestItemAgeSeconds: q.peer.queueAge.ageSeconds(len(q.peer.ch)),
+	}
+}
+
+func (q *LocalBuilderQueue) Close() {
+	close(q.close)
+	q.peer.Close()
+}
+
+// waitReady blocks until the builder is ready, it returns false if the queue was closed
+func (q *LocalBuilderQueue) waitReady() bool {
+	_, ok := q.wait(q.isReady)
+	return ok
+}
+
+// waitSendable blocks until the builder is ready and no order is being retried,
+// waited is true if it had to wait and ok is false if the queue was closed
+func (q *LocalBuilderQueue) waitSendable() (waited, ok bool) {
+	return q.wait(q.sendable)
+}
+
+func (q *LocalBuilderQueue) isReady() bool {
+	return q.ready == nil || q.ready()
+}
+
+func (q *LocalBuilderQueue) sendable() bool {
+	return q.retrying.Load() == 0 && q.isReady()
+}
+
+func (q *LocalBuilderQueue) wait(cond func() bool) (waited, ok bool) {
+	if cond() {
+		return false, true
+	}
+	ticker := time.NewTicker(LocalBuilderReadyCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.close:
+			return true, false
+		case <-ticker.C:
+		}
+		if cond() {
+			return true, true
+		}
+	}
+}
+
+// dispatch takes orders from the queue in order. Orders are taken only when the builder is ready and no order
+// is being retried, orders held meanwhile are sent by the dispatcher one by one until the queue is empty.
+func (q *LocalBuilderQueue) dispatch(workers int) {
+	orders := make(chan *ParsedRequest)
+	defer close(orders)
+	for worker := range workers {
+		go q.proxyRequests(worker, orders)
+	}
+	logger := q.log.With(slog.String("worker", "dispatcher"))
+
+	sequential := false
+	for {
+		waited, ok := q.waitSendable()
+		if !ok {
+			return
+		}
+		sequential = sequential || waited
+		req, more := <-q.peer.ch
+		if !more {
+			return
+		}
+		q.peer.queueAge.dequeued(req, q.peer.ch)
+
+		if !sequential && !q.sendable() {
+			// builder failed while the dispatcher was waiting for the order
+			sequential = true
+			if _, ok := q.waitSendable(); !ok {
+				return
+			}
+		}
+		if sequential {
+			if !q.deliver(logger, req) {
+				return
+			}
+			sequential = len(q.peer.ch) > 0
+			continue
+		}
+		select {
+		case orders <- req:
+		case <-q.close:
+			return
+		}
+	}
+}
+
+func (q *LocalBuilderQueue) proxyRequests(worker int, orders <-chan *ParsedRequest) {
+	logger := q.log.With(slog.Int("worker", worker))
+	for req := range orders {
+		if !q.deliver(logger, req) {
+			return
+		}
+	}
+}
+
+// deliver sends the order, if the builder can't be reached the order is retried once it's ready again.
+// It returns false if the queue was closed.
+func (q *LocalBuilderQueue) deliver(logger *slog.Logger, req *ParsedRequest) bool {
+	retrying := false
+	defer func() {
+		if retrying {
+			q.retrying.Add(-1)
+		}
+	}()
+	for attempt := 1; ; attempt++ {
+		if q.staleFilter.Expired(req.validity) {
+			q.peer.metrics.expiredRequests.Inc()
+			req.ledgerEntry.record(q.peer.name, DeliveryStatusExpired, nil)
+			return true
+		}
+
+		q.peer.inflight.Add(1)
+		err := sendShareRequest(logger, req, q.peer)
+		q.peer.inflight.Add(-1)
+		if err == nil || q.notReady == nil || attempt >= LocalBuilderMaxDeliveryAttempts {
+			return true
+		}
+		// builder is likely restarting, the dispatcher holds the following orders until this one is retried
+		if !retrying {
+			retrying = true
+			q.retrying.Add(1)
+		}
+		q.notReady(err)
+		if !q.waitReady() {
+			return false
+		}
+	}
+}
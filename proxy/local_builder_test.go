@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalBuilderQueue(t *testing.T) {
+	builderRequests := make(chan *RequestData, 1)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+
+	defer func(interval time.Duration) { LocalBuilderReadyCheckInterval = interval }(LocalBuilderReadyCheckInterval)
+	LocalBuilderReadyCheckInterval = time.Millisecond * 10
+
+	var ready atomic.Bool
+	queue, err := NewLocalBuilderQueue(slog.Default(), nil, LocalBuilderConfig{Name: "test-builder", Endpoint: builder.URL, Mode: LocalBuilderModePrimary}, 1, nil, ready.Load, func(error) { ready.Store(false) })
+	require.NoError(t, err)
+	defer queue.Close()
+
+	newRequest := func(id int, maxTimestamp uint64) *ParsedRequest {
+		return &ParsedRequest{
+			method:                   EthSendBundleMethod,
+			receivedAt:               time.Now(),
+			validity:                 orderValidity{maxTimestamp: maxTimestamp},
+			serializedJSONRPCRequest: []byte(fmt.Sprintf(`{"id":%d}`, id)),
+		}
+	}
+
+	// orders are held while the builder is not ready
+	queue.SendRequest(newRequest(1, 0))
+	// expired while waiting
+	queue.SendRequest(newRequest(2, uint64(time.Now().Add(-time.Second).Unix())))
+	queue.SendRequest(newRequest(3, 0))
+	expectNoRequest(t, builderRequests)
+	require.Equal(t, 3, queue.queueInfo().Length)
+
+	// held orders are replayed in order when the builder is ready
+	ready.Store(true)
+	require.Equal(t, `{"id":1}`, expectRequest(t, builderRequests).body)
+	require.Equal(t, `{"id":3}`, expectRequest(t, builderRequests).body)
+	expectNoRequest(t, builderRequests)
+
+	// unreachable builder is marked as not ready and the order is retried once it's ready again
+	failing, err := NewLocalBuilderQueue(slog.Default(), nil, LocalBuilderConfig{Name: "test-failing-builder", Endpoint: "http://127.0.0.1:1", Mode: LocalBuilderModePrimary}, 1, nil, ready.Load, func(error) { ready.Store(false) })
+	require.NoError(t, err)
+	defer failing.Close()
+	failing.SendRequest(newRequest(4, 0))
+	require.Eventually(t, func() bool { return !ready.Load() }, time.Second, time.Millisecond*10)
+}
+
+func TestLocalBuilderQueueReplayOrder(t *testing.T) {
+	builderRequests := make(chan *RequestData, 100)
+	builder := ServeHTTPRequestToChan(builderRequests)
+	defer builder.Close()
+
+	defer func(interval time.Duration) { LocalBuilderReadyCheckInterval = interval }(LocalBuilderReadyCheckInterval)
+	LocalBuilderReadyCheckInterval = time.Millisecond * 10
+
+	var ready atomic.Bool
+	queue, err := NewLocalBuilderQueue(slog.Default(), nil, LocalBuilderConfig{Name: "test-ordered-builder", Endpoint: builder.URL, Mode: LocalBuilderModePrimary}, 4, nil, ready.Load, func(error) { ready.Store(false) })
+	require.NoError(t, err)
+	defer queue.Close()
+
+	// held orders are replayed in order even with multiple workers
+	for id := range 50 {
+		queue.SendRequest(&ParsedRequest{
+			method:                   EthSendBundleMethod,
+			receivedAt:               time.Now(),
+			serializedJSONRPCRequest: []byte(fmt.Sprintf(`{"id":%d}`, id)),
+		})
+	}
+	expectNoRequest(t, builderRequests)
+	ready.Store(true)
+	for id := range 50 {
+		require.Equal(t, fmt.Sprintf(`{"id":%d}`, id), expectRequest(t, builderRequests).body)
+	}
+}
+
+func TestLocalBuilderQueueSendAfterClose(t *testing.T) {
+	queue, err := NewLocalBuilderQueue(slog.Default(), nil, LocalBuilderConfig{Name: "test-closed-builder", Endpoint: "http://127.0.0.1:1", Mode: LocalBuilderModePrimary}, 1, nil, func() bool { return false }, nil)
+	require.NoError(t, err)
+
+	// request goroutines can still send while the proxy is stopping
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				queue.SendRequest(&ParsedRequest{method: EthSendBundleMethod, receivedAt: time.Now()})
+			}
+		}()
+	}
+	queue.Close()
+	wg.Wait()
+	queue.SendRequest(&ParsedRequest{method: EthSendBundleMethod, receivedAt: time.Now()})
+}
+
+func TestLocalBuilders(t *testing.T) {
+	config, err := ParseLocalBuilderConfig("rbuilder-canary:canary:10=http://127.0.0.1:8646")
+	require.NoError(t, err)
+	require.Equal(t, LocalBuilderConfig{Name: "rbuilder-canary", Mode: LocalBuilderModeCanary, SamplePercent: 10, Endpoint: "http://127.0.0.1:8646"}, config)
+	_, err = ParseLocalBuilderConfig("rbuilder-canary:canary=http://127.0.0.1:8646")
+	require.ErrorIs(t, err, errLocalBuilderSamplePercent)
+	_, err = ParseLocalBuilderConfig("rbuilder-mirror:shadow=http://127.0.0.1:8646")
+	require.ErrorIs(t, err, errLocalBuilderMode)
+	_, err = ParseLocalBuilderConfig("http://127.0.0.1:8646")
+	require.ErrorIs(t, err, errLocalBuilderInvalidSpec)
+	err = validateLocalBuilders([]LocalBuilderConfig{
+		{Name: "a", Mode: LocalBuilderModePrimary, Endpoint: "http://127.0.0.1:8645"},
+		{Name: "a", Mode: LocalBuilderModeMirror, Endpoint: "http://127.0.0.1:8646"},
+	})
+	require.ErrorIs(t, err, errLocalBuilderDuplicateName)
+
+	newBuilder := func(config LocalBuilderConfig) (*localBuilder, chan *RequestData) {
+		requests := make(chan *RequestData, 1)
+		server := ServeHTTPRequestToChan(requests)
+		t.Cleanup(server.Close)
+		config.Endpoint = server.URL
+		builder, err := newLocalBuilder(slog.Default(), nil, config, 1, nil)
+		require.NoError(t, err)
+		t.Cleanup(builder.queue.Close)
+		return builder, requests
+	}
+	primary, primaryRequests := newBuilder(LocalBuilderConfig{Name: "test-primary", Mode: LocalBuilderModePrimary})
+	mirror, mirrorRequests := newBuilder(LocalBuilderConfig{Name: "test-mirror", Mode: LocalBuilderModeMirror})
+	canary, canaryRequests := newBuilder(LocalBuilderConfig{Name: "test-canary", Mode: LocalBuilderModeCanary, SamplePercent: 0.0001})
+
+	ledger, err := NewOrderLedger(10, "")
+	require.NoError(t, err)
+	req := &ParsedRequest{
+		method:                   EthSendRawTransactionMethod,
+		receivedAt:               time.Now(),
+		txs:                      []DecodedTx{{Hash: common.HexToHash("0x01")}},
+		serializedJSONRPCRequest: []byte(`{"id":0}`),
+	}
+	req.ledgerEntry = ledger.Add(req)
+	require.NotNil(t, req.ledgerEntry)
+
+	for _, builder := range []*localBuilder{primary, mirror, canary} {
+		builder.SendRequest(req)
+	}
+	expectRequest(t, primaryRequests)
+	expectRequest(t, mirrorRequests)
+	expectNoRequest(t, canaryRequests)
+
+	// only primary builder is visible in the order status
+	txHash := common.HexToHash("0x01")
+	require.Eventually(t, func() bool {
+		status, err := ledger.Status(common.Address{}, &GetOrderStatusArgs{TxHash: &txHash})
+		require.NoError(t, err)
+		return status.Destinations["test-primary"] != nil && status.Destinations["test-primary"].Status == DeliveryStatusDelivered
+	}, time.Second, time.Millisecond*10)
+	status, err := ledger.Status(common.Address{}, &GetOrderStatusArgs{TxHash: &txHash})
+	require.NoError(t, err)
+	require.Len(t, status.Destinations, 1)
+
+	// only primary builders participate in readiness
+	mirror.config.ReadyEndpoint = "http://127.0.0.1:1"
+	mirror.readiness.failure(errors.New("not ready"))
+	prx := &ReceiverProxy{localBuilders: []*localBuilder{primary, mirror, canary}}
+	require.True(t, prx.builderReadinessStatus().Healthy)
+	primary.config.ReadyEndpoint = "http://127.0.0.1:1"
+	primary.readiness.failure(errors.New("not ready"))
+	require.False(t, prx.builderReadinessStatus().Healthy)
+}
@@ -2,6 +2,8 @@ package proxy
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
@@ -12,7 +14,6 @@ var (
 	archiveEventsProcessedErrCounter   = metrics.NewCounter("orderflow_proxy_archive_events_processed_err")
 	// number of events sent successfully to orderflow archive. i.e. if we batch 10 events into 1 request this would be increment by 10
 	archiveEventsRPCSentCounter = metrics.NewCounter("orderflow_proxy_archive_events_sent_ok")
-	archiveEventsRPCDuration    = metrics.NewSummary("orderflow_proxy_archive_rpc_duration_milliseconds")
 	archiveEventsRPCErrors      = metrics.NewCounter("orderflow_proxy_archive_rpc_errors")
 
 	confighubErrorsCounter = metrics.NewCounter("orderflow_proxy_confighub_errors")
@@ -20,6 +21,8 @@ var (
 	shareQueueInternalErrors = metrics.NewCounter("orderflow_proxy_share_queue_internal_errors")
 
 	apiUserRateLimits = metrics.NewCounter("orderflow_proxy_api_user_rate_limits")
+
+	replayProtectionLegacyAccepted = metrics.NewCounter("orderflow_proxy_replay_protection_legacy_accepted")
 )
 
 const (
@@ -28,11 +31,51 @@ const (
 
 	shareQueuePeerStallingErrorsLabel = `orderflow_proxy_share_queue_peer_stalling_errors{peer="%s"}`
 	shareQueuePeerRPCErrorsLabel      = `orderflow_proxy_share_queue_peer_rpc_errors{peer="%s"}`
-	shareQueuePeerRPCDurationLabel    = `orderflow_proxy_share_queue_peer_rpc_duration_milliseconds{peer="%s",is_big="%t"}`
-	shareQueuePeerE2EDurationLabel    = `orderflow_proxy_share_queue_peer_e2e_duration_milliseconds{peer="%s",method="%s",system_endpoint="%t",is_big="%t"}`
-	shareQueuePeerQueueDurationLabel  = `orderflow_proxy_share_queue_peer_queue_duration_milliseconds{peer="%s",method="%s",system_endpoint="%t",is_big="%t"}`
+	shareQueuePeerDeliveredLabel      = `orderflow_proxy_share_queue_peer_delivered_requests{peer="%s"}`
+
+	// latency metrics are exported as histograms `<name>_histogram` (and optionally as legacy summaries `<name>`)
+	archiveRPCDurationName              = "orderflow_proxy_archive_rpc_duration_milliseconds"
+	shareQueuePeerRPCDurationName       = "orderflow_proxy_share_queue_peer_rpc_duration_milliseconds"
+	shareQueuePeerRPCDurationLabels     = `peer="%s",is_big="%t"`
+	shareQueuePeerE2EDurationName       = "orderflow_proxy_share_queue_peer_e2e_duration_milliseconds"
+	shareQueuePeerQueueDurationName     = "orderflow_proxy_share_queue_peer_queue_duration_milliseconds"
+	shareQueuePeerRequestDurationLabels = `peer="%s",method="%s",system_endpoint="%t",is_big="%t"`
+
+	queueLengthLabel        = `orderflow_proxy_queue_length{queue="%s"}`
+	queueCapacityLabel      = `orderflow_proxy_queue_capacity{queue="%s"}`
+	queueOldestItemAgeLabel = `orderflow_proxy_queue_oldest_item_age_seconds{queue="%s"}`
+
+	shareQueuePeerLengthLabel           = `orderflow_proxy_share_queue_peer_length{peer="%s"}`
+	shareQueuePeerCapacityLabel         = `orderflow_proxy_share_queue_peer_capacity{peer="%s"}`
+	shareQueuePeerOldestItemAgeLabel    = `orderflow_proxy_share_queue_peer_oldest_item_age_seconds{peer="%s"}`
+	shareQueuePeerInflightRequestsLabel = `orderflow_proxy_share_queue_peer_inflight_requests{peer="%s"}`
+	shareQueuePeerWorkersLabel          = `orderflow_proxy_share_queue_peer_workers{peer="%s"}`
+
+	archiveWorkerPendingBatchLabel         = `orderflow_proxy_archive_worker_pending_batch_size{queue="%s",worker="%d"}`
+	archiveWorkerPendingBatchCapacityLabel = `orderflow_proxy_archive_worker_pending_batch_capacity{queue="%s",worker="%d"}`
+
+	replayProtectionRejectedLabel = `orderflow_proxy_replay_protection_rejected{reason="%s"}`
+
+	configReloadsLabel = `orderflow_proxy_config_reloads{result="%s"}`
+
+	batchLengthLabel   = `orderflow_proxy_api_batch_length{server_name="%s"}`
+	batchRejectedLabel = `orderflow_proxy_api_batch_rejected{server_name="%s",reason="%s"}`
+
+	staleOrdersRejectedLabel           = `orderflow_proxy_api_stale_orders_rejected{method="%s",reason="%s"}`
+	shareQueuePeerExpiredRequestsLabel = `orderflow_proxy_share_queue_peer_expired_requests{peer="%s"}`
+
+	signerPolicyDecisionsLabel = `orderflow_proxy_api_signer_policy_decisions{decision="%s"}`
 
-	requestDurationLabel = `orderflow_proxy_api_request_processing_duration_milliseconds{method="%s",server_name="%s",step="%s"}`
+	tlsCertReloadsLabel = `orderflow_proxy_tls_cert_reloads{result="%s"}`
+
+	peerStreamHandshakesLabel         = `orderflow_proxy_peer_stream_handshakes{side="%s",result="%s"}`
+	shareQueuePeerStreamFallbackLabel = `orderflow_proxy_share_queue_peer_stream_fallback_requests{peer="%s"}`
+
+	dedupeRequestsLabel  = `orderflow_proxy_dedupe_requests{method="%s",origin="%s",result="%s"}`
+	dedupeEvictionsLabel = `orderflow_proxy_dedupe_evictions{method="%s",origin="%s"}`
+
+	requestDurationName   = "orderflow_proxy_api_request_processing_duration_milliseconds"
+	requestDurationLabels = `method="%s",server_name="%s",step="%s"`
 )
 
 func incAPIIncomingRequestsByPeer(peer string) {
@@ -49,35 +92,269 @@ func incAPIUserRateLimits() {
 	apiUserRateLimits.Inc()
 }
 
-func incShareQueuePeerStallingErrors(peer string) {
-	l := fmt.Sprintf(shareQueuePeerStallingErrorsLabel, peer)
+func timeArchiveRPCDuration(duration time.Duration) {
+	getLatencyObserver(archiveRPCDurationName, "").Update(float64(duration.Milliseconds()))
+}
+
+func incRequestDurationStep(duration time.Duration, method, serverName, step string) {
+	millis := float64(duration.Microseconds()) / 1000.0
+	l := fmt.Sprintf(requestDurationLabels, method, serverName, step)
+	getLatencyObserver(requestDurationName, l).Update(millis)
+}
+
+func incReplayProtectionRejected(reason string) {
+	l := fmt.Sprintf(replayProtectionRejectedLabel, reason)
 	metrics.GetOrCreateCounter(l).Inc()
 }
 
-func incShareQueuePeerRPCErrors(peer string) {
-	l := fmt.Sprintf(shareQueuePeerRPCErrorsLabel, peer)
+func incReplayProtectionLegacyAccepted() {
+	replayProtectionLegacyAccepted.Inc()
+}
+
+func incConfigReloads(ok bool) {
+	result := "ok"
+	if !ok {
+		result = "error"
+	}
+	l := fmt.Sprintf(configReloadsLabel, result)
 	metrics.GetOrCreateCounter(l).Inc()
 }
 
-func timeShareQueuePeerRPCDuration(peer string, duration int64, bigRequest bool) {
-	l := fmt.Sprintf(shareQueuePeerRPCDurationLabel, peer, bigRequest)
-	metrics.GetOrCreateSummary(l).Update(float64(duration))
+// queueAgeTracker tracks age of the oldest item in the channel based FIFO queue without locks.
+// Queue can't be peeked so only the enqueue time of the head is kept: producer that finds no head sets it
+// after the send, and when the consumer takes an item the next head is not older than the taken request.
+// The head is cleared when the queue becomes empty so the age doesn't include idle periods.
+type queueAgeTracker struct {
+	headEnqueuedAt atomic.Int64
 }
 
-func timeShareQueuePeerE2EDuration(peer string, duration time.Duration, method string, systemEndpoint, bigRequest bool) {
-	millis := float64(duration.Microseconds()) / 1000.0
-	l := fmt.Sprintf(shareQueuePeerE2EDurationLabel, peer, method, systemEndpoint, bigRequest)
-	metrics.GetOrCreateSummary(l).Update(float64(millis))
+// enqueued records that an item was sent to the queue
+func (t *queueAgeTracker) enqueued() {
+	t.headEnqueuedAt.CompareAndSwap(0, time.Now().UnixMicro())
 }
 
-func timeShareQueuePeerQueueDuration(peer string, duration time.Duration, method string, systemEndpoint, bigRequest bool) {
-	millis := float64(duration.Microseconds()) / 1000.0
-	l := fmt.Sprintf(shareQueuePeerQueueDurationLabel, peer, method, systemEndpoint, bigRequest)
-	metrics.GetOrCreateSummary(l).Update(float64(millis))
+// dequeued records that the consumer took the request from the queue
+func (t *queueAgeTracker) dequeued(req *ParsedRequest, ch chan *ParsedRequest) {
+	if len(ch) > 0 {
+		t.headEnqueuedAt.Store(req.receivedAt.UnixMicro())
+		return
+	}
+	t.headEnqueuedAt.Store(0)
+	// producer could send before the head was cleared and find it already set
+	if len(ch) > 0 {
+		t.headEnqueuedAt.CompareAndSwap(0, time.Now().UnixMicro())
+	}
 }
 
-func incRequestDurationStep(duration time.Duration, method, serverName, step string) {
-	millis := float64(duration.Microseconds()) / 1000.0
-	l := fmt.Sprintf(requestDurationLabel, method, serverName, step)
-	metrics.GetOrCreateSummary(l).Update(millis)
+func (t *queueAgeTracker) ageSeconds(queueLength int) float64 {
+	if queueLength == 0 {
+		return 0
+	}
+	enqueuedAt := t.headEnqueuedAt.Load()
+	if enqueuedAt == 0 {
+		return 0
+	}
+	return time.Since(time.UnixMicro(enqueuedAt)).Seconds()
+}
+
+// queueMetricsName distinguishes queues of multiple proxies running in the same process
+func queueMetricsName(queue, proxyName string) string {
+	if proxyName == "" {
+		return queue
+	}
+	return queue + "_" + proxyName
+}
+
+// registerQueueGauges registers length, capacity and oldest item age gauges for the queue in the set
+func registerQueueGauges(set *metrics.Set, queue string, ch chan *ParsedRequest, age *queueAgeTracker) {
+	set.NewGauge(fmt.Sprintf(queueLengthLabel, queue), func() float64 {
+		return float64(len(ch))
+	})
+	set.NewGauge(fmt.Sprintf(queueCapacityLabel, queue), func() float64 {
+		return float64(cap(ch))
+	})
+	set.NewGauge(fmt.Sprintf(queueOldestItemAgeLabel, queue), func() float64 {
+		return age.ageSeconds(len(ch))
+	})
+}
+
+// newShareQueuePeerMetrics creates registered set with gauges for the peer, it should be unregistered when peer is closed
+func newShareQueuePeerMetrics(peer *shareQueuePeer) *metrics.Set {
+	set := metrics.NewSet()
+	set.NewGauge(fmt.Sprintf(shareQueuePeerLengthLabel, peer.name), func() float64 {
+		return float64(len(peer.ch))
+	})
+	set.NewGauge(fmt.Sprintf(shareQueuePeerCapacityLabel, peer.name), func() float64 {
+		return float64(cap(peer.ch))
+	})
+	set.NewGauge(fmt.Sprintf(shareQueuePeerOldestItemAgeLabel, peer.name), func() float64 {
+		return peer.queueAge.ageSeconds(len(peer.ch))
+	})
+	set.NewGauge(fmt.Sprintf(shareQueuePeerInflightRequestsLabel, peer.name), func() float64 {
+		return float64(peer.inflight.Load())
+	})
+	set.NewGauge(fmt.Sprintf(shareQueuePeerWorkersLabel, peer.name), func() float64 {
+		return float64(peer.workers.current.Load())
+	})
+	metrics.RegisterSet(set)
+	return set
+}
+
+func registerArchiveWorkerGauges(set *metrics.Set, queue string, worker int, pending *atomic.Int64) {
+	set.NewGauge(fmt.Sprintf(archiveWorkerPendingBatchLabel, queue, worker), func() float64 {
+		return float64(pending.Load())
+	})
+	set.NewGauge(fmt.Sprintf(archiveWorkerPendingBatchCapacityLabel, queue, worker), func() float64 {
+		return float64(ArchiveBatchSize)
+	})
+}
+
+func observeBatchLength(serverName string, length int) {
+	l := fmt.Sprintf(batchLengthLabel, serverName)
+	metrics.GetOrCreateHistogram(l).Update(float64(length))
+}
+
+func incBatchRejected(serverName, reason string) {
+	l := fmt.Sprintf(batchRejectedLabel, serverName, reason)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incStaleOrdersRejected(method, reason string) {
+	l := fmt.Sprintf(staleOrdersRejectedLabel, method, reason)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incSignerPolicyDecision(decision string) {
+	l := fmt.Sprintf(signerPolicyDecisionsLabel, decision)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incTLSCertReloads(ok bool) {
+	result := "ok"
+	if !ok {
+		result = "error"
+	}
+	l := fmt.Sprintf(tlsCertReloadsLabel, result)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+func incPeerStreamHandshakes(side, result string) {
+	l := fmt.Sprintf(peerStreamHandshakesLabel, side, result)
+	metrics.GetOrCreateCounter(l).Inc()
+}
+
+// dedupeMetricHandles are dedupe counters resolved on the first request with the given method and origin,
+// so checking the dedupe does not format metric names and look them up in the registry
+type dedupeMetricHandles struct {
+	mu       sync.RWMutex
+	byLabels map[dedupeMetricLabels]*dedupeCounters
+}
+
+type dedupeMetricLabels struct {
+	method string
+	origin string
+}
+
+type dedupeCounters struct {
+	hits      *metrics.Counter
+	misses    *metrics.Counter
+	evictions *metrics.Counter
+}
+
+func newDedupeMetricHandles() *dedupeMetricHandles {
+	return &dedupeMetricHandles{byLabels: make(map[dedupeMetricLabels]*dedupeCounters)}
+}
+
+func (m *dedupeMetricHandles) counters(method, origin string) *dedupeCounters {
+	labels := dedupeMetricLabels{method: method, origin: origin}
+	m.mu.RLock()
+	counters, ok := m.byLabels[labels]
+	m.mu.RUnlock()
+	if ok {
+		return counters
+	}
+
+	counters = &dedupeCounters{
+		hits:      metrics.GetOrCreateCounter(fmt.Sprintf(dedupeRequestsLabel, method, origin, "hit")),
+		misses:    metrics.GetOrCreateCounter(fmt.Sprintf(dedupeRequestsLabel, method, origin, "miss")),
+		evictions: metrics.GetOrCreateCounter(fmt.Sprintf(dedupeEvictionsLabel, method, origin)),
+	}
+	m.mu.Lock()
+	m.byLabels[labels] = counters
+	m.mu.Unlock()
+	return counters
+}
+
+// shareQueuePeerMetricHandles are metrics of the peer resolved when the peer is created,
+// so sending requests does not format metric names and look them up in the registry
+type shareQueuePeerMetricHandles struct {
+	peer string
+
+	stallingErrors    *metrics.Counter
+	rpcErrors         *metrics.Counter
+	deliveredRequests *metrics.Counter
+	expiredRequests   *metrics.Counter
+	// rpcDuration is indexed by is_big label
+	rpcDuration [2]*latencyObserver
+
+	// requestDurations are resolved on the first request with the given labels
+	requestDurationsMu sync.RWMutex
+	requestDurations   map[shareQueuePeerRequestLabels]shareQueuePeerRequestDurations
+}
+
+type shareQueuePeerRequestLabels struct {
+	method         string
+	systemEndpoint bool
+	isBig          bool
+}
+
+type shareQueuePeerRequestDurations struct {
+	queue *latencyObserver
+	e2e   *latencyObserver
+}
+
+func newShareQueuePeerMetricHandles(peer string) *shareQueuePeerMetricHandles {
+	return &shareQueuePeerMetricHandles{
+		peer:              peer,
+		stallingErrors:    metrics.GetOrCreateCounter(fmt.Sprintf(shareQueuePeerStallingErrorsLabel, peer)),
+		rpcErrors:         metrics.GetOrCreateCounter(fmt.Sprintf(shareQueuePeerRPCErrorsLabel, peer)),
+		deliveredRequests: metrics.GetOrCreateCounter(fmt.Sprintf(shareQueuePeerDeliveredLabel, peer)),
+		expiredRequests:   metrics.GetOrCreateCounter(fmt.Sprintf(shareQueuePeerExpiredRequestsLabel, peer)),
+		rpcDuration: [2]*latencyObserver{
+			getLatencyObserver(shareQueuePeerRPCDurationName, fmt.Sprintf(shareQueuePeerRPCDurationLabels, peer, false)),
+			getLatencyObserver(shareQueuePeerRPCDurationName, fmt.Sprintf(shareQueuePeerRPCDurationLabels, peer, true)),
+		},
+		requestDurations: make(map[shareQueuePeerRequestLabels]shareQueuePeerRequestDurations),
+	}
+}
+
+func (m *shareQueuePeerMetricHandles) requestDurationObservers(labels shareQueuePeerRequestLabels) shareQueuePeerRequestDurations {
+	m.requestDurationsMu.RLock()
+	durations, ok := m.requestDurations[labels]
+	m.requestDurationsMu.RUnlock()
+	if ok {
+		return durations
+	}
+
+	l := fmt.Sprintf(shareQueuePeerRequestDurationLabels, m.peer, labels.method, labels.systemEndpoint, labels.isBig)
+	durations = shareQueuePeerRequestDurations{
+		queue: getLatencyObserver(shareQueuePeerQueueDurationName, l),
+		e2e:   getLatencyObserver(shareQueuePeerE2EDurationName, l),
+	}
+	m.requestDurationsMu.Lock()
+	m.requestDurations[labels] = durations
+	m.requestDurationsMu.Unlock()
+	return durations
+}
+
+func (m *shareQueuePeerMetricHandles) observeRequest(req *ParsedRequest, timeInQueue, requestDuration time.Duration) {
+	isBig := req.size >= bigRequestSize
+	bigIndex := 0
+	if isBig {
+		bigIndex = 1
+	}
+	m.rpcDuration[bigIndex].Update(float64(requestDuration.Milliseconds()))
+	durations := m.requestDurationObservers(shareQueuePeerRequestLabels{method: req.method, systemEndpoint: req.systemEndpoint, isBig: isBig})
+	durations.queue.Update(float64(timeInQueue.Microseconds()) / 1000.0)
+	durations.e2e.Update(float64((timeInQueue + requestDuration).Microseconds()) / 1000.0)
 }
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueAgeTracker(t *testing.T) {
+	var tracker queueAgeTracker
+	queue := make(chan *ParsedRequest, 2)
+	require.Zero(t, tracker.ageSeconds(len(queue)))
+
+	send := func() {
+		queue <- &ParsedRequest{receivedAt: time.Now()}
+		tracker.enqueued()
+	}
+	receive := func() {
+		tracker.dequeued(<-queue, queue)
+	}
+
+	send()
+	time.Sleep(time.Millisecond * 50)
+	send()
+	require.GreaterOrEqual(t, tracker.ageSeconds(len(queue)), 0.05)
+
+	// the head is the second item now, it's not older than the taken item
+	receive()
+	require.Less(t, tracker.ageSeconds(len(queue)), 0.1)
+	receive()
+	require.Zero(t, tracker.ageSeconds(len(queue)))
+
+	// after the idle period the new item is not older than the time it was enqueued
+	time.Sleep(time.Millisecond * 50)
+	send()
+	require.Less(t, tracker.ageSeconds(len(queue)), 0.05)
+}
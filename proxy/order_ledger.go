@@ -0,0 +1,303 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+const GetOrderStatusMethod = "flashbots_getOrderStatus"
+
+var (
+	// DefaultOrderLedgerSize is how many keys (bundle hashes, tx hashes and replacement uuids) ledger keeps
+	DefaultOrderLedgerSize = 100_000
+	// OrderLedgerTTL is how long order status can be queried
+	OrderLedgerTTL = time.Hour
+	// OrderLedgerSaveInterval is how often ledger and other state of the proxy is saved to disk if the file is set
+	OrderLedgerSaveInterval = time.Minute
+
+	errOrderStatusNoKey = errors.New("one of bundleHash, txHash or replacementUuid should be set")
+)
+
+// destinations of the order that are not peers
+const (
+	DeliveryDestinationLocalBuilder = localBuilderPeerName
+	DeliveryDestinationArchive      = "archive"
+)
+
+const (
+	DeliveryStatusQueued    = "queued"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+	// DeliveryStatusExpired is set when the order target block passed while the order was queued
+	DeliveryStatusExpired = "expired"
+	// DeliveryStatusDropped is set when the queue of the destination was full
+	DeliveryStatusDropped = "dropped"
+)
+
+// GetOrderStatusArgs are params of flashbots_getOrderStatus, exactly one field should be set
+type GetOrderStatusArgs struct {
+	BundleHash      *common.Hash `json:"bundleHash,omitempty"`
+	TxHash          *common.Hash `json:"txHash,omitempty"`
+	ReplacementUUID *uuid.UUID   `json:"replacementUuid,omitempty"`
+}
+
+// OrderStatus is the result of flashbots_getOrderStatus
+type OrderStatus struct {
+	Method string         `json:"method"`
+	Signer common.Address `json:"signer"`
+	// ReceivedAt is a unix millisecond timestamp
+	ReceivedAt      int64                      `json:"receivedAt"`
+	BundleHash      *common.Hash               `json:"bundleHash,omitempty"`
+	TxHashes        []common.Hash              `json:"txHashes,omitempty"`
+	ReplacementUUID *uuid.UUID                 `json:"replacementUuid,omitempty"`
+	Destinations    map[string]*DeliveryStatus `json:"destinations"`
+}
+
+// DeliveryStatus is the outcome of sending the order to one destination (local builder, peer or archive)
+type DeliveryStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// UpdatedAt is a unix millisecond timestamp
+	UpdatedAt int64 `json:"updatedAt"`
+	// DurationMs is the time from receiving the order to the last update
+	DurationMs int64 `json:"durationMs"`
+}
+
+// orderLedgerEntry is shared by all keys of the order and updated by the queues
+type orderLedgerEntry struct {
+	mu     sync.Mutex
+	status OrderStatus
+}
+
+// record updates delivery status of the destination, entry can be nil if the order is not tracked
+func (e *orderLedgerEntry) record(destination, status string, err error) {
+	if e == nil {
+		return
+	}
+	now := time.Now()
+	delivery := &DeliveryStatus{
+		Status:     status,
+		UpdatedAt:  now.UnixMilli(),
+		DurationMs: now.UnixMilli() - e.status.ReceivedAt,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	e.mu.Lock()
+	e.status.Destinations[destination] = delivery
+	e.mu.Unlock()
+}
+
+func (e *orderLedgerEntry) snapshot() *OrderStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	status := e.status
+	status.Destinations = make(map[string]*DeliveryStatus, len(e.status.Destinations))
+	for destination, delivery := range e.status.Destinations {
+		d := *delivery
+		status.Destinations[destination] = &d
+	}
+	return &status
+}
+
+// keys are scoped by the signer, so a signer resubmitting someone else's transaction doesn't replace their entry
+func (e *orderLedgerEntry) keys() []string {
+	var keys []string
+	signer := e.status.Signer
+	if e.status.BundleHash != nil {
+		keys = append(keys, bundleHashLedgerKey(signer, *e.status.BundleHash))
+	}
+	for _, hash := range e.status.TxHashes {
+		keys = append(keys, txHashLedgerKey(signer, hash))
+	}
+	if e.status.ReplacementUUID != nil {
+		keys = append(keys, replacementUUIDLedgerKey(signer, *e.status.ReplacementUUID))
+	}
+	return keys
+}
+
+func bundleHashLedgerKey(signer common.Address, hash common.Hash) string {
+	return signer.Hex() + ":bundle:" + hash.Hex()
+}
+
+func txHashLedgerKey(signer common.Address, hash common.Hash) string {
+	return signer.Hex() + ":tx:" + hash.Hex()
+}
+
+func replacementUUIDLedgerKey(signer common.Address, u uuid.UUID) string {
+	return signer.Hex() + ":uuid:" + u.String()
+}
+
+// OrderLedger remembers where user orders were delivered so the signer can query their status
+type OrderLedger struct {
+	entries *expirable.LRU[string, *orderLedgerEntry]
+	// path is a file the ledger is saved to, if empty ledger is in memory only
+	path   string
+	saveMu sync.Mutex
+}
+
+// NewOrderLedger creates the ledger, if path is set entries saved before restart are loaded from it
+func NewOrderLedger(size int, path string) (*OrderLedger, error) {
+	if size == 0 {
+		size = DefaultOrderLedgerSize
+	}
+	ledger := &OrderLedger{
+		entries: expirable.NewLRU[string, *orderLedgerEntry](size, nil, OrderLedgerTTL),
+		path:    path,
+	}
+	if path != "" {
+		err := ledger.load()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return ledger, nil
+}
+
+// Add starts tracking the user order, it returns nil for orders that can't be queried
+func (l *OrderLedger) Add(req *ParsedRequest) *orderLedgerEntry {
+	if l == nil {
+		return nil
+	}
+	entry := &orderLedgerEntry{
+		status: OrderStatus{
+			Method:       req.method,
+			Signer:       req.signer,
+			ReceivedAt:   req.receivedAt.UnixMilli(),
+			Destinations: make(map[string]*DeliveryStatus),
+		},
+	}
+	for _, tx := range req.txs {
+		entry.status.TxHashes = append(entry.status.TxHashes, tx.Hash)
+	}
+	switch {
+	case req.ethSendBundle != nil:
+		if len(req.txs) > 0 {
+			hash := ethBundleHash(req.txs)
+			entry.status.BundleHash = &hash
+		}
+		entry.status.ReplacementUUID = req.ethSendBundle.ReplacementUUID
+	case req.mevSendBundle != nil:
+		if req.bundleHash != (common.Hash{}) {
+			hash := req.bundleHash
+			entry.status.BundleHash = &hash
+		}
+		entry.status.ReplacementUUID = req.mevSendBundle.ReplacementUUID
+	}
+
+	keys := entry.keys()
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		l.entries.Add(key, entry)
+	}
+	return entry
+}
+
+// Status returns status of the order sent by the signer, nil if the order is not known
+func (l *OrderLedger) Status(signer common.Address, args *GetOrderStatusArgs) (*OrderStatus, error) {
+	var key string
+	switch {
+	case args.BundleHash != nil:
+		key = bundleHashLedgerKey(signer, *args.BundleHash)
+	case args.TxHash != nil:
+		key = txHashLedgerKey(signer, *args.TxHash)
+	case args.ReplacementUUID != nil:
+		key = replacementUUIDLedgerKey(signer, *args.ReplacementUUID)
+	default:
+		return nil, errOrderStatusNoKey
+	}
+	entry, ok := l.entries.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	return entry.snapshot(), nil
+}
+
+// ethBundleHash is a hash of eth_sendBundle, keccak of concatenated tx hashes
+func ethBundleHash(txs []DecodedTx) common.Hash {
+	hashes := make([]byte, 0, len(txs)*common.HashLength)
+	for _, tx := range txs {
+		hashes = append(hashes, tx.Hash[:]...)
+	}
+	return crypto.Keccak256Hash(hashes)
+}
+
+// Save writes ledger to the file as JSON lines, it does nothing if the file is not set
+func (l *OrderLedger) Save() error {
+	if l == nil || l.path == "" {
+		return nil
+	}
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	seen := make(map[*orderLedgerEntry]struct{})
+	for _, entry := range l.entries.Values() {
+		if _, ok := seen[entry]; ok {
+			continue
+		}
+		seen[entry] = struct{}{}
+		err = enc.Encode(entry.snapshot())
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+func (l *OrderLedger) load() error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	minReceivedAt := time.Now().Add(-OrderLedgerTTL).UnixMilli()
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for dec.More() {
+		entry := &orderLedgerEntry{}
+		err = dec.Decode(&entry.status)
+		if err != nil {
+			return err
+		}
+		if entry.status.ReceivedAt < minReceivedAt {
+			continue
+		}
+		if entry.status.Destinations == nil {
+			entry.status.Destinations = make(map[string]*DeliveryStatus)
+		}
+		for _, key := range entry.keys() {
+			l.entries.Add(key, entry)
+		}
+	}
+	return nil
+}
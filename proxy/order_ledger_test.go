@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/flashbots/go-utils/rpcclient"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderStatus(t *testing.T) {
+	signer, err := signature.NewSignerFromHexPrivateKey("0xd63b3c447fdea415a05e4c0b859474d14105a88178efdf350bc9f7b05be3cc58")
+	require.NoError(t, err)
+	client, err := RPCClientWithCertAndSigner(proxies[0].localServerEndpoint, proxies[0].PublicCertPEM, signer, 1)
+	require.NoError(t, err)
+	otherClient, err := RPCClientWithCertAndSigner(proxies[0].localServerEndpoint, proxies[0].PublicCertPEM, flashbotsSigner, 1)
+	require.NoError(t, err)
+
+	builderHubPeers = nil
+	testAddBuilderhubPeer(t, 0)
+	testAddBuilderhubPeer(t, 1)
+	proxiesUpdatePeers(t)
+
+	// archive requests of the previous tests can be flushed together with ours
+	archiveDone := make(chan struct{})
+	defer close(archiveDone)
+	go func() {
+		for {
+			select {
+			case <-archiveServerRequests:
+			case <-archiveDone:
+				return
+			}
+		}
+	}()
+
+	txs := []hexutil.Bytes{*createTestTx(10), *createTestTx(11)}
+	replacementUUID := uuid.New()
+	resp, err := client.Call(context.Background(), EthSendBundleMethod, &rpctypes.EthSendBundleArgs{Txs: txs, ReplacementUUID: &replacementUUID})
+	require.NoError(t, err)
+	require.Nil(t, resp.Error)
+	expectRequest(t, proxies[0].localBuilderRequests)
+	expectRequest(t, proxies[1].localBuilderRequests)
+	proxiesFlushQueue()
+
+	bundleHash := crypto.Keccak256Hash(crypto.Keccak256(txs[0]), crypto.Keccak256(txs[1]))
+	getStatus := func(client rpcclient.RPCClient, args *GetOrderStatusArgs) *OrderStatus {
+		t.Helper()
+		var status *OrderStatus
+		require.NoError(t, client.CallFor(context.Background(), &status, GetOrderStatusMethod, args))
+		return status
+	}
+	require.Eventually(t, func() bool {
+		status := getStatus(client, &GetOrderStatusArgs{BundleHash: &bundleHash})
+		return status != nil &&
+			status.Destinations[DeliveryDestinationLocalBuilder].Status == DeliveryStatusDelivered &&
+			status.Destinations["proxy:1"].Status == DeliveryStatusDelivered &&
+			status.Destinations[DeliveryDestinationArchive].Status == DeliveryStatusDelivered
+	}, time.Second, time.Millisecond*10)
+
+	txHash := crypto.Keccak256Hash(txs[1])
+	status := getStatus(client, &GetOrderStatusArgs{TxHash: &txHash})
+	require.NotNil(t, status)
+	require.Equal(t, EthSendBundleMethod, status.Method)
+	require.Equal(t, signer.Address(), status.Signer)
+	require.Equal(t, &bundleHash, status.BundleHash)
+	require.Equal(t, []common.Hash{crypto.Keccak256Hash(txs[0]), txHash}, status.TxHashes)
+	require.Equal(t, status, getStatus(client, &GetOrderStatusArgs{ReplacementUUID: &replacementUUID}))
+
+	// orders are visible only to their signer
+	require.Nil(t, getStatus(otherClient, &GetOrderStatusArgs{BundleHash: &bundleHash}))
+	unknownHash := common.Hash{0x01}
+	require.Nil(t, getStatus(client, &GetOrderStatusArgs{TxHash: &unknownHash}))
+	resp, err = client.Call(context.Background(), GetOrderStatusMethod, &GetOrderStatusArgs{})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Error)
+	require.Contains(t, resp.Error.Message, errOrderStatusNoKey.Error())
+
+	// ledger survives restart if the file is set
+	ledgerPath := path.Join(t.TempDir(), "ledger.jsonl")
+	ledger, err := NewOrderLedger(10, ledgerPath)
+	require.NoError(t, err)
+	entry := ledger.Add(&ParsedRequest{method: EthSendRawTransactionMethod, signer: signer.Address(), receivedAt: time.Now(), txs: []DecodedTx{{Hash: txHash}}})
+	entry.record("peer", DeliveryStatusExpired, nil)
+	require.NoError(t, ledger.Save())
+
+	ledger, err = NewOrderLedger(10, ledgerPath)
+	require.NoError(t, err)
+	status, err = ledger.Status(signer.Address(), &GetOrderStatusArgs{TxHash: &txHash})
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	require.Equal(t, DeliveryStatusExpired, status.Destinations["peer"].Status)
+
+	// resubmitted transaction of another signer doesn't replace the entry
+	ledger.Add(&ParsedRequest{method: EthSendRawTransactionMethod, signer: flashbotsSigner.Address(), receivedAt: time.Now(), txs: []DecodedTx{{Hash: txHash}}})
+	status, err = ledger.Status(signer.Address(), &GetOrderStatusArgs{TxHash: &txHash})
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	require.Equal(t, signer.Address(), status.Signer)
+	require.Equal(t, DeliveryStatusExpired, status.Destinations["peer"].Status)
+}
@@ -0,0 +1,548 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/go-utils/signature"
+	proxycommon "github.com/flashbots/tdx-orderflow-proxy/common"
+)
+
+// PeerStreamProtocol is the value of the Upgrade header that switches the system server connection to the order stream.
+//
+// The handshake is a GET request signed over the protocol name with the orderflow key and replay protection header.
+// After the server responds with 101 client sends order frames without waiting for acks,
+// server processes them in order and responds with one ack frame per order:
+//
+//	order: id uint64 | length uint32 | JSON-RPC request
+//	ack:   id uint64 | status byte | length uint32 | JSON-RPC response (only for errors)
+const PeerStreamProtocol = "buildernet-orderflow-stream/1"
+
+const (
+	peerStreamAckOK    byte = 0
+	peerStreamAckError byte = 1
+
+	peerStreamOrderHeaderSize = 12
+	peerStreamAckHeaderSize   = 13
+)
+
+var (
+	// PeerStreamRedialInterval is how long HTTP fallback is used after the stream failed or was rejected
+	PeerStreamRedialInterval = time.Second * 30
+
+	errPeerStreamClosed        = errors.New("peer stream closed")
+	errPeerStreamTimeout       = errors.New("peer stream ack timeout")
+	errPeerStreamUpgrade       = errors.New("peer does not accept order stream")
+	errPeerStreamHandshake     = errors.New("order stream handshake should be signed with replay protection header")
+	errPeerStreamFrameTooBig   = errors.New("order stream frame too big")
+	errPeerStreamNoSigner      = errors.New("stream transport should be created with NewStreamPeerTransport")
+	errPeerStreamNotHijackable = errors.New("order stream requires HTTP/1.1 connection")
+)
+
+// streamPeerTransport sends orders over a persistent stream, fallback is used while the stream is not available
+type streamPeerTransport struct {
+	log        *slog.Logger
+	logSampler *proxycommon.LogSampler
+	endpoint   string
+	tlsConfig  *tls.Config
+	signer     *signature.Signer
+	fallback   PeerTransport
+
+	fallbackRequests *metrics.Counter
+
+	mu          sync.Mutex
+	stream      *peerStream
+	dialing     bool
+	lastFailure time.Time
+	closed      bool
+}
+
+// NewStreamPeerTransport creates the stream transport, the stream is opened on the first request.
+// Requests are sent with fallback while the stream is connecting and for PeerStreamRedialInterval after it failed.
+//
+//nolint:ireturn
+func NewStreamPeerTransport(log *slog.Logger, logSampler *proxycommon.LogSampler, peerName, endpoint string, certPEM []byte, signer *signature.Signer, fallback PeerTransport) (PeerTransport, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// connection can't be upgraded over HTTP/2
+		NextProtos: []string{"http/1.1"},
+	}
+	if certPEM != nil {
+		certPool := x509.NewCertPool()
+		if ok := certPool.AppendCertsFromPEM(certPEM); !ok {
+			return nil, errCertificate
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	return &streamPeerTransport{
+		log:              log.With(slog.String("peer", peerName)),
+		logSampler:       logSampler,
+		endpoint:         endpoint,
+		tlsConfig:        tlsConfig,
+		signer:           signer,
+		fallback:         fallback,
+		fallbackRequests: metrics.GetOrCreateCounter(fmt.Sprintf(shareQueuePeerStreamFallbackLabel, peerName)),
+	}, nil
+}
+
+func (t *streamPeerTransport) Send(req PeerRequest) error {
+	stream := t.currentStream()
+	if stream != nil {
+		written, err := stream.send(req.Body)
+		if err == nil || IsPeerResponseError(err) {
+			return err
+		}
+		t.streamFailed(stream, err)
+		if written {
+			// the peer could have processed the order, it's not sent again so cancellations are not duplicated
+			return err
+		}
+	}
+	t.fallbackRequests.Inc()
+	return t.fallback.Send(req)
+}
+
+// currentStream returns the open stream, one of the callers dials a new stream while others use fallback
+func (t *streamPeerTransport) currentStream() *peerStream {
+	t.mu.Lock()
+	if t.stream != nil || t.closed || t.dialing || time.Since(t.lastFailure) < PeerStreamRedialInterval {
+		stream := t.stream
+		t.mu.Unlock()
+		return stream
+	}
+	t.dialing = true
+	t.mu.Unlock()
+
+	stream, err := dialPeerStream(t.endpoint, t.tlsConfig, t.signer)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dialing = false
+	if err != nil {
+		t.logSampler.Warn(t.log, t.endpoint, "Failed to open order stream, using fallback", slog.Any("error", err))
+		incPeerStreamHandshakes("client", "error")
+		t.lastFailure = time.Now()
+		return nil
+	}
+	incPeerStreamHandshakes("client", "ok")
+	if t.closed {
+		stream.close(errPeerStreamClosed)
+		return nil
+	}
+	t.log.Info("Opened order stream")
+	t.stream = stream
+	return stream
+}
+
+func (t *streamPeerTransport) streamFailed(stream *peerStream, err error) {
+	stream.close(err)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stream == stream {
+		t.logSampler.Warn(t.log, t.endpoint, "Order stream failed, using fallback", slog.Any("error", err))
+		t.stream = nil
+		t.lastFailure = time.Now()
+	}
+}
+
+func (t *streamPeerTransport) Close() {
+	t.mu.Lock()
+	t.closed = true
+	stream := t.stream
+	t.stream = nil
+	t.mu.Unlock()
+	if stream != nil {
+		stream.close(errPeerStreamClosed)
+	}
+	t.fallback.Close()
+}
+
+// peerStream is the client side of the order stream
+type peerStream struct {
+	conn net.Conn
+
+	// writeMu guards writer, header and nextID
+	writeMu sync.Mutex
+	writer  *bufio.Writer
+	header  [peerStreamOrderHeaderSize]byte
+	nextID  uint64
+
+	pendingMu sync.Mutex
+	pending   map[uint64]chan error
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+func dialPeerStream(endpoint string, tlsConfig *tls.Config, signer *signature.Signer) (*peerStream, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	address := u.Host
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	dialer := &net.Dialer{Timeout: requestTimeout, KeepAlive: time.Second * 30}
+	var conn net.Conn
+	switch u.Scheme {
+	case "https":
+		config := tlsConfig.Clone()
+		config.ServerName = u.Hostname()
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, config)
+	case "http":
+		conn, err = dialer.Dial("tcp", address)
+	default:
+		err = fmt.Errorf("%w: unsupported scheme %s", errPeerStreamUpgrade, u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := peerStreamHandshake(conn, endpoint, signer)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	stream := &peerStream{
+		conn:    conn,
+		writer:  bufio.NewWriter(conn),
+		pending: make(map[uint64]chan error),
+		done:    make(chan struct{}),
+	}
+	go stream.readAcks(reader)
+	return stream, nil
+}
+
+func peerStreamHandshake(conn net.Conn, endpoint string, signer *signature.Signer) (*bufio.Reader, error) {
+	body := []byte(PeerStreamProtocol)
+	signatureHeader, err := signer.Create(body)
+	if err != nil {
+		return nil, err
+	}
+	replayHeader, err := CreateReplayProtectionHeader(signer, body, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", PeerStreamProtocol)
+	request.Header.Set(signature.HTTPHeader, signatureHeader)
+	request.Header.Set(ReplayProtectionHTTPHeader, replayHeader)
+
+	_ = conn.SetDeadline(time.Now().Add(requestTimeout))
+	err = request.Write(conn)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || !strings.EqualFold(resp.Header.Get("Upgrade"), PeerStreamProtocol) {
+		return nil, fmt.Errorf("%w: status %d", errPeerStreamUpgrade, resp.StatusCode)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return reader, nil
+}
+
+// send writes the order and waits for its ack, orders of concurrent callers are pipelined.
+// written is true if the order was flushed to the connection, the peer could have processed it even if ack failed.
+func (s *peerStream) send(body []byte) (written bool, err error) {
+	ack := make(chan error, 1)
+
+	s.writeMu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.pendingMu.Lock()
+	s.pending[id] = ack
+	s.pendingMu.Unlock()
+	binary.BigEndian.PutUint64(s.header[0:8], id)
+	binary.BigEndian.PutUint32(s.header[8:12], uint32(len(body))) //nolint:gosec
+	_ = s.conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+	_, err = s.writer.Write(s.header[:])
+	if err == nil {
+		_, err = s.writer.Write(body)
+	}
+	if err == nil {
+		err = s.writer.Flush()
+	}
+	s.writeMu.Unlock()
+	if err != nil {
+		s.forget(id)
+		s.close(err)
+		return false, errors.Join(errPeerStreamClosed, err)
+	}
+
+	timer := time.NewTimer(requestTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-ack:
+		return true, err
+	case <-s.done:
+		// ack could be received right before the stream was closed
+		select {
+		case err := <-ack:
+			return true, err
+		default:
+			return true, errors.Join(errPeerStreamClosed, s.err)
+		}
+	case <-timer.C:
+		s.forget(id)
+		s.close(errPeerStreamTimeout)
+		return true, errPeerStreamTimeout
+	}
+}
+
+// forget removes the order that won't be acked from pending
+func (s *peerStream) forget(id uint64) {
+	s.pendingMu.Lock()
+	delete(s.pending, id)
+	s.pendingMu.Unlock()
+}
+
+func (s *peerStream) readAcks(reader *bufio.Reader) {
+	var header [peerStreamAckHeaderSize]byte
+	for {
+		_, err := io.ReadFull(reader, header[:])
+		if err != nil {
+			s.close(err)
+			return
+		}
+		id := binary.BigEndian.Uint64(header[0:8])
+		status := header[8]
+		length := binary.BigEndian.Uint32(header[9:13])
+		if int64(length) > maxPeerResponseSize {
+			s.close(errPeerStreamFrameTooBig)
+			return
+		}
+		payload := make([]byte, length)
+		_, err = io.ReadFull(reader, payload)
+		if err != nil {
+			s.close(err)
+			return
+		}
+
+		var ackErr error
+		if status != peerStreamAckOK {
+			ackErr = shareResponseError(payload)
+			if ackErr == nil {
+				ackErr = errPeerInvalidResponse
+			}
+		}
+		s.pendingMu.Lock()
+		ack, ok := s.pending[id]
+		delete(s.pending, id)
+		s.pendingMu.Unlock()
+		if ok {
+			ack <- ackErr
+		}
+	}
+}
+
+func (s *peerStream) close(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		close(s.done)
+		_ = s.conn.Close()
+	})
+}
+
+// peerStreamServer accepts order streams on the system server, other requests are passed to the next handler
+type peerStreamServer struct {
+	log              *slog.Logger
+	replayProtection *ReplayProtection
+	// peerName returns name of the peer if the signer can send orders to the system endpoint
+	peerName func(signer common.Address) (string, bool)
+	// orderHandler handles one order with the stream signer in the context, like an element of the batch
+	orderHandler http.Handler
+	next         http.Handler
+	maxFrameSize int64
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func (s *peerStreamServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), PeerStreamProtocol) {
+		s.next.ServeHTTP(w, r)
+		return
+	}
+
+	signer, peerName, err := s.verifyHandshake(r)
+	if err != nil {
+		incPeerStreamHandshakes("server", "rejected")
+		s.log.Debug("Rejected order stream", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		incPeerStreamHandshakes("server", "rejected")
+		http.Error(w, errPeerStreamNotHijackable.Error(), http.StatusHTTPVersionNotSupported)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		s.log.Error("Failed to hijack order stream connection", slog.Any("error", err))
+		return
+	}
+	defer conn.Close()
+	if !s.track(conn) {
+		return
+	}
+	defer s.untrack(conn)
+
+	// timeouts of the HTTP server are not applied to the stream
+	_ = conn.SetDeadline(time.Time{})
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + PeerStreamProtocol + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		return
+	}
+	incPeerStreamHandshakes("server", "ok")
+
+	log := s.log.With(slog.String("peer", peerName))
+	log.Info("Order stream opened")
+	err = s.serve(rw.Reader, rw.Writer, signer)
+	log.Info("Order stream closed", slog.Any("error", err))
+}
+
+func (s *peerStreamServer) verifyHandshake(r *http.Request) (common.Address, string, error) {
+	body := []byte(PeerStreamProtocol)
+	signatureHeader := r.Header.Get(signature.HTTPHeader)
+	replayHeader := r.Header.Get(ReplayProtectionHTTPHeader)
+	if signatureHeader == "" || replayHeader == "" {
+		return common.Address{}, "", errPeerStreamHandshake
+	}
+	signer, err := signature.Verify(signatureHeader, body)
+	if err != nil {
+		return common.Address{}, "", errors.Join(errPeerStreamHandshake, err)
+	}
+	err = s.replayProtection.verify(signatureHeader, replayHeader, body)
+	if err != nil {
+		return common.Address{}, "", err
+	}
+	peerName, ok := s.peerName(signer)
+	if !ok {
+		return common.Address{}, "", errUnknownPeer
+	}
+	return signer, peerName, nil
+}
+
+func (s *peerStreamServer) serve(reader *bufio.Reader, writer *bufio.Writer, signer common.Address) error {
+	ctx := context.WithValue(context.Background(), batchSignerKey{}, signer)
+	var (
+		header [peerStreamOrderHeaderSize]byte
+		ack    [peerStreamAckHeaderSize]byte
+	)
+	for {
+		_, err := io.ReadFull(reader, header[:])
+		if err != nil {
+			return err
+		}
+		length := binary.BigEndian.Uint32(header[8:12])
+		if int64(length) > s.maxFrameSize {
+			return errPeerStreamFrameTooBig
+		}
+		body := make([]byte, length)
+		_, err = io.ReadFull(reader, body)
+		if err != nil {
+			return err
+		}
+
+		status, response := s.handleOrder(ctx, body)
+		copy(ack[0:8], header[0:8])
+		ack[8] = status
+		binary.BigEndian.PutUint32(ack[9:13], uint32(len(response))) //nolint:gosec
+		_, err = writer.Write(ack[:])
+		if err == nil {
+			_, err = writer.Write(response)
+		}
+		// acks of pipelined orders are flushed together
+		if err == nil && reader.Buffered() == 0 {
+			err = writer.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleOrder processes the order like an element of the batch, it returns JSON-RPC response only for errors
+func (s *peerStreamServer) handleOrder(ctx context.Context, body []byte) (byte, []byte) {
+	ctx = context.WithValue(ctx, rawJSONRPCRequestKey{}, body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", bytes.NewReader(body))
+	if err != nil {
+		return peerStreamAckError, nil
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rw := &batchElementResponseWriter{header: make(http.Header)}
+	s.orderHandler.ServeHTTP(rw, req)
+
+	response := rw.body.Bytes()
+	if shareResponseError(response) != nil {
+		return peerStreamAckError, response
+	}
+	return peerStreamAckOK, nil
+}
+
+func (s *peerStreamServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *peerStreamServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// Close closes open streams, hijacked connections are not closed by the HTTP server
+func (s *peerStreamServer) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
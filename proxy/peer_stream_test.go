@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerStream(t *testing.T) {
+	builderHubPeers = nil
+	testAddBuilderhubPeer(t, 0)
+	testAddBuilderhubPeer(t, 1)
+	proxiesUpdatePeers(t)
+
+	newStreamTransport := func(name string, signer *signature.Signer) *streamPeerTransport {
+		t.Helper()
+		fallback, err := NewPeerTransport(PeerTransportHTTP1, proxies[1].publicServerEndpoint, proxies[1].PublicCertPEM, 1)
+		require.NoError(t, err)
+		transport, err := NewStreamPeerTransport(proxies[0].proxy.Log, nil, name, proxies[1].publicServerEndpoint, proxies[1].PublicCertPEM, signer, fallback)
+		require.NoError(t, err)
+		t.Cleanup(transport.Close)
+		return transport.(*streamPeerTransport) //nolint:forcetypeassert
+	}
+	newRequest := func(body string, signer *signature.Signer) PeerRequest {
+		t.Helper()
+		header, err := signer.Create([]byte(body))
+		require.NoError(t, err)
+		replayHeader, err := CreateReplayProtectionHeader(signer, []byte(body), time.Now())
+		require.NoError(t, err)
+		return PeerRequest{Body: []byte(body), SignatureHeader: header, ReplayProtectionHeader: replayHeader}
+	}
+
+	// orders are sent over the stream as received
+	transport := newStreamTransport("stream-test-peer", proxies[0].proxy.OrderflowSigner)
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["%s"]}`, createTestTx(300).String())
+	require.NoError(t, transport.Send(newRequest(body, proxies[0].proxy.OrderflowSigner)))
+	require.Equal(t, body, expectRequest(t, proxies[1].localBuilderRequests).body)
+	require.NotNil(t, transport.stream)
+	require.Equal(t, uint64(0), transport.fallbackRequests.Get())
+
+	// errors of the orders are returned without closing the stream
+	err := transport.Send(newRequest(`{"jsonrpc":"2.0","id":2,"method":"eth_sendRawTransaction","params":["0x00"]}`, proxies[0].proxy.OrderflowSigner))
+	require.ErrorIs(t, err, errPeerReturnedError)
+	require.NotNil(t, transport.stream)
+	require.Equal(t, uint64(0), transport.fallbackRequests.Get())
+
+	// orders of concurrent workers are pipelined and each gets its own ack
+	errs := make(chan error, 20)
+	for i := range cap(errs) {
+		request := newRequest(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"eth_sendRawTransaction","params":["0x00"]}`, 100+i), proxies[0].proxy.OrderflowSigner)
+		go func() { errs <- transport.Send(request) }()
+	}
+	for range cap(errs) {
+		require.ErrorIs(t, <-errs, errPeerReturnedError)
+	}
+	require.Equal(t, uint64(0), transport.fallbackRequests.Get())
+
+	// when the stream fails orders are sent with fallback
+	transport.stream.close(errors.New("test"))
+	body = fmt.Sprintf(`{"jsonrpc":"2.0","id":3,"method":"eth_sendRawTransaction","params":["%s"]}`, createTestTx(301).String())
+	require.NoError(t, transport.Send(newRequest(body, proxies[0].proxy.OrderflowSigner)))
+	require.Equal(t, body, expectRequest(t, proxies[1].localBuilderRequests).body)
+	require.Nil(t, transport.stream)
+	require.Equal(t, uint64(1), transport.fallbackRequests.Get())
+
+	// handshake of unknown signer is rejected
+	unknownSigner, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	_, err = dialPeerStream(proxies[1].publicServerEndpoint, transport.tlsConfig, unknownSigner)
+	require.ErrorIs(t, err, errPeerStreamUpgrade)
+	unknownTransport := newStreamTransport("stream-test-unknown-peer", unknownSigner)
+	err = unknownTransport.Send(newRequest(body, unknownSigner))
+	require.True(t, IsPeerResponseError(err))
+	require.Nil(t, unknownTransport.stream)
+	require.Equal(t, uint64(1), unknownTransport.fallbackRequests.Get())
+}
+
+func TestPeerStreamNotResentAfterWrite(t *testing.T) {
+	client, server := net.Pipe()
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_cancelBundle","params":[]}`)
+	go func() {
+		// the peer reads the order and fails before the ack
+		frame := make([]byte, peerStreamOrderHeaderSize+len(body))
+		_, _ = io.ReadFull(server, frame)
+		_ = server.Close()
+	}()
+	stream := &peerStream{
+		conn:    client,
+		writer:  bufio.NewWriter(client),
+		pending: make(map[uint64]chan error),
+		done:    make(chan struct{}),
+	}
+	go stream.readAcks(bufio.NewReader(client))
+
+	fallback := &slowPeerTransport{}
+	transport := &streamPeerTransport{
+		log:              discardLogger,
+		fallback:         fallback,
+		fallbackRequests: metrics.NewCounter("test_peer_stream_not_resent_fallback_total"),
+		stream:           stream,
+	}
+	err := transport.Send(PeerRequest{Body: body})
+	require.ErrorIs(t, err, errPeerStreamClosed)
+	require.Nil(t, transport.stream)
+	require.Equal(t, uint64(0), transport.fallbackRequests.Get())
+	require.Equal(t, int64(0), fallback.maxSeen.Load())
+}
+
+func TestPeerStreamAckTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { requestTimeout = timeout }(requestTimeout)
+	requestTimeout = time.Millisecond * 50
+
+	client, server := net.Pipe()
+	defer server.Close()
+	body := []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_sendBundle","params":[]}`)
+	go func() {
+		// the peer reads the order and never acks it
+		frame := make([]byte, peerStreamOrderHeaderSize+len(body))
+		_, _ = io.ReadFull(server, frame)
+	}()
+	stream := &peerStream{
+		conn:    client,
+		writer:  bufio.NewWriter(client),
+		pending: make(map[uint64]chan error),
+		done:    make(chan struct{}),
+	}
+
+	written, err := stream.send(body)
+	require.True(t, written)
+	require.ErrorIs(t, err, errPeerStreamTimeout)
+	stream.pendingMu.Lock()
+	defer stream.pendingMu.Unlock()
+	require.Empty(t, stream.pending)
+}
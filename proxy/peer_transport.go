@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flashbots/go-utils/signature"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+)
+
+// transports used to share orders with peers
+const (
+	// PeerTransportHTTP1 sends requests over a pool of HTTP/1.1 connections, one request per connection at a time
+	PeerTransportHTTP1 = "http1"
+	// PeerTransportHTTP2 multiplexes requests of all peer workers over one HTTP/2 connection
+	PeerTransportHTTP2 = "http2"
+	// PeerTransportStream pipelines orders over a persistent authenticated stream with HTTP/1.1 fallback
+	PeerTransportStream = "stream"
+)
+
+var (
+	// HTTP2PeerReadIdleTimeout is how long HTTP/2 connection to the peer can be idle before it's checked with ping
+	HTTP2PeerReadIdleTimeout = time.Second * 30
+	// HTTP2PeerPingTimeout is how long to wait for the ping response before the connection is closed
+	HTTP2PeerPingTimeout = time.Second * 15
+	// maxPeerResponseSize limits the response body that is read from the peer
+	maxPeerResponseSize int64 = 1 << 20
+
+	errPeerTransport         = errors.New("peer transport should be http1, http2 or stream")
+	errPeerTransportOverride = errors.New("peer transport override should be specified as name=transport")
+
+	jsonContentType = []byte("application/json")
+
+	peerResponseBufferPool = sync.Pool{
+		New: func() any { return new(bytes.Buffer) },
+	}
+)
+
+// PeerRequest is a serialized and signed order sent to the peer
+type PeerRequest struct {
+	Body                   []byte
+	SignatureHeader        string
+	ReplayProtectionHeader string
+}
+
+// PeerTransport delivers orders to one peer, it is used concurrently by all workers of the peer
+type PeerTransport interface {
+	// Send returns an error for which IsPeerResponseError is true if the peer responded with an error,
+	// other errors mean that the request could not be made
+	Send(req PeerRequest) error
+	// Close closes idle connections, requests in flight are not interrupted
+	Close()
+}
+
+// IsPeerResponseError returns true if the request was delivered but the peer returned an error
+func IsPeerResponseError(err error) bool {
+	return errors.Is(err, errPeerReturnedError) || errors.Is(err, errPeerInvalidResponse)
+}
+
+// NewPeerTransport creates the transport of the given kind, if certPEM is set only this certificate is trusted
+//
+//nolint:ireturn
+func NewPeerTransport(transport, endpoint string, certPEM []byte, maxOpenConnections int) (PeerTransport, error) {
+	switch transport {
+	case PeerTransportHTTP1, "":
+		client, err := NewFastHTTPClient(certPEM, maxOpenConnections)
+		if err != nil {
+			return nil, err
+		}
+		return &fastHTTPPeerTransport{client: client, endpoint: endpoint}, nil
+	case PeerTransportHTTP2:
+		return newHTTP2PeerTransport(endpoint, certPEM)
+	case PeerTransportStream:
+		return nil, errPeerStreamNoSigner
+	default:
+		return nil, fmt.Errorf("%w: %s", errPeerTransport, transport)
+	}
+}
+
+func validatePeerTransport(transport string) error {
+	switch transport {
+	case PeerTransportHTTP1, PeerTransportHTTP2, PeerTransportStream, "":
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errPeerTransport, transport)
+	}
+}
+
+func validatePeerTransports(transport string, overrides map[string]string) error {
+	err := validatePeerTransport(transport)
+	if err != nil {
+		return err
+	}
+	for name, override := range overrides {
+		err := validatePeerTransport(override)
+		if err != nil {
+			return fmt.Errorf("peer %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ParsePeerTransportOverride parses transport of one peer from "name=transport", e.g. "builder-eu=http2"
+func ParsePeerTransportOverride(spec string) (name, transport string, err error) {
+	name, transport, ok := strings.Cut(spec, "=")
+	if !ok || name == "" {
+		return "", "", errPeerTransportOverride
+	}
+	return name, transport, validatePeerTransport(transport)
+}
+
+type fastHTTPPeerTransport struct {
+	client   *fasthttp.Client
+	endpoint string
+}
+
+func (t *fastHTTPPeerTransport) Send(req PeerRequest) error {
+	request := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(request)
+	request.SetRequestURI(t.endpoint)
+	request.Header.SetMethod(http.MethodPost)
+	request.Header.SetContentTypeBytes(jsonContentType)
+	request.Header.Set(signature.HTTPHeader, req.SignatureHeader)
+	if req.ReplayProtectionHeader != "" {
+		request.Header.Set(ReplayProtectionHTTPHeader, req.ReplayProtectionHeader)
+	}
+	request.SetBodyRaw(req.Body)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	err := t.client.DoTimeout(request, resp, requestTimeout)
+	if err != nil {
+		return err
+	}
+	return shareResponseError(resp.Body())
+}
+
+func (t *fastHTTPPeerTransport) Close() {
+	t.client.CloseIdleConnections()
+}
+
+type http2PeerTransport struct {
+	client    *http.Client
+	transport *http2.Transport
+	endpoint  string
+}
+
+func newHTTP2PeerTransport(endpoint string, certPEM []byte) (*http2PeerTransport, error) {
+	transport := &http2.Transport{
+		DisableCompression: true,
+		ReadIdleTimeout:    HTTP2PeerReadIdleTimeout,
+		PingTimeout:        HTTP2PeerPingTimeout,
+	}
+	if certPEM != nil {
+		certPool := x509.NewCertPool()
+		if ok := certPool.AppendCertsFromPEM(certPEM); !ok {
+			return nil, errCertificate
+		}
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    certPool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	if strings.HasPrefix(endpoint, "http://") {
+		// HTTP/2 without TLS (h2c)
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	return &http2PeerTransport{
+		client:    &http.Client{Transport: transport, Timeout: requestTimeout},
+		transport: transport,
+		endpoint:  endpoint,
+	}, nil
+}
+
+func (t *http2PeerTransport) Send(req PeerRequest) error {
+	request, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(req.Body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(signature.HTTPHeader, req.SignatureHeader)
+	if req.ReplayProtectionHeader != "" {
+		request.Header.Set(ReplayProtectionHTTPHeader, req.ReplayProtectionHeader)
+	}
+
+	resp, err := t.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf := peerResponseBufferPool.Get().(*bytes.Buffer) //nolint:forcetypeassert
+	defer peerResponseBufferPool.Put(buf)
+	buf.Reset()
+	_, err = buf.ReadFrom(io.LimitReader(resp.Body, maxPeerResponseSize))
+	if err != nil {
+		return err
+	}
+	return shareResponseError(buf.Bytes())
+}
+
+func (t *http2PeerTransport) Close() {
+	t.transport.CloseIdleConnections()
+}
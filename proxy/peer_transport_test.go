@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/flashbots/go-utils/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerTransports(t *testing.T) {
+	var protoMajor atomic.Int32
+	peerRequests := make(chan *RequestData, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protoMajor.Store(int32(r.ProtoMajor)) //nolint:gosec
+		body, _ := io.ReadAll(r.Body)
+		peerRequests <- &RequestData{request: r, body: string(body)}
+		if strings.Contains(string(body), "fail") {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"bad"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	for _, tc := range []struct {
+		transport  string
+		protoMajor int32
+	}{
+		{PeerTransportHTTP1, 1},
+		{PeerTransportHTTP2, 2},
+	} {
+		t.Run(tc.transport, func(t *testing.T) {
+			transport, err := NewPeerTransport(tc.transport, server.URL, certPEM, 2)
+			require.NoError(t, err)
+			defer transport.Close()
+
+			err = transport.Send(PeerRequest{Body: []byte(`{"id":1}`), SignatureHeader: "sig", ReplayProtectionHeader: "replay"})
+			require.NoError(t, err)
+			request := expectRequest(t, peerRequests)
+			require.Equal(t, `{"id":1}`, request.body)
+			require.Equal(t, "sig", request.request.Header.Get(signature.HTTPHeader))
+			require.Equal(t, "replay", request.request.Header.Get(ReplayProtectionHTTPHeader))
+			require.Equal(t, tc.protoMajor, protoMajor.Load())
+
+			err = transport.Send(PeerRequest{Body: []byte(`{"id":"fail"}`)})
+			require.ErrorIs(t, err, errPeerReturnedError)
+			require.True(t, IsPeerResponseError(err))
+			expectRequest(t, peerRequests)
+		})
+	}
+
+	// certificate of the peer is pinned
+	transport, err := NewPeerTransport(PeerTransportHTTP2, server.URL, nil, 1)
+	require.NoError(t, err)
+	err = transport.Send(PeerRequest{Body: []byte(`{"id":1}`)})
+	require.Error(t, err)
+	require.False(t, IsPeerResponseError(err))
+	transport.Close()
+
+	_, err = NewPeerTransport("http3", server.URL, nil, 1)
+	require.ErrorIs(t, err, errPeerTransport)
+
+	name, transportName, err := ParsePeerTransportOverride("builder-eu=http2")
+	require.NoError(t, err)
+	require.Equal(t, "builder-eu", name)
+	sq := &ShareQueue{peerTransportOverrides: map[string]string{name: transportName}}
+	require.Equal(t, PeerTransportHTTP2, sq.peerTransportName("builder-eu"))
+	require.Equal(t, PeerTransportHTTP1, sq.peerTransportName("builder-us"))
+	_, _, err = ParsePeerTransportOverride("builder-eu")
+	require.ErrorIs(t, err, errPeerTransportOverride)
+}
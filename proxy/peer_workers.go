@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// PeerWorkersAdjustInterval is how often the number of workers of the peer is adjusted
+	PeerWorkersAdjustInterval = time.Second
+	// PeerWorkersRTTInflation is how many times RTT of the peer can exceed its base RTT before workers are halved
+	PeerWorkersRTTInflation = 2.0
+	// peerWorkersBaseRTTDrift lets the base RTT grow slowly so it follows changes of the network path
+	peerWorkersBaseRTTDrift = 1.01
+)
+
+// peerWorkers is the number of workers of the peer, it's adjusted between min and max in AIMD style:
+// workers are added one at a time while orders wait in the queue and RTT stays close to the base RTT,
+// halved when RTT is inflated (the peer is overloaded) and removed one at a time while the peer is idle
+type peerWorkers struct {
+	min int
+	max int
+
+	current atomic.Int64
+	// stop has a token for each worker that should exit, workers that are sending finish the request first
+	stop chan struct{}
+	// nextWorkerID is only used by the controller
+	nextWorkerID int
+
+	rttSumMicros atomic.Int64
+	rttCount     atomic.Int64
+	// baseRTT is the lowest recent RTT in milliseconds, it's only used by the controller
+	baseRTT float64
+}
+
+func newPeerWorkers(minWorkers, maxWorkers int) *peerWorkers {
+	if maxWorkers <= 0 {
+		maxWorkers = 1
+	}
+	if minWorkers <= 0 || minWorkers > maxWorkers {
+		minWorkers = maxWorkers
+	}
+	return &peerWorkers{
+		min:  minWorkers,
+		max:  maxWorkers,
+		stop: make(chan struct{}, maxWorkers),
+	}
+}
+
+func (w *peerWorkers) observeRTT(rtt time.Duration) {
+	w.rttSumMicros.Add(rtt.Microseconds())
+	w.rttCount.Add(1)
+}
+
+// intervalRTT returns mean RTT in milliseconds since the last call, 0 if there were no requests
+func (w *peerWorkers) intervalRTT() float64 {
+	count := w.rttCount.Swap(0)
+	sum := w.rttSumMicros.Swap(0)
+	if count == 0 {
+		return 0
+	}
+	return float64(sum) / float64(count) / 1000.0
+}
+
+// nextWorkers returns the number of workers for the next interval
+func (w *peerWorkers) nextWorkers(queueLength, inflight int, rtt float64) int {
+	current := int(w.current.Load())
+	if rtt > 0 {
+		if w.baseRTT == 0 {
+			w.baseRTT = rtt
+		} else {
+			// base follows lower RTT at once and higher RTT slowly, it never exceeds the observed RTT
+			w.baseRTT = min(rtt, w.baseRTT*peerWorkersBaseRTTDrift)
+		}
+	}
+
+	switch {
+	case rtt > 0 && rtt > w.baseRTT*PeerWorkersRTTInflation:
+		return max(w.min, current/2)
+	case queueLength > 0:
+		return min(w.max, current+1)
+	case inflight < current:
+		return max(w.min, current-1)
+	default:
+		return current
+	}
+}
+
+// runPeerWorkers starts the minimal number of workers and adjusts them until the peer is closed
+func (sq *ShareQueue) runPeerWorkers(peer *shareQueuePeer) {
+	sq.setPeerWorkers(peer, peer.workers.min)
+	if peer.workers.min == peer.workers.max {
+		return
+	}
+
+	ticker := time.NewTicker(PeerWorkersAdjustInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-peer.closed:
+			return
+		case <-ticker.C:
+		}
+		next := peer.workers.nextWorkers(len(peer.ch), int(peer.inflight.Load()), peer.workers.intervalRTT())
+		sq.setPeerWorkers(peer, next)
+	}
+}
+
+func (sq *ShareQueue) setPeerWorkers(peer *shareQueuePeer, target int) {
+	workers := peer.workers
+	current := int(workers.current.Load())
+	for ; current < target; current++ {
+		// worker that was asked to stop but didn't yet is kept instead of starting a new one
+		select {
+		case <-workers.stop:
+			continue
+		default:
+		}
+		go sq.proxyRequests(peer, workers.nextWorkerID)
+		workers.nextWorkerID++
+	}
+	for ; current > target; current-- {
+		workers.stop <- struct{}{}
+	}
+	workers.current.Store(int64(current))
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerWorkersNext(t *testing.T) {
+	workers := newPeerWorkers(1, 4)
+	workers.current.Store(2)
+
+	require.Equal(t, 3, workers.nextWorkers(10, 2, 10), "queue is not empty")
+	require.InDelta(t, 10, workers.baseRTT, 0.001)
+	workers.current.Store(4)
+	require.Equal(t, 4, workers.nextWorkers(10, 4, 10), "max workers")
+	require.Equal(t, 3, workers.nextWorkers(0, 1, 0), "peer is idle")
+	require.Equal(t, 4, workers.nextWorkers(0, 4, 0), "all workers are busy")
+	require.Equal(t, 2, workers.nextWorkers(10, 4, 30), "rtt is inflated")
+	workers.current.Store(1)
+	require.Equal(t, 1, workers.nextWorkers(0, 0, 0), "min workers")
+
+	fixed := newPeerWorkers(0, 3)
+	require.Equal(t, 3, fixed.min)
+	require.Equal(t, 3, fixed.max)
+}
+
+type slowPeerTransport struct {
+	delay    time.Duration
+	inflight atomic.Int64
+	maxSeen  atomic.Int64
+}
+
+func (t *slowPeerTransport) Send(PeerRequest) error {
+	inflight := t.inflight.Add(1)
+	defer t.inflight.Add(-1)
+	for {
+		seen := t.maxSeen.Load()
+		if inflight <= seen || t.maxSeen.CompareAndSwap(seen, inflight) {
+			break
+		}
+	}
+	time.Sleep(t.delay)
+	return nil
+}
+
+func (t *slowPeerTransport) Close() {}
+
+func TestPeerWorkers(t *testing.T) {
+	adjustInterval := PeerWorkersAdjustInterval
+	PeerWorkersAdjustInterval = 10 * time.Millisecond
+	defer func() { PeerWorkersAdjustInterval = adjustInterval }()
+
+	transport := &slowPeerTransport{delay: 20 * time.Millisecond}
+	peer := newShareQueuePeer("workers-"+t.Name(), transport, ConfighubBuilder{}, 1, 4, nil)
+	defer peer.Close()
+	sq := &ShareQueue{name: "test", log: discardLogger}
+	go sq.runPeerWorkers(peer)
+
+	require.Eventually(t, func() bool { return peer.workers.current.Load() == 1 }, time.Second, time.Millisecond)
+	for range 100 {
+		peer.SendRequest(discardLogger, newTestSharedRequest(t))
+	}
+	require.Eventually(t, func() bool { return peer.workers.current.Load() == 4 }, 2*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return len(peer.ch) == 0 && peer.inflight.Load() == 0 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, int64(4), transport.maxSeen.Load())
+	require.Eventually(t, func() bool { return peer.workers.current.Load() == 1 }, 2*time.Second, time.Millisecond)
+}
+
+func TestPeerWorkersSteadyRTT(t *testing.T) {
+	workers := newPeerWorkers(1, 10)
+	workers.current.Store(8)
+
+	// base RTT doesn't drift above steady RTT, so a spike after a long steady period still halves workers
+	for range 1000 {
+		require.Equal(t, 8, workers.nextWorkers(0, 8, 80))
+	}
+	require.InDelta(t, 80, workers.baseRTT, 0.001)
+	require.Equal(t, 4, workers.nextWorkers(10, 8, 200))
+}
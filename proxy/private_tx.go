@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/google/uuid"
+)
+
+// PrivateTxDefaultMaxBlocks is how many blocks private tx is valid if maxBlockNumber is not set
+var PrivateTxDefaultMaxBlocks = uint64(25)
+
+// EthSendPrivateTransactionArgs are params of eth_sendPrivateTransaction
+type EthSendPrivateTransactionArgs struct {
+	Tx hexutil.Bytes `json:"tx"`
+	// MaxBlockNumber is the last block tx can be included in
+	MaxBlockNumber *hexutil.Uint64       `json:"maxBlockNumber,omitempty"`
+	Preferences    *PrivateTxPreferences `json:"preferences,omitempty"`
+	SigningAddress *common.Address       `json:"signingAddress,omitempty"`
+}
+
+type PrivateTxPreferences struct {
+	Fast     bool               `json:"fast"`
+	Privacy  *PrivateTxPrivacy  `json:"privacy,omitempty"`
+	Validity *PrivateTxValidity `json:"validity,omitempty"`
+}
+
+type PrivateTxPrivacy struct {
+	Hints    []string `json:"hints,omitempty"`
+	Builders []string `json:"builders,omitempty"`
+}
+
+type PrivateTxValidity struct {
+	Refund []rpctypes.RefundConfig `json:"refund,omitempty"`
+}
+
+// EthCancelPrivateTransactionArgs are params of eth_cancelPrivateTransaction
+type EthCancelPrivateTransactionArgs struct {
+	TxHash         common.Hash     `json:"txHash"`
+	SigningAddress *common.Address `json:"signingAddress,omitempty"`
+}
+
+// UniqueKey is the same for the same tx with the same max block
+func (args *EthSendPrivateTransactionArgs) UniqueKey() uuid.UUID {
+	var maxBlock [8]byte
+	if args.MaxBlockNumber != nil {
+		binary.LittleEndian.PutUint64(maxBlock[:], uint64(*args.MaxBlockNumber))
+	}
+	hash := crypto.Keccak256([]byte(EthSendPrivateTransactionMethod), args.Tx, maxBlock[:])
+	u, _ := uuid.FromBytes(hash[:16])
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u
+}
+
+// maxBlock returns last block for the private tx received when currentBlock was the head
+func (args *EthSendPrivateTransactionArgs) maxBlock(currentBlock uint64) uint64 {
+	if args.MaxBlockNumber == nil {
+		return currentBlock + PrivateTxDefaultMaxBlocks
+	}
+	return max(uint64(*args.MaxBlockNumber), currentBlock)
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyPrivateTransaction(t *testing.T) {
+	signer, err := signature.NewSignerFromHexPrivateKey("0xd63b3c447fdea415a05e4c0b859474d14105a88178efdf350bc9f7b05be3cc58")
+	require.NoError(t, err)
+	client, err := RPCClientWithCertAndSigner(proxies[0].localServerEndpoint, proxies[0].PublicCertPEM, signer, 1)
+	require.NoError(t, err)
+
+	builderHubPeers = nil
+	testAddBuilderhubPeer(t, 0)
+	testAddBuilderhubPeer(t, 1)
+	proxiesUpdatePeers(t)
+
+	apiNow = func() time.Time {
+		return time.Unix(1730000000, 0)
+	}
+	blockSource := proxies[0].proxy.blockNumberSource
+	blockSource.cacheMu.Lock()
+	blockSource.cachedNumber = 1000
+	blockSource.cacheTimestamp = time.Now().Add(time.Hour)
+	blockSource.cacheMu.Unlock()
+	defer func() {
+		apiNow = time.Now
+		blockSource.cacheMu.Lock()
+		blockSource.cacheTimestamp = time.Time{}
+		blockSource.cacheMu.Unlock()
+	}()
+
+	tx := createTestTx(100)
+	maxBlockNumber := hexutil.Uint64(1010)
+	var txHash common.Hash
+	err = client.CallFor(context.Background(), &txHash, EthSendPrivateTransactionMethod, &EthSendPrivateTransactionArgs{
+		Tx:             *tx,
+		MaxBlockNumber: &maxBlockNumber,
+		Preferences: &PrivateTxPreferences{
+			Validity: &PrivateTxValidity{Refund: []rpctypes.RefundConfig{{Address: signer.Address(), Percent: 90}}},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, crypto.Keccak256Hash(*tx), txHash)
+
+	expectedRequest := `{"method":"eth_sendPrivateTransaction","params":[{"tx":"` + tx.String() + `","maxBlockNumber":"0x3f2","preferences":{"fast":false,"validity":{"refund":[{"address":"0x9349365494be4f6205e5d44bdc7ec7dcd134becf","percent":90}]}},"signingAddress":"0x9349365494be4f6205e5d44bdc7ec7dcd134becf"}],"id":0,"jsonrpc":"2.0"}`
+	require.Equal(t, expectedRequest, expectRequest(t, proxies[0].localBuilderRequests).body)
+	require.Equal(t, expectedRequest, expectRequest(t, proxies[1].localBuilderRequests).body)
+
+	var cancelled bool
+	err = client.CallFor(context.Background(), &cancelled, EthCancelPrivateTransactionMethod, &EthCancelPrivateTransactionArgs{TxHash: txHash})
+	require.NoError(t, err)
+	require.True(t, cancelled)
+
+	expectedRequest = `{"method":"eth_cancelPrivateTransaction","params":[{"txHash":"` + txHash.Hex() + `","signingAddress":"0x9349365494be4f6205e5d44bdc7ec7dcd134becf"}],"id":0,"jsonrpc":"2.0"}`
+	require.Equal(t, expectedRequest, expectRequest(t, proxies[0].localBuilderRequests).body)
+	require.Equal(t, expectedRequest, expectRequest(t, proxies[1].localBuilderRequests).body)
+
+	resp, err := client.Call(context.Background(), EthSendPrivateTransactionMethod, &EthSendPrivateTransactionArgs{})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Error)
+	require.Contains(t, resp.Error.Message, errPrivateTxEmpty.Error())
+
+	proxiesFlushQueue()
+	archiveRequest := expectRequest(t, archiveServerRequests)
+	expectedArchiveRequest := `{"method":"flashbots_newOrderEvents","params":[{"orderEvents":[{"mev_sendBundle":{"params":{"version":"v0.1","inclusion":{"block":"0x3e8","maxBlock":"0x3f2"},"body":[{"tx":"` + tx.String() + `"}],"validity":{"refundConfig":[{"address":"0x9349365494be4f6205e5d44bdc7ec7dcd134becf","percent":90}]},"metadata":{"signer":"0x9349365494be4f6205e5d44bdc7ec7dcd134becf"}},"metadata":{"receivedAt":1730000000000}}},{"eth_cancelPrivateTransaction":{"params":{"txHash":"` + txHash.Hex() + `","signingAddress":"0x9349365494be4f6205e5d44bdc7ec7dcd134becf"},"metadata":{"receivedAt":1730000000000}}}]}],"id":0,"jsonrpc":"2.0"}`
+	require.Equal(t, expectedArchiveRequest, archiveRequest.body)
+}
@@ -24,6 +24,9 @@ const (
 	EthCancelBundleMethod       = "eth_cancelBundle"
 	EthSendRawTransactionMethod = "eth_sendRawTransaction"
 	BidSubsidiseBlockMethod     = "bid_subsidiseBlock"
+
+	EthSendPrivateTransactionMethod   = "eth_sendPrivateTransaction"
+	EthCancelPrivateTransactionMethod = "eth_cancelPrivateTransaction"
 )
 
 var (
@@ -37,14 +40,36 @@ var (
 	handleParsedRequestTimeout = time.Second * 1
 )
 
-func (prx *ReceiverProxy) SystemJSONRPCHandler(maxRequestBodySizeBytes int64) (*rpcserver.JSONRPCHandler, error) {
-	handler, err := rpcserver.NewJSONRPCHandler(rpcserver.Methods{
+func (prx *ReceiverProxy) systemMethods() rpcserver.Methods {
+	return rpcserver.Methods{
 		EthSendBundleMethod:         prx.EthSendBundleSystem,
 		MevSendBundleMethod:         prx.MevSendBundleSystem,
 		EthCancelBundleMethod:       prx.EthCancelBundleSystem,
 		EthSendRawTransactionMethod: prx.EthSendRawTransactionSystem,
 		BidSubsidiseBlockMethod:     prx.BidSubsidiseBlockSystem,
-	},
+
+		EthSendPrivateTransactionMethod:   prx.EthSendPrivateTransactionSystem,
+		EthCancelPrivateTransactionMethod: prx.EthCancelPrivateTransactionSystem,
+	}
+}
+
+func (prx *ReceiverProxy) userMethods() rpcserver.Methods {
+	return rpcserver.Methods{
+		EthSendBundleMethod:         prx.EthSendBundleUser,
+		MevSendBundleMethod:         prx.MevSendBundleUser,
+		EthCancelBundleMethod:       prx.EthCancelBundleUser,
+		EthSendRawTransactionMethod: prx.EthSendRawTransactionUser,
+		BidSubsidiseBlockMethod:     prx.BidSubsidiseBlockUser,
+
+		EthSendPrivateTransactionMethod:   prx.EthSendPrivateTransactionUser,
+		EthCancelPrivateTransactionMethod: prx.EthCancelPrivateTransactionUser,
+
+		GetOrderStatusMethod: prx.GetOrderStatus,
+	}
+}
+
+func (prx *ReceiverProxy) SystemJSONRPCHandler(maxRequestBodySizeBytes int64) (*rpcserver.JSONRPCHandler, error) {
+	handler, err := rpcserver.NewJSONRPCHandler(prx.systemMethods(),
 		rpcserver.JSONRPCHandlerOpts{
 			ServerName:                       "system_server",
 			Log:                              prx.Log,
@@ -57,13 +82,7 @@ func (prx *ReceiverProxy) SystemJSONRPCHandler(maxRequestBodySizeBytes int64) (*
 }
 
 func (prx *ReceiverProxy) UserJSONRPCHandler(maxRequestBodySizeBytes int64) (*rpcserver.JSONRPCHandler, error) {
-	handler, err := rpcserver.NewJSONRPCHandler(rpcserver.Methods{
-		EthSendBundleMethod:         prx.EthSendBundleUser,
-		MevSendBundleMethod:         prx.MevSendBundleUser,
-		EthCancelBundleMethod:       prx.EthCancelBundleUser,
-		EthSendRawTransactionMethod: prx.EthSendRawTransactionUser,
-		BidSubsidiseBlockMethod:     prx.BidSubsidiseBlockUser,
-	},
+	handler, err := rpcserver.NewJSONRPCHandler(prx.userMethods(),
 		rpcserver.JSONRPCHandlerOpts{
 			ServerName:                       "user_server",
 			Log:                              prx.Log,
@@ -76,22 +95,22 @@ func (prx *ReceiverProxy) UserJSONRPCHandler(maxRequestBodySizeBytes int64) (*rp
 	return handler, err
 }
 
-// readyHandler calls /readyz on rbuilder
-func (prx *ReceiverProxy) readyHandler(w http.ResponseWriter, r *http.Request) error {
-	if prx.builderReadyEndpoint == "" {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ready"))
-		return nil
-	}
+// batchElementJSONRPCHandler handles elements of the batch, signature of the whole batch is verified by JSONRPCBatchHandler
+func (prx *ReceiverProxy) batchElementJSONRPCHandler(serverName string, methods rpcserver.Methods, maxRequestBodySizeBytes int64) (*rpcserver.JSONRPCHandler, error) {
+	return rpcserver.NewJSONRPCHandler(methods,
+		rpcserver.JSONRPCHandlerOpts{
+			ServerName:              serverName,
+			Log:                     prx.Log,
+			MaxRequestBodySizeBytes: maxRequestBodySizeBytes,
+		},
+	)
+}
 
-	resp, err := http.Get(prx.builderReadyEndpoint + "/readyz")
-	if err != nil {
-		prx.Log.Warn("Failed to check builder readiness", slog.Any("error", err))
-		http.Error(w, "not ready", http.StatusServiceUnavailable)
-		return nil
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
+// readyHandler returns cached readiness of rbuilder, /readyz of the builder is polled every HealthPollInterval
+// in background by the health poller so the response can be up to one interval old
+func (prx *ReceiverProxy) readyHandler(w http.ResponseWriter, r *http.Request) error {
+	status := prx.builderReadinessStatus()
+	if !status.Healthy {
 		http.Error(w, "not ready", http.StatusServiceUnavailable)
 		return nil
 	}
@@ -103,8 +122,13 @@ func (prx *ReceiverProxy) readyHandler(w http.ResponseWriter, r *http.Request) e
 }
 
 func (prx *ReceiverProxy) ValidateSigner(ctx context.Context, req *ParsedRequest, systemEndpoint bool) error {
-	req.signer = rpcserver.GetSigner(ctx)
+	req.signer = requestSigner(ctx)
 	if !systemEndpoint {
+		policy, err := prx.userSigners.Load().check(req.signer, req.method)
+		if err != nil {
+			return err
+		}
+		req.signerPolicy = policy
 		req.peerName = "user-request"
 		return nil
 	}
@@ -116,17 +140,7 @@ func (prx *ReceiverProxy) ValidateSigner(ctx context.Context, req *ParsedRequest
 		return nil
 	}
 
-	prx.peersMu.RLock()
-	defer prx.peersMu.RUnlock()
-	found := false
-	peerName := ""
-	for _, peer := range prx.lastFetchedPeers {
-		if req.signer == peer.OrderflowProxy.EcdsaPubkeyAddress {
-			found = true
-			peerName = peer.Name
-			break
-		}
-	}
+	peerName, found := prx.systemPeerName(req.signer)
 	if !found {
 		return errUnknownPeer
 	}
@@ -134,6 +148,21 @@ func (prx *ReceiverProxy) ValidateSigner(ctx context.Context, req *ParsedRequest
 	return nil
 }
 
+// systemPeerName returns name of the peer with the given signer, Flashbots signer is also allowed on the system endpoint
+func (prx *ReceiverProxy) systemPeerName(signer common.Address) (string, bool) {
+	if signer == prx.FlashbotsSignerAddress {
+		return FlashbotsPeerName, true
+	}
+	prx.peersMu.RLock()
+	defer prx.peersMu.RUnlock()
+	for _, peer := range prx.lastFetchedPeers {
+		if signer == peer.OrderflowProxy.EcdsaPubkeyAddress {
+			return peer.Name, true
+		}
+	}
+	return "", false
+}
+
 func (prx *ReceiverProxy) EthSendBundle(ctx context.Context, ethSendBundle rpctypes.EthSendBundleArgs, systemEndpoint bool) error {
 	startAt := time.Now()
 	parsedRequest := ParsedRequest{
@@ -148,6 +177,8 @@ func (prx *ReceiverProxy) EthSendBundle(ctx context.Context, ethSendBundle rpcty
 		return err
 	}
 
+	// uuid is moved to replacementUuid
+	parsedRequest.argsModified = ethSendBundle.UUID != nil
 	_, err = EnsureReplacementUUID(&ethSendBundle)
 	if err != nil {
 		return err
@@ -158,6 +189,17 @@ func (prx *ReceiverProxy) EthSendBundle(ctx context.Context, ethSendBundle rpcty
 		return err
 	}
 
+	parsedRequest.txs, err = prx.txValidator.DecodeTxs(ethSendBundle.Txs, systemEndpoint)
+	if err != nil {
+		return withRPCErrorCode(ctx, err)
+	}
+
+	parsedRequest.validity = ethSendBundleValidity(&ethSendBundle)
+	err = prx.staleFilter.Check(parsedRequest.method, parsedRequest.validity)
+	if err != nil {
+		return err
+	}
+
 	incRequestDurationStep(time.Since(startAt), parsedRequest.method, "", "validation")
 	startAt = time.Now()
 
@@ -216,7 +258,18 @@ func (prx *ReceiverProxy) MevSendBundle(ctx context.Context, mevSendBundle rpcty
 		return err
 	}
 
-	err = ValidateMevSendBundle(&mevSendBundle, systemEndpoint)
+	parsedRequest.bundleHash, err = ValidateMevSendBundle(&mevSendBundle, systemEndpoint)
+	if err != nil {
+		return err
+	}
+
+	parsedRequest.txs, err = prx.txValidator.DecodeMevBundleTxs(&mevSendBundle, systemEndpoint)
+	if err != nil {
+		return withRPCErrorCode(ctx, err)
+	}
+
+	parsedRequest.validity = mevSendBundleValidity(&mevSendBundle)
+	err = prx.staleFilter.Check(parsedRequest.method, parsedRequest.validity)
 	if err != nil {
 		return err
 	}
@@ -237,8 +290,10 @@ func (prx *ReceiverProxy) MevSendBundle(ctx context.Context, mevSendBundle rpcty
 			// this is not atomic but the normal user will not send multiple replacements in parallel
 			nonce, ok := prx.replacementNonceRLU.Peek(replacementKey)
 			if ok {
+				prx.replacementNonceHits.Add(1)
 				nonce += 1
 			} else {
+				prx.replacementNonceMisses.Add(1)
 				nonce = 0
 			}
 			prx.replacementNonceRLU.Add(replacementKey, nonce)
@@ -312,6 +367,12 @@ func (prx *ReceiverProxy) EthSendRawTransaction(ctx context.Context, ethSendRawT
 		return err
 	}
 
+	tx, err := prx.txValidator.DecodeTx(ethSendRawTransaction, systemEndpoint)
+	if err != nil {
+		return withRPCErrorCode(ctx, err)
+	}
+	parsedRequest.txs = []DecodedTx{tx}
+
 	uniqueKey := ethSendRawTransaction.UniqueKey()
 	parsedRequest.requestArgUniqueKey = &uniqueKey
 
@@ -326,6 +387,89 @@ func (prx *ReceiverProxy) EthSendRawTransactionUser(ctx context.Context, ethSend
 	return prx.EthSendRawTransaction(ctx, ethSendRawTransaction, false)
 }
 
+func (prx *ReceiverProxy) EthSendPrivateTransaction(ctx context.Context, ethSendPrivateTransaction EthSendPrivateTransactionArgs, systemEndpoint bool) (common.Hash, error) {
+	parsedRequest := ParsedRequest{
+		systemEndpoint:            systemEndpoint,
+		ethSendPrivateTransaction: &ethSendPrivateTransaction,
+		method:                    EthSendPrivateTransactionMethod,
+		size:                      rpcserver.GetRequestSize(ctx),
+	}
+	err := prx.ValidateSigner(ctx, &parsedRequest, systemEndpoint)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	err = ValidateEthSendPrivateTransaction(&ethSendPrivateTransaction, systemEndpoint)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	tx, err := prx.txValidator.DecodeTx(ethSendPrivateTransaction.Tx, systemEndpoint)
+	if err != nil {
+		return common.Hash{}, withRPCErrorCode(ctx, err)
+	}
+	parsedRequest.txs = []DecodedTx{tx}
+
+	parsedRequest.validity = ethSendPrivateTransactionValidity(&ethSendPrivateTransaction)
+	err = prx.staleFilter.Check(parsedRequest.method, parsedRequest.validity)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	if !systemEndpoint {
+		ethSendPrivateTransaction.SigningAddress = &parsedRequest.signer
+	}
+
+	uniqueKey := ethSendPrivateTransaction.UniqueKey()
+	parsedRequest.requestArgUniqueKey = &uniqueKey
+
+	return tx.Hash, prx.HandleParsedRequest(ctx, parsedRequest)
+}
+
+func (prx *ReceiverProxy) EthSendPrivateTransactionSystem(ctx context.Context, ethSendPrivateTransaction EthSendPrivateTransactionArgs) (common.Hash, error) {
+	return prx.EthSendPrivateTransaction(ctx, ethSendPrivateTransaction, true)
+}
+
+func (prx *ReceiverProxy) EthSendPrivateTransactionUser(ctx context.Context, ethSendPrivateTransaction EthSendPrivateTransactionArgs) (common.Hash, error) {
+	return prx.EthSendPrivateTransaction(ctx, ethSendPrivateTransaction, false)
+}
+
+func (prx *ReceiverProxy) EthCancelPrivateTransaction(ctx context.Context, ethCancelPrivateTransaction EthCancelPrivateTransactionArgs, systemEndpoint bool) (bool, error) {
+	parsedRequest := ParsedRequest{
+		systemEndpoint:              systemEndpoint,
+		ethCancelPrivateTransaction: &ethCancelPrivateTransaction,
+		method:                      EthCancelPrivateTransactionMethod,
+		size:                        rpcserver.GetRequestSize(ctx),
+	}
+	err := prx.ValidateSigner(ctx, &parsedRequest, systemEndpoint)
+	if err != nil {
+		return false, err
+	}
+
+	err = ValidateEthCancelPrivateTransaction(&ethCancelPrivateTransaction, systemEndpoint)
+	if err != nil {
+		return false, err
+	}
+
+	if !systemEndpoint {
+		ethCancelPrivateTransaction.SigningAddress = &parsedRequest.signer
+	}
+
+	err = prx.HandleParsedRequest(ctx, parsedRequest)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (prx *ReceiverProxy) EthCancelPrivateTransactionSystem(ctx context.Context, ethCancelPrivateTransaction EthCancelPrivateTransactionArgs) (bool, error) {
+	return prx.EthCancelPrivateTransaction(ctx, ethCancelPrivateTransaction, true)
+}
+
+func (prx *ReceiverProxy) EthCancelPrivateTransactionUser(ctx context.Context, ethCancelPrivateTransaction EthCancelPrivateTransactionArgs) (bool, error) {
+	return prx.EthCancelPrivateTransaction(ctx, ethCancelPrivateTransaction, false)
+}
+
 func (prx *ReceiverProxy) BidSubsidiseBlock(ctx context.Context, bidSubsidiseBlock rpctypes.BidSubsisideBlockArgs, systemEndpoint bool) error {
 	if !systemEndpoint {
 		return errSubsidyWrongEndpoint
@@ -375,8 +519,33 @@ type ParsedRequest struct {
 	ethSendRawTransaction *rpctypes.EthSendRawTransactionArgs
 	bidSubsidiseBlock     *rpctypes.BidSubsisideBlockArgs
 
+	ethSendPrivateTransaction   *EthSendPrivateTransactionArgs
+	ethCancelPrivateTransaction *EthCancelPrivateTransactionArgs
+
+	// txs are decoded txs of the request with cached hashes and senders
+	txs []DecodedTx
+	// validity is used to drop orders that expired before they were shared
+	validity orderValidity
+	// signerPolicy restricts user orderflow, nil if the signer is not restricted
+	signerPolicy *signerPolicy
+	// bundleHash is set for mev_sendBundle
+	bundleHash common.Hash
+	// ledgerEntry records delivery of user orders, nil if the order is not tracked
+	ledgerEntry *orderLedgerEntry
+
+	// rawJSONRPCRequest is the request as received on the system endpoint,
+	// it is forwarded without re-encoding unless argsModified is set
+	rawJSONRPCRequest []byte
+	argsModified      bool
+
 	serializedJSONRPCRequest []byte
 	signatureHeader          string
+	replayProtectionHeader   string
+}
+
+// localOnly is true for requests that are sent only to local builders and not shared with peers
+func (r *ParsedRequest) localOnly() bool {
+	return r.systemEndpoint || r.signerPolicy.isLocalOnly()
 }
 
 func (prx *ReceiverProxy) HandleParsedRequest(ctx context.Context, parsedRequest ParsedRequest) error {
@@ -385,16 +554,27 @@ func (prx *ReceiverProxy) HandleParsedRequest(ctx context.Context, parsedRequest
 	defer cancel()
 
 	parsedRequest.receivedAt = apiNow()
+	if parsedRequest.systemEndpoint {
+		parsedRequest.rawJSONRPCRequest = rawJSONRPCRequest(ctx)
+	}
 	prx.Log.Debug("Received request", slog.Bool("isSystemEndpoint", parsedRequest.systemEndpoint), slog.String("method", parsedRequest.method))
 	if parsedRequest.systemEndpoint {
 		incAPIIncomingRequestsByPeer(parsedRequest.peerName)
+	} else {
+		err := parsedRequest.signerPolicy.checkRequest(&parsedRequest)
+		if err != nil {
+			return err
+		}
 	}
 	if parsedRequest.requestArgUniqueKey != nil {
-		if prx.requestUniqueKeysRLU.Contains(*parsedRequest.requestArgUniqueKey) {
+		origin := DedupeOriginUser
+		if parsedRequest.systemEndpoint {
+			origin = DedupeOriginPeer
+		}
+		if prx.requestDedupe.Seen(*parsedRequest.requestArgUniqueKey, parsedRequest.method, origin) {
 			incAPIDuplicateRequestsByPeer(parsedRequest.peerName)
 			return nil
 		}
-		prx.requestUniqueKeysRLU.Add(*parsedRequest.requestArgUniqueKey, struct{}{})
 	}
 
 	incRequestDurationStep(time.Since(startAt), parsedRequest.method, "", "validation")
@@ -411,20 +591,25 @@ func (prx *ReceiverProxy) HandleParsedRequest(ctx context.Context, parsedRequest
 	incRequestDurationStep(time.Since(startAt), parsedRequest.method, "", "rate_limiting")
 	startAt = time.Now()
 
+	if !parsedRequest.systemEndpoint {
+		parsedRequest.ledgerEntry = prx.orderLedger.Add(&parsedRequest)
+	}
+
 	err := SerializeParsedRequestForSharing(&parsedRequest, prx.OrderflowSigner)
 	if err != nil {
-		prx.Log.Warn("Failed to serialize request for sharing", slog.Any("error", err))
+		prx.logSampler.Warn(prx.Log, parsedRequest.method, "Failed to serialize request for sharing", slog.Any("error", err))
 	}
 
 	incRequestDurationStep(time.Since(startAt), parsedRequest.method, "", "serialize_parsed_request")
 	startAt = time.Now()
 
-	// since we send to local builder while handling the request we can skip sharing request
-	if !parsedRequest.systemEndpoint {
+	// requests from the system endpoint are only sent to the local builder
+	if !parsedRequest.localOnly() {
 		select {
 		case <-ctx.Done():
-			prx.Log.Error("Shared queue is stalling")
+			prx.logSampler.Error(prx.Log, "share-queue", "Shared queue is stalling")
 		case prx.shareQueue <- &parsedRequest:
+			prx.sharing.queueAge.enqueued()
 		}
 	}
 
@@ -432,22 +617,33 @@ func (prx *ReceiverProxy) HandleParsedRequest(ctx context.Context, parsedRequest
 	startAt = time.Now()
 
 	if !parsedRequest.systemEndpoint {
+		parsedRequest.ledgerEntry.record(DeliveryDestinationArchive, DeliveryStatusQueued, nil)
 		select {
 		case <-ctx.Done():
-			prx.Log.Error("Archive queue is stalling")
+			prx.logSampler.Error(prx.Log, archiveLogSampleKey, "Archive queue is stalling")
+			parsedRequest.ledgerEntry.record(DeliveryDestinationArchive, DeliveryStatusDropped, nil)
 		case prx.archiveQueue <- &parsedRequest:
+			prx.archiveQueueAge.enqueued()
 		}
 	}
 
 	incRequestDurationStep(time.Since(startAt), parsedRequest.method, "", "archive_queue")
 	startAt = time.Now()
-	// since we always send to local builder we do it here to avoid queue entirery
 
-	err = prx.localBuilderSender.SendRequest(&parsedRequest)
-	if err != nil {
-		prx.Log.Debug("Failed to send request to a local builder", slog.Any("error", err))
+	for _, builder := range prx.localBuilders {
+		builder.SendRequest(&parsedRequest)
 	}
 
 	incRequestDurationStep(time.Since(startAt), parsedRequest.method, "", "local_builder")
 	return nil
 }
+
+// GetOrderStatus returns delivery status of the order sent by the same signer, null if the order is not known
+func (prx *ReceiverProxy) GetOrderStatus(ctx context.Context, args GetOrderStatusArgs) (*OrderStatus, error) {
+	signer := requestSigner(ctx)
+	_, err := prx.userSigners.Load().check(signer, GetOrderStatusMethod)
+	if err != nil {
+		return nil, err
+	}
+	return prx.orderLedger.Status(signer, &args)
+}
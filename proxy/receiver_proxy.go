@@ -2,29 +2,32 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/flashbots/go-utils/rpcclient"
 	"github.com/flashbots/go-utils/signature"
+	proxycommon "github.com/flashbots/tdx-orderflow-proxy/common"
 	"github.com/google/uuid"
 	"github.com/hashicorp/golang-lru/v2/expirable"
 	"golang.org/x/time/rate"
 )
 
 var (
-	requestsRLUSize = 4096
-	requestsRLUTTL  = time.Second * 12
-
 	peerUpdateTime = time.Second * 30
 
 	replacementNonceSize = 4096
 	replacementNonceTTL  = time.Second * 5 * 12
 
 	ReceiverProxyWorkerQueueSize = 10000
+
+	errProxyStopped = errors.New("proxy is stopped")
 )
 
 type replacementNonceKey struct {
@@ -41,27 +44,57 @@ type ReceiverProxy struct {
 
 	UserHandler   http.Handler
 	SystemHandler http.Handler
+	// peerStreams accepts order streams of the peers on the system server
+	peerStreams      *peerStreamServer
+	replayProtection *ReplayProtection
 
 	updatePeers chan []ConfighubBuilder
 	shareQueue  chan *ParsedRequest
+	sharing     *ShareQueue
 
 	archiveQueue      chan *ParsedRequest
 	archiveFlushQueue chan struct{}
+	// archiveFlushClose is closed on stop so flushes don't wait for the stopped archive queue
+	archiveFlushClose chan struct{}
 
-	peersMu          sync.RWMutex
+	peersMu sync.RWMutex
+	// lastFetchedPeers are peers from BuilderHub merged with static peers
 	lastFetchedPeers []ConfighubBuilder
+	builderHubPeers  []ConfighubBuilder
+	staticPeers      atomic.Pointer[[]ConfighubBuilder]
 
-	requestUniqueKeysRLU *expirable.LRU[uuid.UUID, struct{}]
+	requestDedupe *RequestDedupe
 
-	replacementNonceRLU *expirable.LRU[replacementNonceKey, int]
+	replacementNonceRLU    *expirable.LRU[replacementNonceKey, int]
+	replacementNonceHits   atomic.Uint64
+	replacementNonceMisses atomic.Uint64
 
 	peerUpdaterClose chan struct{}
 
 	userAPIRateLimiter *rate.Limiter
-
-	localBuilderSender LocalBuilderSender
-
-	builderReadyEndpoint string
+	maxUserRPS         int
+	userSigners        atomic.Pointer[signerLists]
+
+	localBuilders []*localBuilder
+	txValidator   *TxValidator
+	staleFilter   *StaleFilter
+	orderLedger   *OrderLedger
+	tls           ReceiverTLSConfig
+
+	blockNumberSource   *BlockNumberSource
+	archiveWorkersQueue chan *ParsedRequest
+	archiveQueueAge     queueAgeTracker
+
+	healthHardDependencies []string
+	healthPollerClose      chan struct{}
+	saversClose            chan struct{}
+	tlsWatcherClose        chan struct{}
+	builderHubHealth       dependencyHealth
+	archiveHealth          dependencyHealth
+	ethHead                ethHeadHealth
+	signerRegistered       atomic.Bool
+	// logSampler collapses repeated hot path errors, it's stopped with the proxy
+	logSampler *proxycommon.LogSampler
 }
 
 type ReceiverProxyConstantConfig struct {
@@ -79,15 +112,55 @@ type ReceiverProxyConfig struct {
 	ArchiveEndpoint          string
 	ArchiveConnections       int
 	BuilderReadyEndpoint     string
+	// LocalBuilders are local destinations in addition to LocalBuilderEndpoint which is always a primary builder
+	LocalBuilders []LocalBuilderConfig
 
 	// EthRPC should support eth_blockNumber API
 	EthRPC string
 
 	MaxRequestBodySizeBytes int64
-
+	// MaxBatchLength and MaxBatchSizeBytes limit JSON-RPC batch requests, if 0 defaults are used
+	MaxBatchLength    int
+	MaxBatchSizeBytes int64
+
+	TxValidation TxValidationConfig
+	// MaxBlocksAhead limits target block of the orders, if 0 DefaultMaxBlocksAhead is used
+	MaxBlocksAhead uint64
+
+	// OrderLedgerSize is a number of keys in the order status ledger, if 0 DefaultOrderLedgerSize is used
+	OrderLedgerSize int
+	// OrderLedgerFile is used to keep order statuses between restarts, if empty ledger is in memory only
+	OrderLedgerFile string
+	// DedupeWindow is how long unique keys of orders are remembered, if 0 DefaultDedupeWindow is used
+	DedupeWindow time.Duration
+	// DedupeMaxEntries bounds the number of remembered keys, if 0 DefaultDedupeMaxEntries is used
+	DedupeMaxEntries int
+	// DedupeFile is used to keep unique keys of orders between restarts, if empty dedupe is in memory only
+	DedupeFile string
+
+	TLS ReceiverTLSConfig
+
+	// ConnectionsPerPeer is the maximum number of concurrent requests to each peer
 	ConnectionsPerPeer int
-	MaxUserRPS         int
-	ArchiveWorkerCount int
+	// MinConnectionsPerPeer is the lower bound of adaptive concurrency of each peer, if 0 ConnectionsPerPeer is always used
+	MinConnectionsPerPeer int
+	MaxUserRPS            int
+	ArchiveWorkerCount    int
+
+	// PeerTransport is PeerTransportHTTP1 (default), PeerTransportHTTP2 or PeerTransportStream
+	PeerTransport string
+	// PeerTransportOverrides are transports of the peers by name that differ from PeerTransport
+	PeerTransportOverrides map[string]string
+
+	// ReplayProtectionMode is one of ReplayProtectionDisabled, ReplayProtectionOptional (default), ReplayProtectionRequired
+	ReplayProtectionMode         string
+	ReplayProtectionMaxClockSkew time.Duration
+	// ReplayProtectionFile is used to keep signers that upgraded to replay protection between restarts,
+	// if empty they are in memory only and legacy requests of all signers are accepted again after restart
+	ReplayProtectionFile string
+
+	// HealthHardDependencies are dependencies that make /readyz fail, if nil DefaultHealthHardDependencies are used
+	HealthHardDependencies []string
 }
 
 func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
@@ -96,12 +169,25 @@ func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
 		return nil, err
 	}
 
-	limit := rate.Limit(config.MaxUserRPS)
-	if config.MaxUserRPS == 0 {
-		limit = rate.Inf
+	healthHardDependencies := DefaultHealthHardDependencies
+	if config.HealthHardDependencies != nil {
+		healthHardDependencies = config.HealthHardDependencies
+	}
+	err = validateHealthDependencies(healthHardDependencies)
+	if err != nil {
+		return nil, err
+	}
+	err = validatePeerTransports(config.PeerTransport, config.PeerTransportOverrides)
+	if err != nil {
+		return nil, err
 	}
-	userAPIRateLimiter := rate.NewLimiter(limit, config.MaxUserRPS)
-	localBuilderSender, err := NewLocalBuilderSender(config.Log, config.LocalBuilderEndpoint, config.ConnectionsPerPeer)
+
+	userAPIRateLimiter := rate.NewLimiter(userRateLimit(config.MaxUserRPS))
+	orderLedger, err := NewOrderLedger(config.OrderLedgerSize, config.OrderLedgerFile)
+	if err != nil {
+		return nil, err
+	}
+	requestDedupe, err := NewRequestDedupe(config.DedupeWindow, config.DedupeMaxEntries, config.DedupeFile)
 	if err != nil {
 		return nil, err
 	}
@@ -109,12 +195,36 @@ func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
 		ReceiverProxyConstantConfig: config.ReceiverProxyConstantConfig,
 		ConfigHub:                   NewBuilderConfigHub(config.Log, config.BuilderConfigHubEndpoint),
 		OrderflowSigner:             orderflowSigner,
-		requestUniqueKeysRLU:        expirable.NewLRU[uuid.UUID, struct{}](requestsRLUSize, nil, requestsRLUTTL),
+		requestDedupe:               requestDedupe,
 		replacementNonceRLU:         expirable.NewLRU[replacementNonceKey, int](replacementNonceSize, nil, replacementNonceTTL),
 		userAPIRateLimiter:          userAPIRateLimiter,
-		localBuilderSender:          localBuilderSender,
-		builderReadyEndpoint:        config.BuilderReadyEndpoint,
+		maxUserRPS:                  config.MaxUserRPS,
+		txValidator:                 NewTxValidator(config.TxValidation),
+		orderLedger:                 orderLedger,
+		tls:                         config.TLS,
+		blockNumberSource:           NewBlockNumberSource(config.EthRPC),
+		healthHardDependencies:      healthHardDependencies,
+		logSampler:                  proxycommon.NewLogSampler(ErrorLogSampleInterval),
+	}
+	prx.staleFilter = NewStaleFilter(prx.blockNumberSource, config.MaxBlocksAhead)
+	localBuilders := append([]LocalBuilderConfig{{
+		Name:          localBuilderPeerName,
+		Endpoint:      config.LocalBuilderEndpoint,
+		Mode:          LocalBuilderModePrimary,
+		ReadyEndpoint: config.BuilderReadyEndpoint,
+	}}, config.LocalBuilders...)
+	err = validateLocalBuilders(localBuilders)
+	if err != nil {
+		return nil, err
 	}
+	for _, builderConfig := range localBuilders {
+		builder, err := newLocalBuilder(config.Log, prx.logSampler, builderConfig, config.ConnectionsPerPeer, prx.staleFilter)
+		if err != nil {
+			return nil, err
+		}
+		prx.localBuilders = append(prx.localBuilders, builder)
+	}
+	prx.userSigners.Store(newSignerLists(nil))
 	maxRequestBodySizeBytes := DefaultMaxRequestBodySizeBytes
 	if config.MaxRequestBodySizeBytes != 0 {
 		maxRequestBodySizeBytes = config.MaxRequestBodySizeBytes
@@ -124,45 +234,92 @@ func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
 	if err != nil {
 		return nil, err
 	}
-	prx.SystemHandler = systemHandler
+	systemBatchElementHandler, err := prx.batchElementJSONRPCHandler("system_server", prx.systemMethods(), maxRequestBodySizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	systemBatchHandler := NewJSONRPCBatchHandler(RPCErrorCodeMiddleware(systemHandler), RPCErrorCodeMiddleware(systemBatchElementHandler), JSONRPCBatchOpts{
+		ServerName:              "system_server",
+		MaxRequestBodySizeBytes: maxRequestBodySizeBytes,
+		MaxBatchLength:          config.MaxBatchLength,
+		MaxBatchSizeBytes:       config.MaxBatchSizeBytes,
+	})
+	replayProtection, err := NewReplayProtection(config.Log, config.ReplayProtectionMode, config.ReplayProtectionMaxClockSkew, maxRequestBodySizeBytes, prx.systemPeerName, config.ReplayProtectionFile)
+	if err != nil {
+		return nil, err
+	}
+	prx.replayProtection = replayProtection
+	prx.peerStreams = &peerStreamServer{
+		log:              prx.Log,
+		replayProtection: replayProtection,
+		peerName:         prx.systemPeerName,
+		orderHandler:     RPCErrorCodeMiddleware(systemBatchElementHandler),
+		next:             replayProtection.Middleware(systemBatchHandler),
+		maxFrameSize:     maxRequestBodySizeBytes,
+	}
+	prx.SystemHandler = prx.peerStreams
 
 	userHandler, err := prx.UserJSONRPCHandler(maxRequestBodySizeBytes)
 	if err != nil {
 		return nil, err
 	}
-	prx.UserHandler = userHandler
+	userBatchElementHandler, err := prx.batchElementJSONRPCHandler("user_server", prx.userMethods(), maxRequestBodySizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	prx.UserHandler = NewJSONRPCBatchHandler(RPCErrorCodeMiddleware(userHandler), RPCErrorCodeMiddleware(userBatchElementHandler), JSONRPCBatchOpts{
+		ServerName:              "user_server",
+		MaxRequestBodySizeBytes: maxRequestBodySizeBytes,
+		MaxBatchLength:          config.MaxBatchLength,
+		MaxBatchSizeBytes:       config.MaxBatchSizeBytes,
+	})
 
 	shareQeueuCh := make(chan *ParsedRequest, ReceiverProxyWorkerQueueSize)
-	updatePeersCh := make(chan []ConfighubBuilder)
+	// the channel holds only the latest peer list, see updatePeerList
+	updatePeersCh := make(chan []ConfighubBuilder, 1)
 	prx.shareQueue = shareQeueuCh
 	prx.updatePeers = updatePeersCh
 
-	queue := ShareQueue{
-		name:           prx.Name,
-		log:            prx.Log,
-		queue:          shareQeueuCh,
-		updatePeers:    updatePeersCh,
-		signer:         prx.OrderflowSigner,
-		workersPerPeer: config.ConnectionsPerPeer,
+	prx.sharing = &ShareQueue{
+		name:              prx.Name,
+		log:               prx.Log,
+		logSampler:        prx.logSampler,
+		queue:             shareQeueuCh,
+		updatePeers:       updatePeersCh,
+		signer:            prx.OrderflowSigner,
+		workersPerPeer:    config.ConnectionsPerPeer,
+		minWorkersPerPeer: config.MinConnectionsPerPeer,
+		staleFilter:       prx.staleFilter,
+
+		peerTransport:          config.PeerTransport,
+		peerTransportOverrides: config.PeerTransportOverrides,
 	}
-	go queue.Run()
+	go prx.sharing.Run()
 
 	archiveQueueCh := make(chan *ParsedRequest, ReceiverProxyWorkerQueueSize)
+	archiveWorkersQueueCh := make(chan *ParsedRequest, ArchiveWorkerQueueSize)
 	archiveFlushCh := make(chan struct{})
 	prx.archiveQueue = archiveQueueCh
+	prx.archiveWorkersQueue = archiveWorkersQueueCh
 	prx.archiveFlushQueue = archiveFlushCh
+	prx.archiveFlushClose = make(chan struct{})
 	archiveHTTPClient := HTTPClientWithMaxConnections(config.ArchiveConnections)
 	archiveClient := rpcclient.NewClientWithOpts(config.ArchiveEndpoint, &rpcclient.RPCClientOpts{
 		Signer:     orderflowSigner,
 		HTTPClient: archiveHTTPClient,
 	})
 	archiveQueue := ArchiveQueue{
+		name:              prx.Name,
 		log:               prx.Log,
+		logSampler:        prx.logSampler,
 		queue:             archiveQueueCh,
+		queueAge:          &prx.archiveQueueAge,
+		workersQueue:      archiveWorkersQueueCh,
 		flushQueue:        archiveFlushCh,
 		archiveClient:     archiveClient,
-		blockNumberSource: NewBlockNumberSource(config.EthRPC),
+		blockNumberSource: prx.blockNumberSource,
 		workerCount:       config.ArchiveWorkerCount,
+		health:            &prx.archiveHealth,
 	}
 	go archiveQueue.Run()
 
@@ -183,6 +340,25 @@ func NewReceiverProxy(config ReceiverProxyConfig) (*ReceiverProxy, error) {
 		}
 	}()
 
+	prx.healthPollerClose = make(chan struct{})
+	go prx.runHealthPoller()
+
+	prx.saversClose = make(chan struct{})
+	if config.OrderLedgerFile != "" {
+		go prx.runSaver("order-ledger", prx.orderLedger.Save)
+	}
+	if config.DedupeFile != "" {
+		go prx.runSaver("dedupe", prx.requestDedupe.Save)
+	}
+	if config.ReplayProtectionFile != "" {
+		go prx.runSaver("replay-protection", prx.replayProtection.Save)
+	}
+
+	prx.tlsWatcherClose = make(chan struct{})
+	if prx.tls.Certificate != nil {
+		go prx.tls.Certificate.Watch(prx.tlsWatcherClose, prx.logSampler, prx.reregisterTLSCertificate)
+	}
+
 	// request peers on the first start
 	_ = prx.RequestNewPeers()
 
@@ -193,31 +369,136 @@ func (prx *ReceiverProxy) Stop() {
 	close(prx.shareQueue)
 	close(prx.updatePeers)
 	close(prx.archiveQueue)
-	close(prx.archiveFlushQueue)
+	close(prx.archiveFlushClose)
 	close(prx.peerUpdaterClose)
+	close(prx.healthPollerClose)
+	close(prx.saversClose)
+	close(prx.tlsWatcherClose)
+	prx.peerStreams.Close()
+	for _, builder := range prx.localBuilders {
+		builder.queue.Close()
+	}
+	err := prx.orderLedger.Save()
+	if err != nil {
+		prx.Log.Error("Failed to save order ledger", slog.Any("error", err))
+	}
+	err = prx.requestDedupe.Save()
+	if err != nil {
+		prx.Log.Error("Failed to save dedupe", slog.Any("error", err))
+	}
+	err = prx.replayProtection.Save()
+	if err != nil {
+		prx.Log.Error("Failed to save replay protection", slog.Any("error", err))
+	}
+	prx.logSampler.Stop()
+}
+
+// runSaver periodically saves the state to its file so it survives crashes
+func (prx *ReceiverProxy) runSaver(name string, save func() error) {
+	ticker := time.NewTicker(OrderLedgerSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-prx.saversClose:
+			return
+		case <-ticker.C:
+		}
+		err := save()
+		if err != nil {
+			prx.logSampler.Error(prx.Log, name, "Failed to save state", slog.String("state", name), slog.Any("error", err))
+		}
+	}
 }
 
 // RequestNewPeers updates currently available peers from the builder config hub
 func (prx *ReceiverProxy) RequestNewPeers() error {
 	builders, err := prx.ConfigHub.Builders(false)
 	if err != nil {
+		prx.builderHubHealth.failure(err)
+		// static peers are applied with the last known BuilderHub peers
+		prx.updatePeerList()
 		return err
 	}
+	prx.builderHubHealth.success()
 
 	prx.peersMu.Lock()
-	prx.lastFetchedPeers = builders
+	prx.builderHubPeers = builders
 	prx.peersMu.Unlock()
 
+	prx.updatePeerList()
+	return nil
+}
+
+// updatePeerList merges the last peers from BuilderHub with static peers and sends them to the share queue
+func (prx *ReceiverProxy) updatePeerList() {
+	prx.peersMu.Lock()
+	defer prx.peersMu.Unlock()
+	peers := mergeStaticPeers(prx.builderHubPeers, prx.staticPeers.Load())
+	prx.lastFetchedPeers = peers
+
+	// pending list that the share queue didn't take yet is replaced, so the latest list is never dropped
 	select {
-	case prx.updatePeers <- builders:
+	case <-prx.updatePeers:
 	default:
 	}
+	prx.updatePeers <- peers
+}
+
+// userRateLimit returns limit and burst for the user API, 0 rps disables rate limiting
+func userRateLimit(maxUserRPS int) (rate.Limit, int) {
+	if maxUserRPS == 0 {
+		return rate.Inf, 0
+	}
+	return rate.Limit(maxUserRPS), maxUserRPS
+}
+
+func (prx *ReceiverProxy) ValidateReloadableConfig(config *ReloadableConfig) error {
+	if config.Signers == nil {
+		return nil
+	}
+	methods := prx.userMethods()
+	for _, policy := range config.Signers.Policies {
+		for _, method := range policy.Methods {
+			if _, ok := methods[method]; !ok {
+				return fmt.Errorf("%w: %s", errSignerPolicyUnknownMethod, method)
+			}
+		}
+	}
 	return nil
 }
 
-// FlushArchiveQueue forces the archive queue to flush
-func (prx *ReceiverProxy) FlushArchiveQueue() {
-	prx.archiveFlushQueue <- struct{}{}
+func (prx *ReceiverProxy) ApplyReloadableConfig(config *ReloadableConfig) {
+	maxUserRPS := prx.maxUserRPS
+	if config.RateLimits != nil && config.RateLimits.MaxUserRPS != nil {
+		maxUserRPS = *config.RateLimits.MaxUserRPS
+	}
+	limit, burst := userRateLimit(maxUserRPS)
+	prx.userAPIRateLimiter.SetLimit(limit)
+	prx.userAPIRateLimiter.SetBurst(burst)
+
+	prx.userSigners.Store(newSignerLists(config.Signers))
+
+	staticPeers := config.StaticPeers
+	prx.staticPeers.Store(&staticPeers)
+	prx.updatePeerList()
+}
+
+// FlushArchiveQueue forces the archive queue to flush, it fails if the proxy is stopped
+func (prx *ReceiverProxy) FlushArchiveQueue(ctx context.Context) error {
+	// select picks a random ready case, the archive worker could still receive the flush after stop
+	select {
+	case <-prx.archiveFlushClose:
+		return errProxyStopped
+	default:
+	}
+	select {
+	case prx.archiveFlushQueue <- struct{}{}:
+		return nil
+	case <-prx.archiveFlushClose:
+		return errProxyStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (prx *ReceiverProxy) RegisterSecrets(ctx context.Context) error {
@@ -229,11 +510,16 @@ func (prx *ReceiverProxy) RegisterSecrets(ctx context.Context) error {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
+		var tlsCert string
+		if prx.tls.Certificate != nil {
+			tlsCert = string(prx.tls.Certificate.CertPEM())
+		}
 		err := prx.ConfigHub.RegisterCredentials(ctx, ConfighubOrderflowProxyCredentials{
-			TLSCert:            "",
+			TLSCert:            tlsCert,
 			EcdsaPubkeyAddress: prx.OrderflowSigner.Address(),
 		})
 		if err == nil {
+			prx.signerRegistered.Store(true)
 			prx.Log.Info("Credentials registered on config hub")
 			return nil
 		}
@@ -250,3 +536,24 @@ func (prx *ReceiverProxy) RegisterSecrets(ctx context.Context) error {
 		}
 	}
 }
+
+// reregisterTLSCertificate publishes reloaded certificate so peers can connect with it,
+// before the first registration it does nothing because RegisterSecrets will use the current certificate
+func (prx *ReceiverProxy) reregisterTLSCertificate() {
+	if !prx.signerRegistered.Load() {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-prx.tlsWatcherClose:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := prx.RegisterSecrets(ctx)
+	if err != nil {
+		prx.Log.Error("Failed to register reloaded TLS certificate", slog.Any("error", err))
+	}
+}
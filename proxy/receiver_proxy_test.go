@@ -13,6 +13,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -298,7 +299,7 @@ func proxiesUpdatePeers(t *testing.T) {
 
 func proxiesFlushQueue() {
 	for _, instance := range proxies {
-		instance.proxy.FlushArchiveQueue()
+		_ = instance.proxy.FlushArchiveQueue(context.Background())
 	}
 }
 
@@ -690,5 +691,69 @@ func TestJSONRPCServerReadyzOK(t *testing.T) {
 	respBody, err := io.ReadAll(rr.Body)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, rr.Code)
-	require.Contains(t, string(respBody), "ready")
+	require.Equal(t, "OK", string(respBody))
+}
+
+func TestFlushArchiveQueueAfterStop(t *testing.T) {
+	prx := createProxy(archiveServer.URL, "flush-stopped", "", "")
+	require.NoError(t, prx.FlushArchiveQueue(context.Background()))
+
+	prx.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for range 100 {
+		require.ErrorIs(t, prx.FlushArchiveQueue(ctx), errProxyStopped)
+	}
+}
+
+func TestSystemRequestForwardedAsReceived(t *testing.T) {
+	sendSystemRequest := func(body string) {
+		t.Helper()
+		header, err := flashbotsSigner.Create([]byte(body))
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(signature.HTTPHeader, header)
+		rr := httptest.NewRecorder()
+		proxies[0].proxy.SystemHandler.ServeHTTP(rr, req)
+		require.NotContains(t, rr.Body.String(), `"error"`)
+	}
+
+	// not modified request is forwarded byte by byte
+	body := fmt.Sprintf(`{"jsonrpc":"2.0", "id":42, "method":"eth_sendRawTransaction", "params":["%s"]}`, createTestTx(200).String())
+	sendSystemRequest(body)
+	builderRequest := expectRequest(t, proxies[0].localBuilderRequests)
+	require.Equal(t, body, builderRequest.body)
+	signer, err := signature.Verify(builderRequest.request.Header.Get(signature.HTTPHeader), []byte(builderRequest.body))
+	require.NoError(t, err)
+	require.Equal(t, proxies[0].proxy.OrderflowSigner.Address(), signer)
+	require.Empty(t, builderRequest.request.Header.Get(ReplayProtectionHTTPHeader))
+
+	// uuid is moved to replacementUuid so the request is re-encoded
+	body = fmt.Sprintf(`{"jsonrpc":"2.0","id":43,"method":"eth_sendBundle","params":[{"txs":["%s"],"blockNumber":"0x10","version":"v2","uuid":"eaa1d9c5-d6d4-4e9b-8bb6-0ba3d6fb6d4a"}]}`, createTestTx(201).String())
+	sendSystemRequest(body)
+	builderRequest = expectRequest(t, proxies[0].localBuilderRequests)
+	require.NotEqual(t, body, builderRequest.body)
+	require.Contains(t, builderRequest.body, `"replacementUuid":"eaa1d9c5-d6d4-4e9b-8bb6-0ba3d6fb6d4a"`)
+}
+
+func TestUpdatePeerList(t *testing.T) {
+	prx := &ReceiverProxy{
+		ReceiverProxyConstantConfig: ReceiverProxyConstantConfig{Log: discardLogger},
+		ConfigHub:                   NewBuilderConfigHub(discardLogger, "http://127.0.0.1:1"),
+		updatePeers:                 make(chan []ConfighubBuilder, 1),
+	}
+
+	// static peers are applied while BuilderHub is down
+	staticPeers := []ConfighubBuilder{{Name: "static-1"}}
+	prx.staticPeers.Store(&staticPeers)
+	require.Error(t, prx.RequestNewPeers())
+	require.Equal(t, staticPeers, <-prx.updatePeers)
+
+	// pending list is replaced by the latest one if the share queue is busy
+	prx.updatePeerList()
+	staticPeers = []ConfighubBuilder{{Name: "static-2"}}
+	prx.staticPeers.Store(&staticPeers)
+	prx.updatePeerList()
+	require.Equal(t, staticPeers, <-prx.updatePeers)
+	require.Empty(t, prx.updatePeers)
 }
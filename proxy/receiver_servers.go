@@ -19,10 +19,21 @@ var (
 	HTTP2DefaultMaxConcurrentStreams   = uint32(cli.GetEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", 4096))
 )
 
+// ReceiverTLSConfig enables TLS termination on the receiver servers
+type ReceiverTLSConfig struct {
+	// Certificate is used by the servers and registered on BuilderHub, if nil TLS is disabled
+	Certificate  *TLSCertificate
+	UserServer   bool
+	SystemServer bool
+	// CertListenAddr serves the certificate on /cert, if empty the certificate is not served
+	CertListenAddr string
+}
+
 type ReceiverProxyServers struct {
 	proxy        *ReceiverProxy
 	userServer   *http.Server
 	systemServer *http.Server
+	certServer   *http.Server
 }
 
 func StartReceiverServers(proxy *ReceiverProxy, userListenAddress, systemListenAddress string) (*ReceiverProxyServers, error) {
@@ -39,6 +50,11 @@ func StartReceiverServers(proxy *ReceiverProxy, userListenAddress, systemListenA
 		MaxUploadBufferPerStream:     HTTP2DefaultMaxUploadPerStream,
 	}
 
+	tlsConfig := proxy.tls
+	if tlsConfig.Certificate != nil && tlsConfig.UserServer {
+		userServer.TLSConfig = tlsConfig.Certificate.ServerTLSConfig()
+	}
+
 	// NOTE: as per https://github.com/golang/go/issues/67813 we still have to configure it like this
 	// NOTE: this should be only meaningful for systemServer as these changes are to improve latencies whereas RTT is around 50-100ms
 	err := http2.ConfigureServer(userServer, &userH2)
@@ -58,25 +74,49 @@ func StartReceiverServers(proxy *ReceiverProxy, userListenAddress, systemListenA
 		MaxUploadBufferPerStream:     HTTP2DefaultMaxUploadPerStream,
 	}
 
+	if tlsConfig.Certificate != nil && tlsConfig.SystemServer {
+		systemServer.TLSConfig = tlsConfig.Certificate.ServerTLSConfig()
+	}
+
 	err = http2.ConfigureServer(systemServer, &systemH2)
 	if err != nil {
 		return nil, err
 	}
 
+	var certServer *http.Server
+	if tlsConfig.Certificate != nil && tlsConfig.CertListenAddr != "" {
+		certMux := http.NewServeMux()
+		certMux.HandleFunc("/cert", tlsConfig.Certificate.CertHandler)
+		certServer = &http.Server{
+			Addr:         tlsConfig.CertListenAddr,
+			Handler:      certMux,
+			ReadTimeout:  HTTPDefaultReadTimeout,
+			WriteTimeout: HTTPDefaultWriteTimeout,
+		}
+	}
+
 	errCh := make(chan error)
 
 	go func() {
-		if err := systemServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := listenAndServe(systemServer); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			err = errors.Join(errors.New("system HTTP server failed"), err)
 			errCh <- err
 		}
 	}()
 	go func() {
-		if err := userServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := listenAndServe(userServer); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			err = errors.Join(errors.New("user HTTP server failed"), err)
 			errCh <- err
 		}
 	}()
+	if certServer != nil {
+		go func() {
+			if err := certServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				err = errors.Join(errors.New("cert HTTP server failed"), err)
+				errCh <- err
+			}
+		}()
+	}
 
 	select {
 	case err := <-errCh:
@@ -98,12 +138,24 @@ func StartReceiverServers(proxy *ReceiverProxy, userListenAddress, systemListenA
 		proxy:        proxy,
 		userServer:   userServer,
 		systemServer: systemServer,
+		certServer:   certServer,
 	}, nil
 }
 
+// listenAndServe serves TLS if the server has TLS config, the certificate is provided by the config
+func listenAndServe(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
 func (s *ReceiverProxyServers) Stop() {
 	_ = s.userServer.Close()
 	_ = s.systemServer.Close()
+	if s.certServer != nil {
+		_ = s.certServer.Close()
+	}
 	s.proxy.Stop()
 }
 
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/goccy/go-json"
+)
+
+var (
+	errNoConfigFile            = errors.New("config file is not set")
+	errReloadNotSupported      = errors.New("config section is not supported by this proxy")
+	errStaticPeerNoName        = errors.New("static peer must have a name")
+	errStaticPeerNoAddress     = errors.New("static peer must have ip or dns_name")
+	errStaticPeerNoSigner      = errors.New("static peer must have orderflow_proxy.ecdsa_pubkey_address")
+	errStaticPeerDuplicate     = errors.New("duplicate static peer name")
+	errNegativeMaxUserRPS      = errors.New("maxUserRps can't be negative")
+	errSignerAllowedAndDenied  = errors.New("signer is both in allow and deny list")
+	errSignerDenied            = errors.New("signer is not allowed to send orderflow")
+	errSignerPolicyDuplicate   = errors.New("duplicate signer policy")
+	errSignerPolicyMaxTxs      = errors.New("signer policy maxBundleTxs can't be negative")
+	errReloadableConfigInvalid = errors.New("invalid config file")
+)
+
+// ReloadableConfig is a part of the configuration that can be changed without restart (SIGHUP or admin API).
+// The file describes the whole desired state, missing sections are reset to values from flags.
+type ReloadableConfig struct {
+	// LogLevel is one of debug, info, warn, error
+	LogLevel *string `json:"logLevel,omitempty"`
+	// StaticPeers are used in addition to the peers from BuilderHub
+	StaticPeers []ConfighubBuilder `json:"staticPeers,omitempty"`
+	RateLimits  *RateLimitsConfig  `json:"rateLimits,omitempty"`
+	Signers     *SignerListsConfig `json:"signers,omitempty"`
+}
+
+type RateLimitsConfig struct {
+	// MaxUserRPS overrides max-user-requests-per-second flag, 0 disables rate limiting
+	MaxUserRPS *int `json:"maxUserRps,omitempty"`
+}
+
+// SignerListsConfig restricts signers of user orderflow, if Allow is not empty only listed signers are accepted
+type SignerListsConfig struct {
+	Allow []common.Address `json:"allow,omitempty"`
+	Deny  []common.Address `json:"deny,omitempty"`
+	// Policies restrict what the signer can send, signers without policy are not restricted
+	Policies []SignerPolicyConfig `json:"policies,omitempty"`
+}
+
+// ReloadTarget is a proxy that can apply reloadable config,
+// Apply is called only after all targets validated the config so it must not fail
+type ReloadTarget interface {
+	ValidateReloadableConfig(config *ReloadableConfig) error
+	ApplyReloadableConfig(config *ReloadableConfig)
+}
+
+// ConfigReloader loads ReloadableConfig from the file and applies it to the proxy and the logger
+type ConfigReloader struct {
+	log             *slog.Logger
+	path            string
+	logLevel        *slog.LevelVar
+	defaultLogLevel slog.Level
+	target          ReloadTarget
+
+	mu sync.Mutex
+}
+
+// NewConfigReloader creates reloader, logLevel can be nil if log level can't be changed
+func NewConfigReloader(log *slog.Logger, path string, logLevel *slog.LevelVar, target ReloadTarget) *ConfigReloader {
+	var defaultLogLevel slog.Level
+	if logLevel != nil {
+		defaultLogLevel = logLevel.Level()
+	}
+	return &ConfigReloader{
+		log:             log,
+		path:            path,
+		logLevel:        logLevel,
+		defaultLogLevel: defaultLogLevel,
+		target:          target,
+	}
+}
+
+func LoadReloadableConfig(path string) (*ReloadableConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config ReloadableConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&config)
+	if err != nil {
+		return nil, errors.Join(errReloadableConfigInvalid, err)
+	}
+	return &config, nil
+}
+
+// Reload reads, validates and applies the config file, nothing is changed if validation fails
+func (r *ConfigReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.path == "" {
+		r.log.Warn("Config reload requested but config file is not set")
+		return errNoConfigFile
+	}
+
+	config, err := LoadReloadableConfig(r.path)
+	if err == nil {
+		err = r.validate(config)
+	}
+	if err != nil {
+		r.log.Error("Failed to reload config, keeping the previous one", slog.String("path", r.path), slog.Any("error", err))
+		incConfigReloads(false)
+		return err
+	}
+
+	logLevel := r.defaultLogLevel
+	if config.LogLevel != nil {
+		// already validated
+		_ = logLevel.UnmarshalText([]byte(*config.LogLevel))
+	}
+	if r.logLevel != nil {
+		r.logLevel.Set(logLevel)
+	}
+	r.target.ApplyReloadableConfig(config)
+	incConfigReloads(true)
+
+	args := []any{
+		slog.String("path", r.path),
+		slog.String("logLevel", logLevel.String()),
+		slog.Int("staticPeers", len(config.StaticPeers)),
+	}
+	if config.RateLimits != nil && config.RateLimits.MaxUserRPS != nil {
+		args = append(args, slog.Int("maxUserRps", *config.RateLimits.MaxUserRPS))
+	}
+	if config.Signers != nil {
+		args = append(args,
+			slog.Int("allowedSigners", len(config.Signers.Allow)),
+			slog.Int("deniedSigners", len(config.Signers.Deny)),
+			slog.Int("signerPolicies", len(config.Signers.Policies)),
+		)
+	}
+	r.log.Info("Config reloaded", args...)
+	return nil
+}
+
+func (r *ConfigReloader) validate(config *ReloadableConfig) error {
+	if config.LogLevel != nil {
+		if r.logLevel == nil {
+			return fmt.Errorf("%w: logLevel", errReloadNotSupported)
+		}
+		var level slog.Level
+		err := level.UnmarshalText([]byte(*config.LogLevel))
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]struct{}, len(config.StaticPeers))
+	for _, peer := range config.StaticPeers {
+		if peer.Name == "" {
+			return errStaticPeerNoName
+		}
+		if peer.IP == "" && peer.DNSName == "" {
+			return fmt.Errorf("%w: %s", errStaticPeerNoAddress, peer.Name)
+		}
+		if peer.OrderflowProxy.EcdsaPubkeyAddress == (common.Address{}) {
+			return fmt.Errorf("%w: %s", errStaticPeerNoSigner, peer.Name)
+		}
+		if _, ok := names[peer.Name]; ok {
+			return fmt.Errorf("%w: %s", errStaticPeerDuplicate, peer.Name)
+		}
+		names[peer.Name] = struct{}{}
+	}
+
+	if config.RateLimits != nil && config.RateLimits.MaxUserRPS != nil && *config.RateLimits.MaxUserRPS < 0 {
+		return errNegativeMaxUserRPS
+	}
+
+	if config.Signers != nil {
+		for _, allowed := range config.Signers.Allow {
+			for _, denied := range config.Signers.Deny {
+				if allowed == denied {
+					return fmt.Errorf("%w: %s", errSignerAllowedAndDenied, allowed.Hex())
+				}
+			}
+		}
+		policies := make(map[common.Address]struct{}, len(config.Signers.Policies))
+		for _, policy := range config.Signers.Policies {
+			if _, ok := policies[policy.Address]; ok {
+				return fmt.Errorf("%w: %s", errSignerPolicyDuplicate, policy.Address.Hex())
+			}
+			policies[policy.Address] = struct{}{}
+			if policy.MaxBundleTxs < 0 {
+				return fmt.Errorf("%w: %s", errSignerPolicyMaxTxs, policy.Address.Hex())
+			}
+		}
+	}
+
+	return r.target.ValidateReloadableConfig(config)
+}
+
+// signerLists is a parsed SignerListsConfig
+type signerLists struct {
+	allow    map[common.Address]struct{}
+	deny     map[common.Address]struct{}
+	policies map[common.Address]*signerPolicy
+}
+
+func newSignerLists(config *SignerListsConfig) *signerLists {
+	lists := &signerLists{
+		allow:    make(map[common.Address]struct{}),
+		deny:     make(map[common.Address]struct{}),
+		policies: make(map[common.Address]*signerPolicy),
+	}
+	if config == nil {
+		return lists
+	}
+	for _, signer := range config.Allow {
+		lists.allow[signer] = struct{}{}
+	}
+	for _, signer := range config.Deny {
+		lists.deny[signer] = struct{}{}
+	}
+	for _, policy := range config.Policies {
+		lists.policies[policy.Address] = newSignerPolicy(policy)
+	}
+	return lists
+}
+
+func (l *signerLists) allowed(signer common.Address) bool {
+	if _, ok := l.deny[signer]; ok {
+		return false
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	_, ok := l.allow[signer]
+	return ok
+}
+
+// mergeStaticPeers adds static peers to the peers from BuilderHub, BuilderHub peers take precedence
+func mergeStaticPeers(builderHubPeers []ConfighubBuilder, staticPeers *[]ConfighubBuilder) []ConfighubBuilder {
+	if staticPeers == nil || len(*staticPeers) == 0 {
+		return builderHubPeers
+	}
+	result := make([]ConfighubBuilder, 0, len(builderHubPeers)+len(*staticPeers))
+	result = append(result, builderHubPeers...)
+StaticLoop:
+	for _, static := range *staticPeers {
+		for _, peer := range builderHubPeers {
+			if peer.Name == static.Name {
+				continue StaticLoop
+			}
+		}
+		result = append(result, static)
+	}
+	return result
+}
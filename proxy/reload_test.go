@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"log/slog"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigReload(t *testing.T) {
+	prx := proxies[0].proxy
+	signer := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	configPath := path.Join(t.TempDir(), "config.json")
+
+	logLevel := new(slog.LevelVar)
+	reloader := NewConfigReloader(prx.Log, configPath, logLevel, prx)
+	defer prx.ApplyReloadableConfig(&ReloadableConfig{})
+
+	writeConfig := func(config string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(configPath, []byte(config), 0o600))
+	}
+
+	writeConfig(`{"logLevel":"debug","rateLimits":{"maxUserRps":5},"signers":{"deny":["` + signer.Hex() + `"]}}`)
+	require.NoError(t, reloader.Reload())
+	require.Equal(t, slog.LevelDebug, logLevel.Level())
+	require.False(t, prx.userSigners.Load().allowed(signer))
+	require.True(t, prx.userSigners.Load().allowed(flashbotsSigner.Address()))
+	require.InDelta(t, 5, float64(prx.userAPIRateLimiter.Limit()), 0)
+
+	// invalid config is rejected and the previous one is kept
+	writeConfig(`{"rateLimits":{"maxUserRps":-1}}`)
+	require.ErrorIs(t, reloader.Reload(), errNegativeMaxUserRPS)
+	writeConfig(`{"unknownField":1}`)
+	require.ErrorIs(t, reloader.Reload(), errReloadableConfigInvalid)
+	writeConfig(`{"staticPeers":[{"name":"static"}]}`)
+	require.ErrorIs(t, reloader.Reload(), errStaticPeerNoAddress)
+	require.False(t, prx.userSigners.Load().allowed(signer))
+
+	// missing sections are reset
+	writeConfig(`{}`)
+	require.NoError(t, reloader.Reload())
+	require.Equal(t, slog.LevelInfo, logLevel.Level())
+	require.True(t, prx.userSigners.Load().allowed(signer))
+}
@@ -0,0 +1,401 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/goccy/go-json"
+)
+
+// ReplayProtectionHTTPHeader carries timestamp and nonce of the signed system request.
+// Format: <unix millis>:<hex nonce>:<signer address>:<signature>
+// where signature is created over "<unix millis>:<hex nonce>:" + request body.
+const ReplayProtectionHTTPHeader = "X-BuilderNet-Replay-Protection"
+
+const (
+	// ReplayProtectionDisabled does not check replay protection header at all
+	ReplayProtectionDisabled = "disabled"
+	// ReplayProtectionOptional verifies header when present and accepts legacy requests without it,
+	// unless the signer was already seen sending protected requests
+	ReplayProtectionOptional = "optional"
+	// ReplayProtectionRequired rejects all requests without valid replay protection header
+	ReplayProtectionRequired = "required"
+)
+
+var (
+	DefaultReplayProtectionMaxClockSkew = time.Second * 30
+
+	replayUpgradedSignersTTL = time.Hour * 24
+
+	replayNonceSize = 16
+	// replayNonceWindowMaxEntries bounds memory of the nonces in the clock skew window, requests are rejected when it's full
+	replayNonceWindowMaxEntries = 1 << 20
+
+	errReplayHeaderMissing   = errors.New("replay protection header is missing")
+	errReplayHeaderMalformed = errors.New("replay protection header is malformed")
+	errReplaySignerMismatch  = errors.New("replay protection header is signed by a different signer")
+	errReplayClockSkew       = errors.New("request timestamp is outside of allowed clock skew window")
+	errReplayNonceSeen       = errors.New("request nonce was already used")
+	errReplayNonceWindowFull = errors.New("too many replay protected requests in clock skew window")
+	errReplayUnknownSigner   = errors.New("replay protection header is signed by unknown peer")
+	errReplayBodyTooBig      = errors.New("request body too big")
+	errInvalidReplayMode     = errors.New("invalid replay protection mode")
+)
+
+type replayNonceKey struct {
+	signer common.Address
+	nonce  string
+}
+
+// ReplayProtection rejects signed system requests that are too old or that reuse a nonce.
+//
+// Compatibility with old senders is negotiated per signer: in the optional mode requests without the header
+// are accepted until the signer sends its first protected request, after that unprotected requests
+// from that signer are rejected. Only signers of known peers are tracked so unknown signers can't fill the memory.
+type ReplayProtection struct {
+	log                     *slog.Logger
+	mode                    string
+	maxClockSkew            time.Duration
+	maxRequestBodySizeBytes int64
+	// peerName returns name of the peer if the signer can send system requests, if nil all signers are accepted
+	peerName func(signer common.Address) (string, bool)
+
+	seenNonces *replayNonceWindow
+	// upgradedSigners are times of the last protected request of the signers
+	upgradedSignersMu sync.Mutex
+	upgradedSigners   map[common.Address]time.Time
+	now               func() time.Time
+
+	// path is a file upgraded signers are saved to, if empty they are in memory only
+	path   string
+	saveMu sync.Mutex
+}
+
+// NewReplayProtection creates the replay protection, if path is set upgraded signers saved before restart are loaded from it
+func NewReplayProtection(log *slog.Logger, mode string, maxClockSkew time.Duration, maxRequestBodySizeBytes int64, peerName func(signer common.Address) (string, bool), path string) (*ReplayProtection, error) {
+	switch mode {
+	case "":
+		mode = ReplayProtectionOptional
+	case ReplayProtectionDisabled, ReplayProtectionOptional, ReplayProtectionRequired:
+	default:
+		return nil, fmt.Errorf("%w: %s", errInvalidReplayMode, mode)
+	}
+	if maxClockSkew == 0 {
+		maxClockSkew = DefaultReplayProtectionMaxClockSkew
+	}
+	rp := &ReplayProtection{
+		log:                     log,
+		mode:                    mode,
+		maxClockSkew:            maxClockSkew,
+		maxRequestBodySizeBytes: maxRequestBodySizeBytes,
+		peerName:                peerName,
+		seenNonces:              newReplayNonceWindow(maxClockSkew, replayNonceWindowMaxEntries),
+		upgradedSigners:         make(map[common.Address]time.Time),
+		now:                     time.Now,
+		path:                    path,
+	}
+	if path != "" {
+		err := rp.load()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return rp, nil
+}
+
+// CreateReplayProtectionHeader creates value for ReplayProtectionHTTPHeader for the given body
+func CreateReplayProtectionHeader(signer *signature.Signer, body []byte, now time.Time) (string, error) {
+	nonceBytes := make([]byte, replayNonceSize)
+	_, err := rand.Read(nonceBytes)
+	if err != nil {
+		return "", err
+	}
+	prefix := strconv.FormatInt(now.UnixMilli(), 10) + ":" + hex.EncodeToString(nonceBytes) + ":"
+
+	payload := make([]byte, 0, len(prefix)+len(body))
+	payload = append(payload, prefix...)
+	payload = append(payload, body...)
+	sig, err := signer.Create(payload)
+	if err != nil {
+		return "", err
+	}
+	return prefix + sig, nil
+}
+
+// Middleware verifies replay protection header before passing request to the next handler
+func (rp *ReplayProtection) Middleware(next http.Handler) http.Handler {
+	if rp.mode == ReplayProtectionDisabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, r, err := readRequestBody(w, r, rp.maxRequestBodySizeBytes)
+		if err != nil {
+			writeJSONRPCError(w, nil, jsonRPCInvalidRequestCode, errReplayBodyTooBig.Error())
+			return
+		}
+
+		err = rp.verify(r.Header.Get(signature.HTTPHeader), r.Header.Get(ReplayProtectionHTTPHeader), body)
+		if err != nil {
+			rp.log.Debug("Rejected request by replay protection", slog.Any("error", err))
+			writeJSONRPCError(w, nil, jsonRPCInvalidRequestCode, err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (rp *ReplayProtection) verify(signatureHeader, replayHeader string, body []byte) error {
+	bodySigner := signatureHeaderAddress(signatureHeader)
+
+	if replayHeader == "" {
+		if rp.mode == ReplayProtectionRequired {
+			incReplayProtectionRejected("missing")
+			return errReplayHeaderMissing
+		}
+		if rp.isUpgraded(bodySigner) {
+			incReplayProtectionRejected("downgrade")
+			return errReplayHeaderMissing
+		}
+		incReplayProtectionLegacyAccepted()
+		return nil
+	}
+
+	parts := strings.SplitN(replayHeader, ":", 3)
+	if len(parts) != 3 {
+		incReplayProtectionRejected("malformed")
+		return errReplayHeaderMalformed
+	}
+	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		incReplayProtectionRejected("malformed")
+		return errReplayHeaderMalformed
+	}
+	nonce := parts[1]
+	if len(nonce) != 2*replayNonceSize {
+		incReplayProtectionRejected("malformed")
+		return errReplayHeaderMalformed
+	}
+
+	prefixLen := len(parts[0]) + len(parts[1]) + 2
+	payload := make([]byte, 0, prefixLen+len(body))
+	payload = append(payload, replayHeader[:prefixLen]...)
+	payload = append(payload, body...)
+	signer, err := signature.Verify(parts[2], payload)
+	if err != nil {
+		incReplayProtectionRejected("signature")
+		return errors.Join(errReplayHeaderMalformed, err)
+	}
+	if signer != bodySigner {
+		incReplayProtectionRejected("signer_mismatch")
+		return errReplaySignerMismatch
+	}
+	// nonces and upgrades are recorded only for peers
+	if rp.peerName != nil {
+		if _, ok := rp.peerName(signer); !ok {
+			incReplayProtectionRejected("unknown_signer")
+			return errReplayUnknownSigner
+		}
+	}
+
+	skew := rp.now().Sub(time.UnixMilli(timestamp))
+	if skew > rp.maxClockSkew || skew < -rp.maxClockSkew {
+		incReplayProtectionRejected("clock_skew")
+		return errReplayClockSkew
+	}
+
+	err = rp.seenNonces.add(replayNonceKey{signer: signer, nonce: nonce}, timestamp, rp.now())
+	if errors.Is(err, errReplayNonceWindowFull) {
+		incReplayProtectionRejected("nonce_window_full")
+		return err
+	}
+	if err != nil {
+		incReplayProtectionRejected("nonce_seen")
+		return err
+	}
+
+	rp.upgraded(signer)
+	return nil
+}
+
+func (rp *ReplayProtection) isUpgraded(signer common.Address) bool {
+	rp.upgradedSignersMu.Lock()
+	defer rp.upgradedSignersMu.Unlock()
+	lastSeen, ok := rp.upgradedSigners[signer]
+	return ok && rp.now().Sub(lastSeen) < replayUpgradedSignersTTL
+}
+
+// upgraded records the protected request of the signer, it's called on every request so TTL of active signers is refreshed
+func (rp *ReplayProtection) upgraded(signer common.Address) {
+	now := rp.now()
+	rp.upgradedSignersMu.Lock()
+	lastSeen, ok := rp.upgradedSigners[signer]
+	if !ok || now.Sub(lastSeen) >= replayUpgradedSignersTTL {
+		rp.log.Info("Signer upgraded to replay protected requests", slog.String("signer", signer.String()))
+	}
+	rp.upgradedSigners[signer] = now
+	rp.upgradedSignersMu.Unlock()
+}
+
+// replayProtectionSnapshotEntry is an upgraded signer saved to the file
+type replayProtectionSnapshotEntry struct {
+	Signer common.Address `json:"signer"`
+	// LastSeen is a unix millisecond timestamp of the last protected request
+	LastSeen int64 `json:"lastSeen"`
+}
+
+// Save writes upgraded signers to the file as JSON lines, it does nothing if the file is not set
+func (rp *ReplayProtection) Save() error {
+	if rp == nil || rp.path == "" {
+		return nil
+	}
+	rp.saveMu.Lock()
+	defer rp.saveMu.Unlock()
+
+	now := rp.now()
+	var entries []replayProtectionSnapshotEntry
+	rp.upgradedSignersMu.Lock()
+	for signer, lastSeen := range rp.upgradedSigners {
+		if now.Sub(lastSeen) >= replayUpgradedSignersTTL {
+			delete(rp.upgradedSigners, signer)
+			continue
+		}
+		entries = append(entries, replayProtectionSnapshotEntry{Signer: signer, LastSeen: lastSeen.UnixMilli()})
+	}
+	rp.upgradedSignersMu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(rp.path), filepath.Base(rp.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		err = enc.Encode(entry)
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), rp.path)
+}
+
+func (rp *ReplayProtection) load() error {
+	file, err := os.Open(rp.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for dec.More() {
+		var entry replayProtectionSnapshotEntry
+		err = dec.Decode(&entry)
+		if err != nil {
+			return err
+		}
+		rp.upgradedSigners[entry.Signer] = time.UnixMilli(entry.LastSeen)
+	}
+	return nil
+}
+
+// replayNonceWindow remembers nonces by the second of their request timestamp. A second is dropped only
+// after its timestamps left the clock skew window, so a nonce is never forgotten while its request can be accepted.
+// Nonces are never evicted early, new nonces are rejected instead when the window has maxEntries.
+type replayNonceWindow struct {
+	maxClockSkew time.Duration
+	maxEntries   int
+
+	mu      sync.Mutex
+	seconds map[int64]map[replayNonceKey]struct{}
+	count   int
+	// expiredBefore is the first second that is kept, older seconds were dropped
+	expiredBefore int64
+}
+
+func newReplayNonceWindow(maxClockSkew time.Duration, maxEntries int) *replayNonceWindow {
+	return &replayNonceWindow{
+		maxClockSkew: maxClockSkew,
+		maxEntries:   maxEntries,
+		seconds:      make(map[int64]map[replayNonceKey]struct{}),
+	}
+}
+
+// add returns errReplayNonceSeen if the nonce was already seen or errReplayNonceWindowFull if it can't be remembered,
+// timestamp is unix millis of the request that passed skew check
+func (w *replayNonceWindow) add(key replayNonceKey, timestamp int64, now time.Time) error {
+	second := timestamp / 1000
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// requests with timestamps in the second are accepted until the end of the second plus skew
+	expiredBefore := (now.Add(-w.maxClockSkew).UnixMilli() / 1000) - 1
+	if expiredBefore > w.expiredBefore {
+		for s, nonces := range w.seconds {
+			if s < expiredBefore {
+				w.count -= len(nonces)
+				delete(w.seconds, s)
+			}
+		}
+		w.expiredBefore = expiredBefore
+	}
+
+	nonces := w.seconds[second]
+	if _, seen := nonces[key]; seen {
+		return errReplayNonceSeen
+	}
+	if w.count >= w.maxEntries {
+		return errReplayNonceWindowFull
+	}
+	if nonces == nil {
+		nonces = make(map[replayNonceKey]struct{})
+		w.seconds[second] = nonces
+	}
+	nonces[key] = struct{}{}
+	w.count++
+	return nil
+}
+
+func (w *replayNonceWindow) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// signatureHeaderAddress extracts (unverified) address from the signature header
+func signatureHeaderAddress(header string) common.Address {
+	address, _, found := strings.Cut(header, ":")
+	if !found || !common.IsHexAddress(address) {
+		return common.Address{}
+	}
+	return common.HexToAddress(address)
+}
@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayNonceWindow(t *testing.T) {
+	window := newReplayNonceWindow(30*time.Second, 1<<20)
+	now := time.Now()
+
+	// nonces are not evicted by the number of requests while they are in the skew window
+	first := replayNonceKey{nonce: "first"}
+	require.NoError(t, window.add(first, now.UnixMilli(), now))
+	for i := range 300_000 {
+		require.NoError(t, window.add(replayNonceKey{nonce: strconv.Itoa(i)}, now.UnixMilli(), now))
+	}
+	require.ErrorIs(t, window.add(first, now.UnixMilli(), now.Add(30*time.Second)), errReplayNonceSeen)
+
+	// seconds are dropped after their timestamps left the window
+	later := now.Add(32 * time.Second)
+	require.NoError(t, window.add(replayNonceKey{nonce: "later"}, later.UnixMilli(), later))
+	require.Equal(t, 1, window.len())
+}
+
+func TestReplayNonceWindowFull(t *testing.T) {
+	window := newReplayNonceWindow(30*time.Second, 2)
+	now := time.Now()
+
+	require.NoError(t, window.add(replayNonceKey{nonce: "1"}, now.UnixMilli(), now))
+	require.NoError(t, window.add(replayNonceKey{nonce: "2"}, now.UnixMilli(), now))
+	require.ErrorIs(t, window.add(replayNonceKey{nonce: "3"}, now.UnixMilli(), now), errReplayNonceWindowFull)
+	// seen nonces are still reported as seen
+	require.ErrorIs(t, window.add(replayNonceKey{nonce: "1"}, now.UnixMilli(), now), errReplayNonceSeen)
+
+	later := now.Add(32 * time.Second)
+	require.NoError(t, window.add(replayNonceKey{nonce: "3"}, later.UnixMilli(), later))
+}
+
+type replayProtectionTest struct {
+	t    *testing.T
+	rp   *ReplayProtection
+	now  time.Time
+	body []byte
+}
+
+func newReplayProtectionTest(t *testing.T, filePath string, peers ...common.Address) *replayProtectionTest {
+	t.Helper()
+	peerName := func(signer common.Address) (string, bool) {
+		for _, peer := range peers {
+			if peer == signer {
+				return peer.Hex(), true
+			}
+		}
+		return "", false
+	}
+	rp, err := NewReplayProtection(discardLogger, ReplayProtectionOptional, 0, 1<<20, peerName, filePath)
+	require.NoError(t, err)
+	test := &replayProtectionTest{
+		t:    t,
+		rp:   rp,
+		now:  time.Now(),
+		body: []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_sendBundle","params":[]}`),
+	}
+	rp.now = func() time.Time { return test.now }
+	return test
+}
+
+func (test *replayProtectionTest) verify(signer *signature.Signer, protected bool) error {
+	test.t.Helper()
+	signatureHeader, err := signer.Create(test.body)
+	require.NoError(test.t, err)
+	replayHeader := ""
+	if protected {
+		replayHeader, err = CreateReplayProtectionHeader(signer, test.body, test.now)
+		require.NoError(test.t, err)
+	}
+	return test.rp.verify(signatureHeader, replayHeader, test.body)
+}
+
+func TestReplayProtectionUpgradedSignerRefresh(t *testing.T) {
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	test := newReplayProtectionTest(t, "", signer.Address())
+
+	// protected requests keep the signer upgraded longer than TTL of the first one
+	for range 4 {
+		require.NoError(t, test.verify(signer, true))
+		test.now = test.now.Add(replayUpgradedSignersTTL / 2)
+	}
+	require.ErrorIs(t, test.verify(signer, false), errReplayHeaderMissing)
+
+	// the signer that didn't send protected requests for TTL can send legacy requests again
+	test.now = test.now.Add(replayUpgradedSignersTTL)
+	require.NoError(t, test.verify(signer, false))
+}
+
+func TestReplayProtectionUnknownSigners(t *testing.T) {
+	peer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	test := newReplayProtectionTest(t, "", peer.Address())
+	require.NoError(t, test.verify(peer, true))
+
+	// unknown signers can't fill nonces or upgraded signers to evict the peer
+	for range 1000 {
+		signer, err := signature.NewRandomSigner()
+		require.NoError(t, err)
+		require.ErrorIs(t, test.verify(signer, true), errReplayUnknownSigner)
+	}
+	require.Equal(t, 1, test.rp.seenNonces.len())
+	require.Len(t, test.rp.upgradedSigners, 1)
+	require.ErrorIs(t, test.verify(peer, false), errReplayHeaderMissing)
+}
+
+func TestReplayProtectionSave(t *testing.T) {
+	peer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	filePath := path.Join(t.TempDir(), "replay-protection.jsonl")
+	test := newReplayProtectionTest(t, filePath, peer.Address())
+	require.NoError(t, test.verify(peer, true))
+	require.NoError(t, test.rp.Save())
+
+	// the peer is still upgraded after restart
+	restarted := newReplayProtectionTest(t, filePath, peer.Address())
+	require.ErrorIs(t, restarted.verify(peer, false), errReplayHeaderMissing)
+}
+
+func TestSystemRequestReplayProtection(t *testing.T) {
+	builderHubPeers = nil
+	testAddBuilderhubPeer(t, 0)
+	testAddBuilderhubPeer(t, 1)
+	proxiesUpdatePeers(t)
+
+	unknownSigner, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	peerSigner := proxies[1].proxy.OrderflowSigner
+
+	sendSystemRequest := func(signer *signature.Signer, body []byte, replayHeader string) string {
+		t.Helper()
+		header, err := signer.Create(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set(signature.HTTPHeader, header)
+		if replayHeader != "" {
+			req.Header.Set(ReplayProtectionHTTPHeader, replayHeader)
+		}
+		rr := httptest.NewRecorder()
+		proxies[0].proxy.SystemHandler.ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	// legacy request of unknown signer reaches the handler and is rejected there
+	unknownBody := []byte(`{"method":"bid_subsidiseBlock","params":[1000],"id":0,"jsonrpc":"2.0"}`)
+	require.Contains(t, sendSystemRequest(unknownSigner, unknownBody, ""), errUnknownPeer.Error())
+
+	// protected requests of unknown signers are rejected before their nonce is remembered
+	replayHeader, err := CreateReplayProtectionHeader(unknownSigner, unknownBody, time.Now())
+	require.NoError(t, err)
+	require.Contains(t, sendSystemRequest(unknownSigner, unknownBody, replayHeader), errReplayUnknownSigner.Error())
+	require.Contains(t, sendSystemRequest(unknownSigner, unknownBody, replayHeader), errReplayUnknownSigner.Error())
+	require.Contains(t, sendSystemRequest(unknownSigner, unknownBody, ""), errUnknownPeer.Error())
+
+	// the method is not handled so requests of the peer that pass replay protection are not forwarded
+	body := []byte(`{"method":"eth_replayProtectionTest","params":[],"id":0,"jsonrpc":"2.0"}`)
+
+	replayHeader, err = CreateReplayProtectionHeader(peerSigner, body, time.Now())
+	require.NoError(t, err)
+	require.NotContains(t, sendSystemRequest(peerSigner, body, replayHeader), "replay")
+
+	// the same request captured and sent again
+	require.Contains(t, sendSystemRequest(peerSigner, body, replayHeader), errReplayNonceSeen.Error())
+
+	// the peer is upgraded, legacy requests are not accepted anymore
+	require.Contains(t, sendSystemRequest(peerSigner, body, ""), errReplayHeaderMissing.Error())
+
+	oldReplayHeader, err := CreateReplayProtectionHeader(peerSigner, body, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Contains(t, sendSystemRequest(peerSigner, body, oldReplayHeader), errReplayClockSkew.Error())
+
+	// header signed for a different body
+	otherBodyHeader, err := CreateReplayProtectionHeader(peerSigner, []byte("{}"), time.Now())
+	require.NoError(t, err)
+	require.Contains(t, sendSystemRequest(peerSigner, body, otherBodyHeader), errReplayHeaderMalformed.Error())
+
+	// header signed by a different signer
+	otherSignerHeader, err := CreateReplayProtectionHeader(flashbotsSigner, body, time.Now())
+	require.NoError(t, err)
+	require.Contains(t, sendSystemRequest(peerSigner, body, otherSignerHeader), errReplaySignerMismatch.Error())
+}
@@ -2,13 +2,17 @@ package proxy
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/flashbots/go-utils/rpcserver"
 	"github.com/flashbots/go-utils/rpctypes"
 	"github.com/flashbots/go-utils/signature"
+	proxycommon "github.com/flashbots/tdx-orderflow-proxy/common"
 )
 
 type SenderProxyConstantConfig struct {
@@ -21,6 +25,12 @@ type SenderProxyConfig struct {
 	BuilderConfigHubEndpoint string
 	MaxRequestBodySizeBytes  int64
 	ConnectionsPerPeer       int
+
+	TxValidation TxValidationConfig
+	// EthRPC should support eth_blockNumber API, if empty stale orders are not filtered
+	EthRPC string
+	// MaxBlocksAhead limits target block of the orders, if 0 DefaultMaxBlocksAhead is used
+	MaxBlocksAhead uint64
 }
 
 type SenderProxy struct {
@@ -30,6 +40,15 @@ type SenderProxy struct {
 
 	updatePeers chan []ConfighubBuilder
 	shareQueue  chan *ParsedRequest
+	sharing     *ShareQueue
+	staticPeers atomic.Pointer[[]ConfighubBuilder]
+	txValidator *TxValidator
+	staleFilter *StaleFilter
+
+	blockNumberSource *BlockNumberSource
+	stop              chan struct{}
+	// logSampler collapses repeated hot path errors, it's stopped with the proxy
+	logSampler *proxycommon.LogSampler
 
 	PeerUpdateForce chan struct{}
 }
@@ -47,7 +66,15 @@ func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
 		updatePeers:               make(chan []ConfighubBuilder),
 		shareQueue:                make(chan *ParsedRequest),
 		PeerUpdateForce:           make(chan struct{}),
+		txValidator:               NewTxValidator(config.TxValidation),
+		stop:                      make(chan struct{}),
+		logSampler:                proxycommon.NewLogSampler(ErrorLogSampleInterval),
 	}
+	if config.EthRPC != "" {
+		prx.blockNumberSource = NewBlockNumberSource(config.EthRPC)
+		go prx.runBlockNumberUpdater()
+	}
+	prx.staleFilter = NewStaleFilter(prx.blockNumberSource, config.MaxBlocksAhead)
 
 	handler, err := rpcserver.NewJSONRPCHandler(rpcserver.Methods{
 		EthSendBundleMethod:         prx.EthSendBundle,
@@ -55,6 +82,9 @@ func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
 		EthCancelBundleMethod:       prx.EthCancelBundle,
 		EthSendRawTransactionMethod: prx.EthSendRawTransaction,
 		BidSubsidiseBlockMethod:     prx.BidSubsidiseBlock,
+
+		EthSendPrivateTransactionMethod:   prx.EthSendPrivateTransaction,
+		EthCancelPrivateTransactionMethod: prx.EthCancelPrivateTransaction,
 	},
 		rpcserver.JSONRPCHandlerOpts{
 			Log:                     prx.Log,
@@ -64,16 +94,18 @@ func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
 	if err != nil {
 		return nil, err
 	}
-	prx.Handler = handler
+	prx.Handler = RPCErrorCodeMiddleware(handler)
 
-	queue := ShareQueue{
+	prx.sharing = &ShareQueue{
 		log:            prx.Log,
+		logSampler:     prx.logSampler,
 		queue:          prx.shareQueue,
 		updatePeers:    prx.updatePeers,
 		signer:         prx.OrderflowSigner,
 		workersPerPeer: config.ConnectionsPerPeer,
+		staleFilter:    prx.staleFilter,
 	}
-	go queue.Run()
+	go prx.sharing.Run()
 
 	go func() {
 		for {
@@ -89,6 +121,7 @@ func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
 				prx.Log.Error("Failed to update peers", slog.Any("error", err))
 				continue
 			}
+			builders = mergeStaticPeers(builders, prx.staticPeers.Load())
 
 			prx.Log.Info("Updated peers", slog.Int("peerCount", len(builders)))
 
@@ -105,9 +138,47 @@ func NewSenderProxy(config SenderProxyConfig) (*SenderProxy, error) {
 }
 
 func (prx *SenderProxy) Stop() {
+	close(prx.stop)
 	close(prx.shareQueue)
 	close(prx.updatePeers)
 	close(prx.PeerUpdateForce)
+	prx.logSampler.Stop()
+}
+
+// runBlockNumberUpdater keeps cached head fresh so stale filter doesn't make requests while handling orders
+func (prx *SenderProxy) runBlockNumberUpdater() {
+	ticker := time.NewTicker(blockNumberUpdateInterval)
+	defer ticker.Stop()
+	for {
+		err := prx.blockNumberSource.UpdateCachedBlockNumber()
+		if err != nil {
+			prx.logSampler.Warn(prx.Log, "eth-rpc", "Failed to update block number", slog.Any("error", err))
+		}
+		select {
+		case <-prx.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (prx *SenderProxy) ValidateReloadableConfig(config *ReloadableConfig) error {
+	if config.RateLimits != nil {
+		return fmt.Errorf("%w: rateLimits", errReloadNotSupported)
+	}
+	if config.Signers != nil {
+		return fmt.Errorf("%w: signers", errReloadNotSupported)
+	}
+	return nil
+}
+
+func (prx *SenderProxy) ApplyReloadableConfig(config *ReloadableConfig) {
+	staticPeers := config.StaticPeers
+	prx.staticPeers.Store(&staticPeers)
+	select {
+	case prx.PeerUpdateForce <- struct{}{}:
+	default:
+	}
 }
 
 func (prx *SenderProxy) EthSendBundle(ctx context.Context, ethSendBundle rpctypes.EthSendBundleArgs) error {
@@ -121,6 +192,11 @@ func (prx *SenderProxy) EthSendBundle(ctx context.Context, ethSendBundle rpctype
 		return err
 	}
 
+	parsedRequest.txs, err = prx.txValidator.DecodeTxs(ethSendBundle.Txs, false)
+	if err != nil {
+		return withRPCErrorCode(ctx, err)
+	}
+
 	// quick workaround for people setting timestamp to 0
 	if ethSendBundle.MaxTimestamp != nil && *ethSendBundle.MaxTimestamp == 0 {
 		ethSendBundle.MaxTimestamp = nil
@@ -128,6 +204,12 @@ func (prx *SenderProxy) EthSendBundle(ctx context.Context, ethSendBundle rpctype
 	if ethSendBundle.MinTimestamp != nil && *ethSendBundle.MinTimestamp == 0 {
 		ethSendBundle.MinTimestamp = nil
 	}
+
+	parsedRequest.validity = ethSendBundleValidity(&ethSendBundle)
+	err = prx.staleFilter.Check(parsedRequest.method, parsedRequest.validity)
+	if err != nil {
+		return err
+	}
 	// we set explicitly bundles to be v1 for all protect flow
 	if ethSendBundle.Version == nil || *ethSendBundle.Version == "" {
 		version := rpctypes.BundleVersionV1
@@ -143,7 +225,18 @@ func (prx *SenderProxy) MevSendBundle(ctx context.Context, mevSendBundle rpctype
 		method:        MevSendBundleMethod,
 	}
 
-	err := ValidateMevSendBundle(&mevSendBundle, true)
+	_, err := ValidateMevSendBundle(&mevSendBundle, true)
+	if err != nil {
+		return err
+	}
+
+	parsedRequest.txs, err = prx.txValidator.DecodeMevBundleTxs(&mevSendBundle, false)
+	if err != nil {
+		return withRPCErrorCode(ctx, err)
+	}
+
+	parsedRequest.validity = mevSendBundleValidity(&mevSendBundle)
+	err = prx.staleFilter.Check(parsedRequest.method, parsedRequest.validity)
 	if err != nil {
 		return err
 	}
@@ -170,9 +263,60 @@ func (prx *SenderProxy) EthSendRawTransaction(ctx context.Context, ethSendRawTra
 		ethSendRawTransaction: &ethSendRawTransaction,
 		method:                EthSendRawTransactionMethod,
 	}
+
+	tx, err := prx.txValidator.DecodeTx(ethSendRawTransaction, false)
+	if err != nil {
+		return withRPCErrorCode(ctx, err)
+	}
+	parsedRequest.txs = []DecodedTx{tx}
+
 	return prx.HandleParsedRequest(ctx, parsedRequest)
 }
 
+func (prx *SenderProxy) EthSendPrivateTransaction(ctx context.Context, ethSendPrivateTransaction EthSendPrivateTransactionArgs) (common.Hash, error) {
+	parsedRequest := ParsedRequest{
+		ethSendPrivateTransaction: &ethSendPrivateTransaction,
+		method:                    EthSendPrivateTransactionMethod,
+	}
+
+	err := ValidateEthSendPrivateTransaction(&ethSendPrivateTransaction, true)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	tx, err := prx.txValidator.DecodeTx(ethSendPrivateTransaction.Tx, false)
+	if err != nil {
+		return common.Hash{}, withRPCErrorCode(ctx, err)
+	}
+	parsedRequest.txs = []DecodedTx{tx}
+
+	parsedRequest.validity = ethSendPrivateTransactionValidity(&ethSendPrivateTransaction)
+	err = prx.staleFilter.Check(parsedRequest.method, parsedRequest.validity)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	return tx.Hash, prx.HandleParsedRequest(ctx, parsedRequest)
+}
+
+func (prx *SenderProxy) EthCancelPrivateTransaction(ctx context.Context, ethCancelPrivateTransaction EthCancelPrivateTransactionArgs) (bool, error) {
+	parsedRequest := ParsedRequest{
+		ethCancelPrivateTransaction: &ethCancelPrivateTransaction,
+		method:                      EthCancelPrivateTransactionMethod,
+	}
+
+	err := ValidateEthCancelPrivateTransaction(&ethCancelPrivateTransaction, true)
+	if err != nil {
+		return false, err
+	}
+
+	err = prx.HandleParsedRequest(ctx, parsedRequest)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (prx *SenderProxy) BidSubsidiseBlock(ctx context.Context, bidSubsidiseBlock rpctypes.BidSubsisideBlockArgs) error {
 	parsedRequest := ParsedRequest{
 		bidSubsidiseBlock: &bidSubsidiseBlock,
@@ -190,12 +334,13 @@ func (prx *SenderProxy) HandleParsedRequest(ctx context.Context, parsedRequest P
 
 	err := SerializeParsedRequestForSharing(&parsedRequest, prx.OrderflowSigner)
 	if err != nil {
-		prx.Log.Warn("Failed to serialize request for sharing", slog.Any("error", err))
+		prx.logSampler.Warn(prx.Log, parsedRequest.method, "Failed to serialize request for sharing", slog.Any("error", err))
 	}
 
 	select {
 	case <-ctx.Done():
 	case prx.shareQueue <- &parsedRequest:
+		prx.sharing.queueAge.enqueued()
 	}
 	return nil
 }
@@ -1,68 +1,192 @@
 package proxy
 
 import (
-	"context"
+	"bytes"
 	"errors"
+	"fmt"
 	"log/slog"
-	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/VictoriaMetrics/metrics"
+	eth "github.com/ethereum/go-ethereum/common"
 	"github.com/flashbots/go-utils/jsonrpc"
 	"github.com/flashbots/go-utils/rpcclient"
 	"github.com/flashbots/go-utils/signature"
+	"github.com/flashbots/tdx-orderflow-proxy/common"
 	"github.com/goccy/go-json"
-	"github.com/valyala/fasthttp"
 )
 
 var (
 	ShareWorkerQueueSize = 10000
 	requestTimeout       = time.Second * 10
 
-	errUnknownRequestType = errors.New("unknown request type for sharing")
+	// ErrorLogSampleInterval is how often repeated hot path errors are logged, it's read when the proxy is created
+	ErrorLogSampleInterval = time.Second * 10
+
+	errUnknownRequestType  = errors.New("unknown request type for sharing")
+	errPeerReturnedError   = errors.New("peer returned error")
+	errPeerInvalidResponse = errors.New("peer returned invalid response")
+
+	jsonRPCErrorKey = []byte(`"error"`)
 )
 
 const (
 	bigRequestSize = 50_000
+
+	localBuilderPeerName = "local-builder"
 )
 
 type ShareQueue struct {
-	name        string
-	log         *slog.Logger
-	queue       chan *ParsedRequest
+	name       string
+	log        *slog.Logger
+	logSampler *common.LogSampler
+	queue      chan *ParsedRequest
+	// queueAge should be updated by the producers of the queue
+	queueAge    queueAgeTracker
 	updatePeers chan []ConfighubBuilder
 	signer      *signature.Signer
-	// if > 0 share queue will spawn multiple senders per peer
+	// if > 0 share queue will spawn up to workersPerPeer senders per peer
 	workersPerPeer int
+	// minWorkersPerPeer is the lower bound of adaptive senders per peer, if 0 workersPerPeer senders are always used
+	minWorkersPerPeer int
+	// staleFilter drops requests that expired while waiting in the peer queue
+	staleFilter *StaleFilter
+	// peerTransport is used for peers without override, PeerTransportHTTP1 if empty
+	peerTransport string
+	// peerTransportOverrides are transports of the peers by name
+	peerTransportOverrides map[string]string
+
+	// peers are the current peers of the running queue, they are only read outside of Run
+	peersMu sync.RWMutex
+	peers   []*shareQueuePeer
+	// disabledPeers are names of peers that we don't share to, it survives peer list updates
+	disabledPeers map[string]struct{}
+}
+
+// shareQueuePeerID identifies the peer across peer list updates, certificate and address of the peer can change
+type shareQueuePeerID struct {
+	name   string
+	signer eth.Address
+}
+
+func peerID(info ConfighubBuilder) shareQueuePeerID {
+	return shareQueuePeerID{name: info.Name, signer: info.OrderflowProxy.EcdsaPubkeyAddress}
+}
+
+// shareQueuePeerClient is the transport of the peer, it's replaced when the peer rotates certificate or changes address
+type shareQueuePeerClient struct {
+	transport PeerTransport
+	// inflight counts requests sent with the transport, replaced transport is closed after they finish
+	inflight sync.WaitGroup
 }
 
 type shareQueuePeer struct {
-	ch       chan *ParsedRequest
-	name     string
-	client   *fasthttp.Client
+	ch      chan *ParsedRequest
+	name    string
+	workers *peerWorkers
+	closed  chan struct{}
+	// chMu guards sends to ch against Close, local builders send to it from request goroutines
+	chMu     sync.RWMutex
+	chClosed bool
+
+	// clientMu protects client and conf, they are replaced by peer list updates
+	clientMu sync.RWMutex
+	client   *shareQueuePeerClient
 	conf     ConfighubBuilder
-	endpoint string
+
+	disabled   atomic.Bool
+	health     dependencyHealth
+	queueAge   queueAgeTracker
+	inflight   atomic.Int64
+	metricsSet *metrics.Set
+	metrics    *shareQueuePeerMetricHandles
+	// sendErrorLogLevel is used for delivery errors, they are expected from peers so debug is used by default
+	sendErrorLogLevel slog.Level
+	logSampler        *common.LogSampler
 }
 
-func newShareQueuePeer(name string, client *fasthttp.Client, conf ConfighubBuilder, endpoint string) shareQueuePeer {
-	return shareQueuePeer{
-		ch:       make(chan *ParsedRequest, ShareWorkerQueueSize),
-		name:     name,
-		client:   client,
-		conf:     conf,
-		endpoint: endpoint,
+func newShareQueuePeer(name string, transport PeerTransport, conf ConfighubBuilder, minWorkers, maxWorkers int, logSampler *common.LogSampler) *shareQueuePeer {
+	peer := &shareQueuePeer{
+		ch:      make(chan *ParsedRequest, ShareWorkerQueueSize),
+		name:    name,
+		workers: newPeerWorkers(minWorkers, maxWorkers),
+		closed:  make(chan struct{}),
+		client:  &shareQueuePeerClient{transport: transport},
+		conf:    conf,
+
+		sendErrorLogLevel: slog.LevelDebug,
+		logSampler:        logSampler,
 	}
+	peer.metricsSet = newShareQueuePeerMetrics(peer)
+	peer.metrics = newShareQueuePeerMetricHandles(name)
+	return peer
 }
 
 func (p *shareQueuePeer) Close() {
+	close(p.closed)
+	p.chMu.Lock()
+	p.chClosed = true
 	close(p.ch)
+	p.chMu.Unlock()
+	p.clientMu.RLock()
+	p.client.transport.Close()
+	p.clientMu.RUnlock()
+	metrics.UnregisterSet(p.metricsSet, true)
+}
+
+func (p *shareQueuePeer) config() ConfighubBuilder {
+	p.clientMu.RLock()
+	defer p.clientMu.RUnlock()
+	return p.conf
+}
+
+// acquireClient returns the current client of the peer, client.inflight.Done must be called after the request
+func (p *shareQueuePeer) acquireClient() *shareQueuePeerClient {
+	p.clientMu.RLock()
+	client := p.client
+	client.inflight.Add(1)
+	p.clientMu.RUnlock()
+	return client
+}
+
+// update replaces config of the peer and if transport is not nil its client,
+// queued orders are kept and the old transport is closed after requests in flight finish
+func (p *shareQueuePeer) update(conf ConfighubBuilder, transport PeerTransport) {
+	p.clientMu.Lock()
+	p.conf = conf
+	old := p.client
+	if transport != nil {
+		p.client = &shareQueuePeerClient{transport: transport}
+	}
+	p.clientMu.Unlock()
+	if transport != nil {
+		go func() {
+			old.inflight.Wait()
+			old.transport.Close()
+		}()
+	}
 }
 
 func (p *shareQueuePeer) SendRequest(log *slog.Logger, request *ParsedRequest) {
+	if p.disabled.Load() {
+		return
+	}
+	p.chMu.RLock()
+	defer p.chMu.RUnlock()
+	if p.chClosed {
+		return
+	}
+	// queued is recorded before the order is visible to workers so it can't overwrite the final status
+	request.ledgerEntry.record(p.name, DeliveryStatusQueued, nil)
 	select {
 	case p.ch <- request:
+		p.queueAge.enqueued()
 	default:
-		log.Error("Peer is stalling on requests", slog.String("peer", p.name))
-		incShareQueuePeerStallingErrors(p.name)
+		p.logSampler.Error(log, p.name, "Peer is stalling on requests", slog.String("peer", p.name))
+		p.metrics.stallingErrors.Inc()
+		request.ledgerEntry.record(p.name, DeliveryStatusDropped, nil)
 	}
 }
 
@@ -71,15 +195,28 @@ func (sq *ShareQueue) Run() {
 	if sq.workersPerPeer > 0 {
 		workersPerPeer = sq.workersPerPeer
 	}
-	var peers []shareQueuePeer
+	var peers []*shareQueuePeer
+	defer func() {
+		sq.setPeers(nil)
+		for _, peer := range peers {
+			peer.Close()
+		}
+	}()
+
+	metricsSet := metrics.NewSet()
+	registerQueueGauges(metricsSet, queueMetricsName("share", sq.name), sq.queue, &sq.queueAge)
+	metrics.RegisterSet(metricsSet)
+	defer metrics.UnregisterSet(metricsSet, true)
+
 	for {
 		select {
 		case req, more := <-sq.queue:
-			sq.log.Debug("Share queue received a request", slog.String("name", sq.name), slog.String("method", req.method))
 			if !more {
 				sq.log.Info("Share queue closing, queue channel closed")
 				return
 			}
+			sq.queueAge.dequeued(req, sq.queue)
+			sq.log.Debug("Share queue received a request", slog.String("name", sq.name), slog.String("method", req.method))
 			if !req.systemEndpoint {
 				for _, peer := range peers {
 					peer.SendRequest(sq.log, req)
@@ -91,165 +228,277 @@ func (sq *ShareQueue) Run() {
 				return
 			}
 
-			var peersToKeep []shareQueuePeer
-			var peersToClose []shareQueuePeer
+			// peers are matched by name and signer, so peers that changed certificate or address keep their queues
+			newPeersByID := make(map[shareQueuePeerID]ConfighubBuilder, len(newPeers))
+			for _, npi := range newPeers {
+				newPeersByID[peerID(npi)] = npi
+			}
 
-		PeerLoop:
+			var peersToKeep []*shareQueuePeer
 			for _, peer := range peers {
-				for _, npi := range newPeers {
-					if peer.conf == npi {
-						// peer found do not close
-						peersToKeep = append(peersToKeep, peer)
-						continue PeerLoop
-					}
+				id := peerID(peer.config())
+				npi, found := newPeersByID[id]
+				if !found {
+					peer.Close()
+					continue
 				}
-				peersToClose = append(peersToClose, peer)
+				delete(newPeersByID, id)
+				sq.updatePeer(peer, npi, workersPerPeer)
+				peersToKeep = append(peersToKeep, peer)
 			}
 
-			var newPeersToOpen []ConfighubBuilder
-		NewPeerLoop:
+			peers = peersToKeep
 			for _, npi := range newPeers {
-				for _, peer := range peersToKeep {
-					if peer.conf == npi {
-						continue NewPeerLoop
-					}
+				info, found := newPeersByID[peerID(npi)]
+				if !found {
+					continue
 				}
-				newPeersToOpen = append(newPeersToOpen, npi)
-			}
-
-			for _, peer := range peersToClose {
-				peer.Close()
-			}
-
-			peers = peersToKeep
-			for _, info := range newPeersToOpen {
+				delete(newPeersByID, peerID(npi))
 				// don't send to yourself
 				if info.OrderflowProxy.EcdsaPubkeyAddress == sq.signer.Address() {
 					continue
 				}
-				client, err := NewFastHTTPClient([]byte(info.TLSCert()), workersPerPeer)
+				transportName := sq.peerTransportName(info.Name)
+				transport, err := sq.newPeerTransport(info, transportName, workersPerPeer)
 				if err != nil {
 					sq.log.Error("Failed to create a peer client3", slog.Any("error", err))
 					shareQueueInternalErrors.Inc()
 					continue
 				}
 
-				sq.log.Info("Created client for peer", slog.String("peer", info.Name), slog.String("name", sq.name))
-				newPeer := newShareQueuePeer(info.Name, client, info, info.SystemAPIAddress())
+				sq.log.Info("Created client for peer", slog.String("peer", info.Name), slog.String("name", sq.name), slog.String("transport", transportName))
+				newPeer := newShareQueuePeer(info.Name, transport, info, sq.minWorkersPerPeer, workersPerPeer, sq.logSampler)
 				peers = append(peers, newPeer)
-				for worker := range workersPerPeer {
-					go sq.proxyRequests(&newPeer, worker)
-				}
+				go sq.runPeerWorkers(newPeer)
 			}
+			sq.setPeers(peers)
 		}
 	}
 }
 
-type LocalBuilderSender struct {
-	logger   *slog.Logger
-	client   *fasthttp.Client
-	endpoint string
+// updatePeer applies the new config of the existing peer, the client is replaced only if certificate or address changed
+func (sq *ShareQueue) updatePeer(peer *shareQueuePeer, info ConfighubBuilder, workers int) {
+	current := peer.config()
+	if current == info {
+		return
+	}
+	if current.TLSCert() == info.TLSCert() && current.SystemAPIAddress() == info.SystemAPIAddress() {
+		peer.update(info, nil)
+		return
+	}
+	transportName := sq.peerTransportName(info.Name)
+	transport, err := sq.newPeerTransport(info, transportName, workers)
+	if err != nil {
+		// the old client is kept, it's replaced on the next update
+		sq.log.Error("Failed to replace a peer client", slog.String("peer", info.Name), slog.Any("error", err))
+		shareQueueInternalErrors.Inc()
+		return
+	}
+	peer.update(info, transport)
+	sq.log.Info("Replaced client for peer", slog.String("peer", info.Name), slog.String("name", sq.name), slog.String("transport", transportName))
 }
 
-func NewLocalBuilderSender(logger *slog.Logger, endpoint string, maxOpenConnections int) (LocalBuilderSender, error) {
-	logger = logger.With(slog.String("peer", "local-builder"))
+// peerTransportName returns transport of the peer, overrides take precedence over the default transport
+func (sq *ShareQueue) peerTransportName(name string) string {
+	if transport, ok := sq.peerTransportOverrides[name]; ok && transport != "" {
+		return transport
+	}
+	if sq.peerTransport != "" {
+		return sq.peerTransport
+	}
+	return PeerTransportHTTP1
+}
 
-	client, err := NewFastHTTPClient(nil, maxOpenConnections)
+//nolint:ireturn
+func (sq *ShareQueue) newPeerTransport(info ConfighubBuilder, transportName string, workers int) (PeerTransport, error) {
+	certPEM := []byte(info.TLSCert())
+	if transportName != PeerTransportStream {
+		return NewPeerTransport(transportName, info.SystemAPIAddress(), certPEM, workers)
+	}
+	fallback, err := NewPeerTransport(PeerTransportHTTP1, info.SystemAPIAddress(), certPEM, workers)
 	if err != nil {
-		return LocalBuilderSender{}, err
+		return nil, err
 	}
+	return NewStreamPeerTransport(sq.log, sq.logSampler, info.Name, info.SystemAPIAddress(), certPEM, sq.signer, fallback)
+}
 
-	return LocalBuilderSender{
-		logger, client, endpoint,
-	}, nil
+func (sq *ShareQueue) setPeers(peers []*shareQueuePeer) {
+	sq.peersMu.Lock()
+	defer sq.peersMu.Unlock()
+	for _, peer := range peers {
+		_, disabled := sq.disabledPeers[peer.name]
+		peer.disabled.Store(disabled)
+	}
+	sq.peers = peers
 }
 
-func (s *LocalBuilderSender) SendRequest(req *ParsedRequest) error {
-	request := fasthttp.AcquireRequest()
-	request.SetRequestURI(s.endpoint)
-	request.Header.SetMethod(http.MethodPost)
-	request.Header.SetContentTypeBytes([]byte("application/json"))
-	defer fasthttp.ReleaseRequest(request)
+// SetPeerDisabled disables or enables sharing to the peer with the given name, the peer should be in the current
+// peer list. Disabled peer can be enabled after it left the list, it stays disabled if it comes back otherwise.
+func (sq *ShareQueue) SetPeerDisabled(name string, disabled bool) error {
+	sq.peersMu.Lock()
+	defer sq.peersMu.Unlock()
+	_, known := sq.disabledPeers[name]
+	known = known && !disabled
+	for _, peer := range sq.peers {
+		if peer.name == name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("%w: %s", errAdminUnknownPeer, name)
+	}
+	if sq.disabledPeers == nil {
+		sq.disabledPeers = make(map[string]struct{})
+	}
+	if disabled {
+		sq.disabledPeers[name] = struct{}{}
+	} else {
+		delete(sq.disabledPeers, name)
+	}
+	for _, peer := range sq.peers {
+		if peer.name == name {
+			peer.disabled.Store(disabled)
+		}
+	}
+	return nil
+}
 
-	return sendShareRequest(s.logger, req, request, s.client, "local-builder")
+// PeersInfo returns the current state of the peers
+func (sq *ShareQueue) PeersInfo() []AdminPeerInfo {
+	sq.peersMu.RLock()
+	defer sq.peersMu.RUnlock()
+	result := make([]AdminPeerInfo, 0, len(sq.peers))
+	for _, peer := range sq.peers {
+		result = append(result, AdminPeerInfo{
+			Name:                      peer.name,
+			Config:                    peer.config(),
+			Transport:                 sq.peerTransportName(peer.name),
+			Disabled:                  peer.disabled.Load(),
+			Health:                    peer.health.status(0, true),
+			QueueLength:               len(peer.ch),
+			QueueCapacity:             cap(peer.ch),
+			QueueOldestItemAgeSeconds: peer.queueAge.ageSeconds(len(peer.ch)),
+			Workers:                   int(peer.workers.current.Load()),
+			InflightRequests:          peer.inflight.Load(),
+		})
+	}
+	for name := range sq.disabledPeers {
+		found := false
+		for _, peer := range sq.peers {
+			if peer.name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, AdminPeerInfo{Name: name, Disabled: true})
+		}
+	}
+	return result
 }
 
-func sendShareRequest(logger *slog.Logger, req *ParsedRequest, request *fasthttp.Request, client *fasthttp.Client, peerName string) error {
+// sendShareRequest sends the request to the peer, it returns an error only if the request could not be made.
+// Response is handled in place without decoding successful responses to keep the hot path free of allocations.
+func sendShareRequest(logger *slog.Logger, req *ParsedRequest, peer *shareQueuePeer) error {
 	if req.serializedJSONRPCRequest == nil {
-		logger.Debug("Skip sharing request that is not serialized properly")
+		peer.logSampler.Debug(logger, peer.name, "Skip sharing request that is not serialized properly")
 		return nil
 	}
 
 	timeInQueue := time.Since(req.receivedAt)
-
-	request.Header.Set(signature.HTTPHeader, req.signatureHeader)
-	request.SetBodyRaw(req.serializedJSONRPCRequest)
-
-	resp := fasthttp.AcquireResponse()
 	start := time.Now()
-	err := client.DoTimeout(request, resp, requestTimeout)
+	client := peer.acquireClient()
+	err := client.transport.Send(PeerRequest{
+		Body:                   req.serializedJSONRPCRequest,
+		SignatureHeader:        req.signatureHeader,
+		ReplayProtectionHeader: req.replayProtectionHeader,
+	})
+	client.inflight.Done()
 	requestDuration := time.Since(start)
-	timeE2E := timeInQueue + requestDuration
-
-	// in background update metrics and handle response
-	go func() {
-		isBig := req.size >= bigRequestSize
-		timeShareQueuePeerQueueDuration(peerName, timeInQueue, req.method, req.systemEndpoint, isBig)
-		timeShareQueuePeerRPCDuration(peerName, requestDuration.Milliseconds(), isBig)
-		timeShareQueuePeerE2EDuration(peerName, timeE2E, req.method, req.systemEndpoint, isBig)
-
-		logSendErrorLevel := slog.LevelDebug
-		if peerName == "local-builder" {
-			logSendErrorLevel = slog.LevelWarn
-		}
-		if err != nil {
-			logger.Log(context.Background(), logSendErrorLevel, "Error while proxying request", slog.Any("error", err))
-			incShareQueuePeerRPCErrors(peerName)
-		} else {
-			var parsedResp jsonrpc.JSONRPCResponse
-			err = json.Unmarshal(resp.Body(), &parsedResp)
-			if err != nil {
-				logger.Log(context.Background(), logSendErrorLevel, "Error parsing response while proxying", slog.Any("error", err))
-				incShareQueuePeerRPCErrors(peerName)
-			} else if parsedResp.Error != nil {
-				logger.Log(context.Background(), logSendErrorLevel, "Error returned from target while proxying", slog.Any("error", parsedResp.Error))
-				incShareQueuePeerRPCErrors(peerName)
-			}
-		}
-		fasthttp.ReleaseResponse(resp)
-	}()
+	peer.metrics.observeRequest(req, timeInQueue, requestDuration)
+	peer.workers.observeRTT(requestDuration)
 
+	if IsPeerResponseError(err) {
+		peer.deliveryFailed(logger, req, "Error returned from target while proxying", err)
+		return nil
+	}
+	if err != nil {
+		peer.deliveryFailed(logger, req, "Error while proxying request", err)
+		return err
+	}
+	peer.health.success()
+	peer.metrics.deliveredRequests.Inc()
+	req.ledgerEntry.record(peer.name, DeliveryStatusDelivered, nil)
 	return nil
 }
 
+func (p *shareQueuePeer) deliveryFailed(logger *slog.Logger, req *ParsedRequest, msg string, err error) {
+	p.logSampler.Log(logger, p.sendErrorLogLevel, p.name, msg, slog.Any("error", err))
+	p.metrics.rpcErrors.Inc()
+	p.health.failure(err)
+	req.ledgerEntry.record(p.name, DeliveryStatusFailed, err)
+}
+
+// shareResponseError checks JSON-RPC response of the peer, only responses that have "error" key are decoded
+func shareResponseError(body []byte) error {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' {
+		return errPeerInvalidResponse
+	}
+	if !bytes.Contains(body, jsonRPCErrorKey) {
+		return nil
+	}
+	var parsedResp jsonrpc.JSONRPCResponse
+	err := json.Unmarshal(body, &parsedResp)
+	if err != nil {
+		return errors.Join(errPeerInvalidResponse, err)
+	}
+	if parsedResp.Error == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errPeerReturnedError, parsedResp.Error.Message)
+}
+
 func (sq *ShareQueue) proxyRequests(peer *shareQueuePeer, worker int) {
 	proxiedRequestCount := 0
 	logger := sq.log.With(slog.String("peer", peer.name), slog.String("name", sq.name), slog.Int("worker", worker))
-	logger.Info("Started proxying requests to peer")
+	logger.Debug("Started proxying requests to peer")
 	defer func() {
-		logger.Info("Stopped proxying requets to peer", slog.Int("proxiedRequestCount", proxiedRequestCount))
+		logger.Info("Stopped proxying requests to peer", slog.Int("proxiedRequestCount", proxiedRequestCount))
 	}()
 
-	request := fasthttp.AcquireRequest()
-	request.SetRequestURI(peer.endpoint)
-	request.Header.SetMethod(http.MethodPost)
-	request.Header.SetContentTypeBytes([]byte("application/json"))
-	defer fasthttp.ReleaseRequest(request)
-
 	for {
-		req, more := <-peer.ch
+		var (
+			req  *ParsedRequest
+			more bool
+		)
+		select {
+		case req, more = <-peer.ch:
+		case <-peer.workers.stop:
+			return
+		}
 		if !more {
 			return
 		}
+		peer.queueAge.dequeued(req, peer.ch)
 
 		if req.serializedJSONRPCRequest == nil {
-			logger.Debug("Skip sharing request that is not serialized properly")
+			sq.logSampler.Debug(logger, peer.name, "Skip sharing request that is not serialized properly")
 			continue
 		}
 
-		err := sendShareRequest(logger, req, request, peer.client, peer.name)
+		if sq.staleFilter.Expired(req.validity) {
+			peer.metrics.expiredRequests.Inc()
+			req.ledgerEntry.record(peer.name, DeliveryStatusExpired, nil)
+			continue
+		}
+
+		peer.inflight.Add(1)
+		err := sendShareRequest(logger, req, peer)
+		peer.inflight.Add(-1)
 		if err != nil {
-			logger.Debug("Failed to proxy a request", slog.Any("error", err))
+			sq.logSampler.Debug(logger, peer.name, "Failed to proxy a request", slog.Any("error", err))
 		}
 
 		proxiedRequestCount += 1
@@ -257,7 +506,41 @@ func (sq *ShareQueue) proxyRequests(peer *shareQueuePeer, worker int) {
 	}
 }
 
+// SerializeParsedRequestForSharing serializes and signs the request,
+// requests that were received on the system endpoint and not modified are forwarded as received
 func SerializeParsedRequestForSharing(req *ParsedRequest, signer *signature.Signer) error {
+	ser := req.rawJSONRPCRequest
+	if ser == nil || req.argsModified {
+		var err error
+		ser, err = marshalParsedRequest(req)
+		if err != nil {
+			return err
+		}
+	}
+
+	req.serializedJSONRPCRequest = ser
+
+	if signer != nil {
+		header, err := signer.Create(ser)
+		if err != nil {
+			return err
+		}
+		req.signatureHeader = header
+
+		// replay protection is verified only by peers, local builders don't check it
+		if !req.localOnly() {
+			replayHeader, err := CreateReplayProtectionHeader(signer, ser, time.Now())
+			if err != nil {
+				return err
+			}
+			req.replayProtectionHeader = replayHeader
+		}
+	}
+
+	return nil
+}
+
+func marshalParsedRequest(req *ParsedRequest) ([]byte, error) {
 	var (
 		method string
 		data   any
@@ -277,26 +560,16 @@ func SerializeParsedRequestForSharing(req *ParsedRequest, signer *signature.Sign
 	} else if req.bidSubsidiseBlock != nil {
 		method = BidSubsidiseBlockMethod
 		data = req.bidSubsidiseBlock
+	} else if req.ethSendPrivateTransaction != nil {
+		method = EthSendPrivateTransactionMethod
+		data = req.ethSendPrivateTransaction
+	} else if req.ethCancelPrivateTransaction != nil {
+		method = EthCancelPrivateTransactionMethod
+		data = req.ethCancelPrivateTransaction
 	} else {
-		return errUnknownRequestType
+		return nil, errUnknownRequestType
 	}
 
 	request := rpcclient.NewRequestWithID(0, method, data)
-
-	ser, err := json.Marshal(request)
-	if err != nil {
-		return err
-	}
-
-	req.serializedJSONRPCRequest = ser
-
-	if signer != nil {
-		header, err := signer.Create(ser)
-		if err != nil {
-			return err
-		}
-		req.signatureHeader = header
-	}
-
-	return nil
+	return json.Marshal(request)
 }
@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/flashbots/go-utils/rpcclient"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestShareQueuePeerGauges(t *testing.T) {
+	writeMetrics := func() string {
+		var buf bytes.Buffer
+		metrics.WritePrometheus(&buf, false)
+		return buf.String()
+	}
+
+	builderHubPeers = nil
+	for i := range proxies {
+		testAddBuilderhubPeer(t, i)
+	}
+	proxiesUpdatePeers(t)
+
+	out := writeMetrics()
+	require.Contains(t, out, fmt.Sprintf(`orderflow_proxy_share_queue_peer_capacity{peer="proxy:1"} %d`, ShareWorkerQueueSize))
+	require.Contains(t, out, `orderflow_proxy_share_queue_peer_inflight_requests{peer="proxy:2"} 0`)
+	require.Contains(t, out, `orderflow_proxy_queue_length{queue="share_proxy:0"} 0`)
+	require.Contains(t, out, `orderflow_proxy_queue_capacity{queue="archive_proxy:0"}`)
+
+	// peer gauges are removed with the peer
+	builderHubPeers = nil
+	testAddBuilderhubPeer(t, 0)
+	proxiesUpdatePeers(t)
+
+	out = writeMetrics()
+	require.NotContains(t, out, `orderflow_proxy_share_queue_peer_capacity{peer="proxy:1"}`)
+	require.NotContains(t, out, `orderflow_proxy_share_queue_peer_capacity{peer="proxy:2"}`)
+}
+
+func BenchmarkSerializeParsedRequestForSharing(b *testing.B) {
+	signer, err := signature.NewRandomSigner()
+	require.NoError(b, err)
+
+	for _, txCount := range []int{1, 10, 100} {
+		txs := make([]hexutil.Bytes, txCount)
+		for i := range txs {
+			txs[i] = *createTestTx(i)
+		}
+		blockNumber := hexutil.Uint64(100)
+		args := &rpctypes.EthSendBundleArgs{Txs: txs, BlockNumber: &blockNumber}
+		raw, err := json.Marshal(rpcclient.NewRequestWithID(1, EthSendBundleMethod, args))
+		require.NoError(b, err)
+
+		for _, signed := range []bool{false, true} {
+			var requestSigner *signature.Signer
+			if signed {
+				requestSigner = signer
+			}
+			b.Run(fmt.Sprintf("txs=%d/signed=%t/reencode", txCount, signed), func(b *testing.B) {
+				b.ReportAllocs()
+				for range b.N {
+					req := ParsedRequest{ethSendBundle: args}
+					err := SerializeParsedRequestForSharing(&req, requestSigner)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			// system requests are forwarded as received and only to the local builder, so they are signed once
+			b.Run(fmt.Sprintf("txs=%d/signed=%t/system", txCount, signed), func(b *testing.B) {
+				b.ReportAllocs()
+				for range b.N {
+					req := ParsedRequest{ethSendBundle: args, rawJSONRPCRequest: raw, systemEndpoint: true}
+					err := SerializeParsedRequestForSharing(&req, requestSigner)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newInmemoryShareQueuePeer creates a peer that sends requests to the in-memory server returning the given response
+func newInmemoryShareQueuePeer(tb testing.TB, response string) *shareQueuePeer {
+	tb.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	server := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString(response)
+	}}
+	go func() { _ = server.Serve(ln) }()
+	tb.Cleanup(func() { _ = server.Shutdown() })
+
+	client, err := NewFastHTTPClient(nil, 1)
+	require.NoError(tb, err)
+	client.Dial = func(string) (net.Conn, error) { return ln.Dial() }
+	return newShareQueuePeer("inmemory-"+tb.Name(), &fastHTTPPeerTransport{client: client, endpoint: "http://peer/"}, ConfighubBuilder{}, 1, 1, nil)
+}
+
+func newTestSharedRequest(tb testing.TB) *ParsedRequest {
+	tb.Helper()
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["%s"]}`, createTestTx(0).String())
+	return &ParsedRequest{
+		method:                   EthSendRawTransactionMethod,
+		receivedAt:               time.Now(),
+		size:                     len(body),
+		serializedJSONRPCRequest: []byte(body),
+		signatureHeader:          "0x0000000000000000000000000000000000000000:0x00",
+	}
+}
+
+func TestShareResponseError(t *testing.T) {
+	require.NoError(t, shareResponseError([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`)))
+	require.NoError(t, shareResponseError([]byte(` {"jsonrpc":"2.0","id":1,"result":"error"}`)))
+	require.NoError(t, shareResponseError([]byte(`{"jsonrpc":"2.0","id":1,"result":null,"error":null}`)))
+	require.ErrorIs(t, shareResponseError([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"bad"}}`)), errPeerReturnedError)
+	require.ErrorIs(t, shareResponseError([]byte(`not found`)), errPeerInvalidResponse)
+	require.ErrorIs(t, shareResponseError(nil), errPeerInvalidResponse)
+}
+
+func TestSendShareRequestAllocations(t *testing.T) {
+	peer := newInmemoryShareQueuePeer(t, `{"jsonrpc":"2.0","id":1,"result":null}`)
+	req := newTestSharedRequest(t)
+
+	// warm up connection and metric handles
+	require.NoError(t, sendShareRequest(discardLogger, req, peer))
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = sendShareRequest(discardLogger, req, peer)
+	})
+	// allocations of the in-memory server are counted too
+	require.LessOrEqual(t, allocs, 2.0)
+	require.True(t, peer.health.status(time.Minute, false).Healthy)
+}
+
+func BenchmarkSendShareRequest(b *testing.B) {
+	for _, tc := range []struct {
+		name     string
+		response string
+	}{
+		{"result", `{"jsonrpc":"2.0","id":1,"result":null}`},
+		{"error", `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"bad"}}`},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			peer := newInmemoryShareQueuePeer(b, tc.response)
+			req := newTestSharedRequest(b)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				err := sendShareRequest(discardLogger, req, peer)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestShareQueuePeerRotation(t *testing.T) {
+	newPeerServer := func(requests chan *RequestData, release chan struct{}) (*httptest.Server, ConfighubBuilder) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests <- &RequestData{request: r, body: string(body)}
+			if release != nil {
+				<-release
+			}
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+		}))
+		info := ConfighubBuilder{
+			Name:           "rotating-peer",
+			IP:             server.Listener.Addr().String(),
+			OrderflowProxy: ConfighubOrderflowProxyCredentials{EcdsaPubkeyAddress: flashbotsSigner.Address()},
+			Instance: ConfighubInstanceData{
+				TLSCert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})),
+			},
+		}
+		return server, info
+	}
+	oldRequests, newRequests := make(chan *RequestData, 10), make(chan *RequestData, 10)
+	release := make(chan struct{})
+	oldServer, oldInfo := newPeerServer(oldRequests, release)
+	defer oldServer.Close()
+	newServer, newInfo := newPeerServer(newRequests, nil)
+	defer newServer.Close()
+
+	signer, err := signature.NewRandomSigner()
+	require.NoError(t, err)
+	queue := make(chan *ParsedRequest, 10)
+	updatePeers := make(chan []ConfighubBuilder)
+	sq := &ShareQueue{name: "test", log: discardLogger, queue: queue, updatePeers: updatePeers, signer: signer, workersPerPeer: 1}
+	go sq.Run()
+	defer close(queue)
+
+	updatePeers <- []ConfighubBuilder{oldInfo}
+	require.Eventually(t, func() bool { return len(sq.PeersInfo()) == 1 }, time.Second, time.Millisecond)
+	peer := sq.peers[0]
+
+	// first order is in flight on the old client, the others wait in the queue
+	for range 3 {
+		queue <- newTestSharedRequest(t)
+	}
+	expectRequest(t, oldRequests)
+	require.Eventually(t, func() bool { return len(peer.ch) == 2 }, time.Second, time.Millisecond)
+
+	// unrelated change keeps the client
+	client := peer.client
+	legacyInfo := oldInfo
+	legacyInfo.OrderflowProxy.TLSCert = "legacy"
+	updatePeers <- []ConfighubBuilder{legacyInfo}
+	require.Eventually(t, func() bool { return peer.config() == legacyInfo }, time.Second, time.Millisecond)
+	require.Same(t, client, peer.client)
+
+	// rotated certificate and address replace the client under the same queue
+	updatePeers <- []ConfighubBuilder{newInfo}
+	require.Eventually(t, func() bool { return peer.config() == newInfo }, time.Second, time.Millisecond)
+	require.Len(t, sq.PeersInfo(), 1)
+	require.Same(t, peer, sq.peers[0])
+	require.Len(t, peer.ch, 2)
+
+	close(release)
+	expectRequest(t, newRequests)
+	expectRequest(t, newRequests)
+	expectNoRequest(t, oldRequests)
+	require.Eventually(t, func() bool { return peer.metrics.deliveredRequests.Get() == 3 }, time.Second, time.Millisecond)
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	errSignerMethodNotAllowed    = errors.New("signer is not allowed to call this method")
+	errSignerBundleTooBig        = errors.New("bundle has more txs than allowed for the signer")
+	errSignerPolicyUnknownMethod = errors.New("signer policy has unknown method")
+)
+
+// decisions of the signer policy, exported as metric labels
+const (
+	signerDecisionAllowed          = "allowed"
+	signerDecisionLocalOnly        = "allowed_local_only"
+	signerDecisionDenied           = "denied"
+	signerDecisionNotAllowlisted   = "not_allowlisted"
+	signerDecisionMethodNotAllowed = "method_not_allowed"
+	signerDecisionBundleTooBig     = "bundle_too_big"
+)
+
+// SignerPolicyConfig restricts orderflow of one user signer
+type SignerPolicyConfig struct {
+	Address common.Address `json:"address"`
+	// Methods signer can call, if empty all methods are allowed
+	Methods []string `json:"methods,omitempty"`
+	// MaxBundleTxs limits number of txs in bundles of the signer, 0 means no limit
+	MaxBundleTxs int `json:"maxBundleTxs,omitempty"`
+	// LocalOnly orderflow is sent only to the local builder and archive, it's not shared with peers
+	LocalOnly bool `json:"localOnly,omitempty"`
+}
+
+// signerPolicy is a parsed SignerPolicyConfig
+type signerPolicy struct {
+	methods      map[string]struct{}
+	maxBundleTxs int
+	localOnly    bool
+}
+
+func newSignerPolicy(config SignerPolicyConfig) *signerPolicy {
+	policy := &signerPolicy{
+		maxBundleTxs: config.MaxBundleTxs,
+		localOnly:    config.LocalOnly,
+	}
+	if len(config.Methods) > 0 {
+		policy.methods = make(map[string]struct{}, len(config.Methods))
+		for _, method := range config.Methods {
+			policy.methods[method] = struct{}{}
+		}
+	}
+	return policy
+}
+
+// check returns policy of the signer (nil if signer is not restricted) if signer can call the method
+func (l *signerLists) check(signer common.Address, method string) (*signerPolicy, error) {
+	if _, ok := l.deny[signer]; ok {
+		incSignerPolicyDecision(signerDecisionDenied)
+		return nil, errSignerDenied
+	}
+	if !l.allowed(signer) {
+		incSignerPolicyDecision(signerDecisionNotAllowlisted)
+		return nil, errSignerDenied
+	}
+	policy := l.policies[signer]
+	if policy != nil && policy.methods != nil {
+		if _, ok := policy.methods[method]; !ok {
+			incSignerPolicyDecision(signerDecisionMethodNotAllowed)
+			return nil, fmt.Errorf("%w: %s", errSignerMethodNotAllowed, method)
+		}
+	}
+	return policy, nil
+}
+
+// checkRequest enforces the part of the policy that depends on the parsed request, it's called after request is validated
+func (p *signerPolicy) checkRequest(req *ParsedRequest) error {
+	if p == nil {
+		incSignerPolicyDecision(signerDecisionAllowed)
+		return nil
+	}
+	if p.maxBundleTxs > 0 && len(req.txs) > p.maxBundleTxs {
+		incSignerPolicyDecision(signerDecisionBundleTooBig)
+		return errSignerBundleTooBig
+	}
+	if p.localOnly {
+		incSignerPolicyDecision(signerDecisionLocalOnly)
+	} else {
+		incSignerPolicyDecision(signerDecisionAllowed)
+	}
+	return nil
+}
+
+func (p *signerPolicy) isLocalOnly() bool {
+	return p != nil && p.localOnly
+}
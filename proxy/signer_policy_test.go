@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/flashbots/go-utils/rpcclient"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignerPolicy(t *testing.T) {
+	signer, err := signature.NewSignerFromHexPrivateKey("0xd63b3c447fdea415a05e4c0b859474d14105a88178efdf350bc9f7b05be3cc58")
+	require.NoError(t, err)
+	client, err := RPCClientWithCertAndSigner(proxies[0].localServerEndpoint, proxies[0].PublicCertPEM, signer, 1)
+	require.NoError(t, err)
+
+	builderHubPeers = nil
+	testAddBuilderhubPeer(t, 0)
+	testAddBuilderhubPeer(t, 1)
+	proxiesUpdatePeers(t)
+
+	prx := proxies[0].proxy
+	configPath := path.Join(t.TempDir(), "config.json")
+	reloader := NewConfigReloader(prx.Log, configPath, nil, prx)
+	defer prx.ApplyReloadableConfig(&ReloadableConfig{})
+	reload := func(config string) error {
+		t.Helper()
+		require.NoError(t, os.WriteFile(configPath, []byte(config), 0o600))
+		return reloader.Reload()
+	}
+	sendBundle := func(method string, args any) *rpcclient.RPCError {
+		t.Helper()
+		resp, err := client.Call(context.Background(), method, args)
+		require.NoError(t, err)
+		return resp.Error
+	}
+
+	policy := `{"address":"` + signer.Address().Hex() + `","methods":["eth_sendBundle"],"maxBundleTxs":1,"localOnly":true}`
+	require.NoError(t, reload(`{"signers":{"policies":[`+policy+`]}}`))
+
+	rpcErr := sendBundle(EthSendBundleMethod, &rpctypes.EthSendBundleArgs{Txs: []hexutil.Bytes{*createTestTx(0), *createTestTx(1)}})
+	require.NotNil(t, rpcErr)
+	require.Contains(t, rpcErr.Message, errSignerBundleTooBig.Error())
+
+	rpcErr = sendBundle(EthSendRawTransactionMethod, createTestTx(0))
+	require.NotNil(t, rpcErr)
+	require.Contains(t, rpcErr.Message, errSignerMethodNotAllowed.Error())
+	expectNoRequest(t, proxies[0].localBuilderRequests)
+
+	// local only orderflow is not shared with peers
+	require.Nil(t, sendBundle(EthSendBundleMethod, &rpctypes.EthSendBundleArgs{Txs: []hexutil.Bytes{*createTestTx(0)}}))
+	expectRequest(t, proxies[0].localBuilderRequests)
+	expectNoRequest(t, proxies[1].localBuilderRequests)
+
+	// signers without policy are not restricted but allowlist and denylist still apply
+	require.NoError(t, reload(`{"signers":{"allow":["`+flashbotsSigner.Address().Hex()+`"]}}`))
+	rpcErr = sendBundle(EthSendBundleMethod, &rpctypes.EthSendBundleArgs{Txs: []hexutil.Bytes{*createTestTx(0)}})
+	require.NotNil(t, rpcErr)
+	require.Contains(t, rpcErr.Message, errSignerDenied.Error())
+	require.NoError(t, reload(`{"signers":{"deny":["`+signer.Address().Hex()+`"]}}`))
+	rpcErr = sendBundle(EthSendBundleMethod, &rpctypes.EthSendBundleArgs{Txs: []hexutil.Bytes{*createTestTx(0)}})
+	require.NotNil(t, rpcErr)
+	require.Contains(t, rpcErr.Message, errSignerDenied.Error())
+	expectNoRequest(t, proxies[0].localBuilderRequests)
+
+	require.ErrorIs(t, reload(`{"signers":{"policies":[{"address":"0x0000000000000000000000000000000000000001","methods":["eth_call"]}]}}`), errSignerPolicyUnknownMethod)
+	require.ErrorIs(t, reload(`{"signers":{"policies":[`+policy+`,`+policy+`]}}`), errSignerPolicyDuplicate)
+
+	var buf bytes.Buffer
+	metrics.WritePrometheus(&buf, false)
+	out := buf.String()
+	for _, decision := range []string{signerDecisionLocalOnly, signerDecisionBundleTooBig, signerDecisionMethodNotAllowed, signerDecisionNotAllowlisted, signerDecisionDenied} {
+		require.Contains(t, out, fmt.Sprintf(`orderflow_proxy_api_signer_policy_decisions{decision="%s"}`, decision))
+	}
+}
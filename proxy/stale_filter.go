@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"errors"
+	"time"
+
+	"github.com/flashbots/go-utils/rpctypes"
+)
+
+var (
+	// DefaultMaxBlocksAhead limits how far in the future the target block of the order can be
+	DefaultMaxBlocksAhead = uint64(100)
+	// staleFilterMaxHeadAge is how old cached head can be, with older head orders are not filtered
+	staleFilterMaxHeadAge = time.Second * 36
+	// blockNumberUpdateInterval is how often sender proxy updates cached head
+	blockNumberUpdateInterval = time.Second * 2
+
+	errOrderBlockPassed    = errors.New("order target block is in the past")
+	errOrderTooFarAhead    = errors.New("order target block is too far in the future")
+	errOrderTimestampStale = errors.New("order max timestamp is in the past")
+)
+
+const (
+	staleReasonBlockPassed = "block_passed"
+	staleReasonTooFarAhead = "too_far_ahead"
+	staleReasonTimestamp   = "timestamp_passed"
+)
+
+// orderValidity is the block range and deadline of the order, zero values are not checked
+type orderValidity struct {
+	minBlock     uint64
+	maxBlock     uint64
+	maxTimestamp uint64
+}
+
+func ethSendBundleValidity(args *rpctypes.EthSendBundleArgs) orderValidity {
+	var v orderValidity
+	if args.BlockNumber != nil {
+		v.minBlock = uint64(*args.BlockNumber)
+		v.maxBlock = v.minBlock
+	}
+	if args.MaxTimestamp != nil {
+		v.maxTimestamp = *args.MaxTimestamp
+	}
+	return v
+}
+
+func mevSendBundleValidity(args *rpctypes.MevSendBundleArgs) orderValidity {
+	return orderValidity{
+		minBlock: uint64(args.Inclusion.BlockNumber),
+		maxBlock: max(uint64(args.Inclusion.MaxBlock), uint64(args.Inclusion.BlockNumber)),
+	}
+}
+
+func ethSendPrivateTransactionValidity(args *EthSendPrivateTransactionArgs) orderValidity {
+	var v orderValidity
+	if args.MaxBlockNumber != nil {
+		v.maxBlock = uint64(*args.MaxBlockNumber)
+	}
+	return v
+}
+
+// StaleFilter rejects orders that can't be included anymore, it uses cached head so it can be used on the hot path
+type StaleFilter struct {
+	blockNumberSource *BlockNumberSource
+	maxBlocksAhead    uint64
+}
+
+// NewStaleFilter returns filter for the head of blockNumberSource, if maxBlocksAhead is 0 DefaultMaxBlocksAhead is used
+func NewStaleFilter(blockNumberSource *BlockNumberSource, maxBlocksAhead uint64) *StaleFilter {
+	if maxBlocksAhead == 0 {
+		maxBlocksAhead = DefaultMaxBlocksAhead
+	}
+	return &StaleFilter{
+		blockNumberSource: blockNumberSource,
+		maxBlocksAhead:    maxBlocksAhead,
+	}
+}
+
+func (f *StaleFilter) head() (uint64, bool) {
+	if f == nil || f.blockNumberSource == nil {
+		return 0, false
+	}
+	return f.blockNumberSource.CachedBlockNumber(staleFilterMaxHeadAge)
+}
+
+// Check is called when the order is received, it rejects orders for passed blocks or too far in the future
+func (f *StaleFilter) Check(method string, v orderValidity) error {
+	if v.maxTimestamp != 0 && int64(v.maxTimestamp) < apiNow().Unix() { //nolint:gosec
+		incStaleOrdersRejected(method, staleReasonTimestamp)
+		return errOrderTimestampStale
+	}
+	head, ok := f.head()
+	if !ok {
+		return nil
+	}
+	if v.maxBlock != 0 && v.maxBlock <= head {
+		incStaleOrdersRejected(method, staleReasonBlockPassed)
+		return errOrderBlockPassed
+	}
+	if v.minBlock > head+f.maxBlocksAhead {
+		incStaleOrdersRejected(method, staleReasonTooFarAhead)
+		return errOrderTooFarAhead
+	}
+	return nil
+}
+
+// Expired is called before sending queued order, it is true if the order expired while waiting in the queue
+func (f *StaleFilter) Expired(v orderValidity) bool {
+	if v.maxTimestamp != 0 && int64(v.maxTimestamp) < apiNow().Unix() { //nolint:gosec
+		return true
+	}
+	if v.maxBlock == 0 {
+		return false
+	}
+	head, ok := f.head()
+	return ok && v.maxBlock <= head
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/flashbots/go-utils/rpcclient"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaleFilter(t *testing.T) {
+	client, err := RPCClientWithCertAndSigner(proxies[0].localServerEndpoint, proxies[0].PublicCertPEM, flashbotsSigner, 1)
+	require.NoError(t, err)
+
+	builderHubPeers = nil
+	testAddBuilderhubPeer(t, 0)
+	proxiesUpdatePeers(t)
+
+	blockSource := proxies[0].proxy.blockNumberSource
+	blockSource.cacheMu.Lock()
+	blockSource.cachedNumber = 1000
+	blockSource.cacheTimestamp = time.Now().Add(time.Hour)
+	blockSource.cacheMu.Unlock()
+	defer func() {
+		blockSource.cacheMu.Lock()
+		blockSource.cacheTimestamp = time.Time{}
+		blockSource.cacheMu.Unlock()
+	}()
+
+	sendBundle := func(args *rpctypes.EthSendBundleArgs) *rpcclient.RPCError {
+		t.Helper()
+		resp, err := client.Call(context.Background(), EthSendBundleMethod, args)
+		require.NoError(t, err)
+		return resp.Error
+	}
+	block := func(n uint64) *hexutil.Uint64 {
+		b := hexutil.Uint64(n)
+		return &b
+	}
+	pastTimestamp := uint64(time.Now().Add(-time.Minute).Unix())
+
+	rpcErr := sendBundle(&rpctypes.EthSendBundleArgs{BlockNumber: block(1000)})
+	require.NotNil(t, rpcErr)
+	require.Contains(t, rpcErr.Message, errOrderBlockPassed.Error())
+
+	rpcErr = sendBundle(&rpctypes.EthSendBundleArgs{BlockNumber: block(1000 + DefaultMaxBlocksAhead + 1)})
+	require.NotNil(t, rpcErr)
+	require.Contains(t, rpcErr.Message, errOrderTooFarAhead.Error())
+
+	rpcErr = sendBundle(&rpctypes.EthSendBundleArgs{BlockNumber: block(1001), MaxTimestamp: &pastTimestamp})
+	require.NotNil(t, rpcErr)
+	require.Contains(t, rpcErr.Message, errOrderTimestampStale.Error())
+	expectNoRequest(t, proxies[0].localBuilderRequests)
+
+	require.Nil(t, sendBundle(&rpctypes.EthSendBundleArgs{BlockNumber: block(1001)}))
+	expectRequest(t, proxies[0].localBuilderRequests)
+
+	filter := proxies[0].proxy.staleFilter
+	mevBundle := &rpctypes.MevSendBundleArgs{Inclusion: rpctypes.MevBundleInclusion{BlockNumber: 990, MaxBlock: 1005}}
+	require.NoError(t, filter.Check(MevSendBundleMethod, mevSendBundleValidity(mevBundle)))
+	mevBundle.Inclusion.MaxBlock = 999
+	require.ErrorIs(t, filter.Check(MevSendBundleMethod, mevSendBundleValidity(mevBundle)), errOrderBlockPassed)
+
+	// orders are not filtered without fresh head
+	require.NoError(t, (*StaleFilter)(nil).Check(EthSendBundleMethod, orderValidity{maxBlock: 1}))
+	require.False(t, NewStaleFilter(NewBlockNumberSource("eth-rpc-not-set"), 0).Expired(orderValidity{maxBlock: 1}))
+
+	// requests that expired in the peer queue are dropped
+	peerRequests := make(chan *RequestData, 2)
+	peerServer := ServeHTTPRequestToChan(peerRequests)
+	defer peerServer.Close()
+	peerTransport, err := NewPeerTransport(PeerTransportHTTP1, peerServer.URL, nil, 1)
+	require.NoError(t, err)
+	peer := newShareQueuePeer("stale-filter-peer", peerTransport, ConfighubBuilder{}, 1, 1, nil)
+	defer peer.Close()
+	sq := &ShareQueue{log: proxies[0].proxy.Log, staleFilter: filter}
+	go sq.proxyRequests(peer, 0)
+
+	peer.ch <- &ParsedRequest{serializedJSONRPCRequest: []byte(`{"id":1}`), validity: orderValidity{maxBlock: 1000}}
+	peer.ch <- &ParsedRequest{serializedJSONRPCRequest: []byte(`{"id":2}`), validity: orderValidity{maxBlock: 1001}}
+	require.Equal(t, `{"id":2}`, expectRequest(t, peerRequests).body)
+	expectNoRequest(t, peerRequests)
+
+	var buf bytes.Buffer
+	metrics.WritePrometheus(&buf, false)
+	require.Contains(t, buf.String(), `orderflow_proxy_share_queue_peer_expired_requests{peer="stale-filter-peer"} 1`)
+	require.Contains(t, buf.String(), `orderflow_proxy_api_stale_orders_rejected{method="eth_sendBundle",reason="block_passed"}`)
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	utils_tls "github.com/flashbots/go-utils/tls"
+	"github.com/flashbots/tdx-orderflow-proxy/common"
+)
+
+var (
+	// TLSCertReloadInterval is how often certificate files are checked for changes
+	TLSCertReloadInterval = time.Second * 10
+
+	DefaultTLSCertDuration = time.Hour * 24 * 365
+	DefaultTLSCertHosts    = []string{"127.0.0.1", "localhost"}
+
+	errTLSCertPathNotSet = errors.New("tls certificate and key paths should be set")
+)
+
+// TLSCertificateConfig describes where the certificate is stored and how to generate it if it doesn't exist
+type TLSCertificateConfig struct {
+	CertPath string
+	KeyPath  string
+	// CertDuration is validity of the generated certificate, if 0 DefaultTLSCertDuration is used
+	CertDuration time.Duration
+	// CertHosts of the generated certificate, if empty DefaultTLSCertHosts are used
+	CertHosts []string
+}
+
+type loadedTLSCertificate struct {
+	certificate *tls.Certificate
+	certPEM     []byte
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// TLSCertificate is a certificate loaded from disk that is reloaded when the files change,
+// new certificate is used for new connections, existing connections are not dropped
+type TLSCertificate struct {
+	log      *slog.Logger
+	certPath string
+	keyPath  string
+	current  atomic.Pointer[loadedTLSCertificate]
+}
+
+// NewTLSCertificate loads the certificate, it is generated and saved if the files don't exist
+func NewTLSCertificate(log *slog.Logger, config TLSCertificateConfig) (*TLSCertificate, error) {
+	if config.CertPath == "" || config.KeyPath == "" {
+		return nil, errTLSCertPathNotSet
+	}
+	duration := config.CertDuration
+	if duration == 0 {
+		duration = DefaultTLSCertDuration
+	}
+	hosts := config.CertHosts
+	if len(hosts) == 0 {
+		hosts = DefaultTLSCertHosts
+	}
+	_, _, err := utils_tls.GetOrGenerateTLS(config.CertPath, config.KeyPath, duration, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &TLSCertificate{
+		log:      log,
+		certPath: config.CertPath,
+		keyPath:  config.KeyPath,
+	}
+	loaded, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	c.current.Store(loaded)
+	return c, nil
+}
+
+func (c *TLSCertificate) load() (*loadedTLSCertificate, error) {
+	certInfo, err := os.Stat(c.certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := os.Stat(c.keyPath)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, err := os.ReadFile(c.certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(c.keyPath)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &loadedTLSCertificate{
+		certificate: &certificate,
+		certPEM:     certPEM,
+		certModTime: certInfo.ModTime(),
+		keyModTime:  keyInfo.ModTime(),
+	}, nil
+}
+
+// Reload loads the certificate if the files changed, it returns true if the certificate was replaced.
+// If the new files are invalid the previous certificate is kept.
+func (c *TLSCertificate) Reload() (bool, error) {
+	current := c.current.Load()
+	certInfo, err := os.Stat(c.certPath)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(c.keyPath)
+	if err != nil {
+		return false, err
+	}
+	if certInfo.ModTime().Equal(current.certModTime) && keyInfo.ModTime().Equal(current.keyModTime) {
+		return false, nil
+	}
+
+	loaded, err := c.load()
+	if err != nil {
+		incTLSCertReloads(false)
+		return false, err
+	}
+	c.current.Store(loaded)
+	incTLSCertReloads(true)
+	return !bytes.Equal(loaded.certPEM, current.certPEM), nil
+}
+
+// Watch reloads the certificate until stop is closed, onChange is called after the certificate is replaced.
+// Repeated reload errors are collapsed by logSampler.
+func (c *TLSCertificate) Watch(stop <-chan struct{}, logSampler *common.LogSampler, onChange func()) {
+	ticker := time.NewTicker(TLSCertReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		changed, err := c.Reload()
+		if err != nil {
+			logSampler.Error(c.log, "tls-cert", "Failed to reload TLS certificate, keeping the previous one", slog.Any("error", err))
+			continue
+		}
+		if changed {
+			c.log.Info("TLS certificate reloaded", slog.String("fingerprint", CertFingerprint(c.CertPEM())))
+			if onChange != nil {
+				onChange()
+			}
+		}
+	}
+}
+
+// CertPEM returns the current certificate
+func (c *TLSCertificate) CertPEM() []byte {
+	return c.current.Load().certPEM
+}
+
+func (c *TLSCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.current.Load().certificate, nil
+}
+
+// ServerTLSConfig returns config that always uses the current certificate
+func (c *TLSCertificate) ServerTLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: c.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// CertHandler serves the current certificate so clients can pin it
+func (c *TLSCertificate) CertHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(c.CertPEM())
+}
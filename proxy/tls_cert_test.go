@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	utils_tls "github.com/flashbots/go-utils/tls"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certPath := path.Join(dir, "cert.pem")
+	keyPath := path.Join(dir, "key.pem")
+
+	cert, err := NewTLSCertificate(slog.Default(), TLSCertificateConfig{CertPath: certPath, KeyPath: keyPath})
+	require.NoError(t, err)
+	firstPEM := cert.CertPEM()
+	savedPEM, err := os.ReadFile(certPath)
+	require.NoError(t, err)
+	require.Equal(t, savedPEM, firstPEM)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{
+		Handler:           http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		ReadHeaderTimeout: time.Second,
+	}
+	go func() { _ = server.Serve(tls.NewListener(listener, cert.ServerTLSConfig())) }()
+	defer server.Close()
+
+	servedCert := func() []byte {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+		require.NoError(t, err)
+		defer conn.Close()
+		certs := conn.ConnectionState().PeerCertificates
+		require.NotEmpty(t, certs)
+		return certs[0].Raw
+	}
+	firstServed := servedCert()
+
+	changed, err := cert.Reload()
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// rotate the certificate on disk
+	newCert, newKey, err := utils_tls.GenerateTLS(time.Hour, []string{"localhost"})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certPath, newCert, 0o600))
+	require.NoError(t, os.WriteFile(keyPath, newKey, 0o600))
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certPath, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyPath, modTime, modTime))
+
+	changed, err = cert.Reload()
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, newCert, cert.CertPEM())
+	require.NotEqual(t, firstServed, servedCert())
+
+	// invalid files keep the previous certificate
+	require.NoError(t, os.WriteFile(keyPath, []byte("invalid"), 0o600))
+	modTime = modTime.Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyPath, modTime, modTime))
+	changed, err = cert.Reload()
+	require.Error(t, err)
+	require.False(t, changed)
+	require.Equal(t, newCert, cert.CertPEM())
+
+	rr := httptest.NewRecorder()
+	cert.CertHandler(rr, httptest.NewRequest(http.MethodGet, "/cert", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, newCert, rr.Body.Bytes())
+}
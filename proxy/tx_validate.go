@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/go-utils/jsonrpc"
+	"github.com/flashbots/go-utils/rpctypes"
+	"github.com/goccy/go-json"
+)
+
+// JSON-RPC error codes of rejected transactions
+const (
+	TxErrorCodeMalformed        = -32602
+	TxErrorCodeInvalidChainID   = -32010
+	TxErrorCodeInvalidSignature = -32011
+	TxErrorCodeTooBig           = -32012
+	TxErrorCodeTypeNotAllowed   = -32013
+)
+
+const (
+	DefaultTxMaxCalldataSizeBytes = 128 * 1024 // 128 KiB
+	DefaultTxMaxAuthorizations    = 32
+)
+
+var (
+	errTxMalformed             = errors.New("tx can't be decoded")
+	errTxWrongChainID          = errors.New("tx has wrong chain id")
+	errTxInvalidSignature      = errors.New("tx signature is invalid")
+	errTxCalldataTooBig        = errors.New("tx calldata is too big")
+	errTxBlobWithoutSidecar    = errors.New("blob tx must have sidecar")
+	errTxBlobSidecarInvalid    = errors.New("blob tx sidecar doesn't match blob hashes")
+	errTxNoAuthorizations      = errors.New("set code tx must have authorizations")
+	errTxTooManyAuthorizations = errors.New("set code tx has too many authorizations")
+)
+
+// RPCError is a method error that is returned to the caller with the given JSON-RPC error code
+type RPCError struct {
+	Code int
+	Err  error
+}
+
+func (e *RPCError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.Err
+}
+
+type TxValidationConfig struct {
+	// ChainID of accepted txs, if 0 chain id is not checked
+	ChainID uint64
+	// MaxCalldataSizeBytes limits tx data, if 0 DefaultTxMaxCalldataSizeBytes is used
+	MaxCalldataSizeBytes int
+	// MaxAuthorizations limits EIP-7702 authorization list, if 0 DefaultTxMaxAuthorizations is used
+	MaxAuthorizations int
+}
+
+// TxValidator decodes raw txs and rejects the ones that can't be included
+type TxValidator struct {
+	chainID              *big.Int
+	signer               types.Signer
+	maxCalldataSizeBytes int
+	maxAuthorizations    int
+}
+
+func NewTxValidator(config TxValidationConfig) *TxValidator {
+	v := &TxValidator{
+		maxCalldataSizeBytes: config.MaxCalldataSizeBytes,
+		maxAuthorizations:    config.MaxAuthorizations,
+	}
+	if config.ChainID != 0 {
+		v.chainID = new(big.Int).SetUint64(config.ChainID)
+		v.signer = types.LatestSignerForChainID(v.chainID)
+	}
+	if v.maxCalldataSizeBytes == 0 {
+		v.maxCalldataSizeBytes = DefaultTxMaxCalldataSizeBytes
+	}
+	if v.maxAuthorizations == 0 {
+		v.maxAuthorizations = DefaultTxMaxAuthorizations
+	}
+	return v
+}
+
+// DecodedTx is a validated tx with values that are expensive to compute
+type DecodedTx struct {
+	Tx   *types.Transaction
+	Hash common.Hash
+	// Sender is not recovered for txs from the system endpoint
+	Sender common.Address
+}
+
+// DecodeTx decodes and validates the tx, errors are RPCError with the code describing the rejection reason.
+// Txs from the system endpoint were validated by the proxy of the peer so the signature is not verified again.
+func (v *TxValidator) DecodeTx(raw []byte, systemEndpoint bool) (DecodedTx, error) {
+	tx := new(types.Transaction)
+	err := tx.UnmarshalBinary(raw)
+	if err != nil {
+		return DecodedTx{}, &RPCError{Code: TxErrorCodeMalformed, Err: errors.Join(errTxMalformed, err)}
+	}
+
+	if len(tx.Data()) > v.maxCalldataSizeBytes {
+		return DecodedTx{}, &RPCError{Code: TxErrorCodeTooBig, Err: errTxCalldataTooBig}
+	}
+
+	switch tx.Type() {
+	case types.BlobTxType:
+		sidecar := tx.BlobTxSidecar()
+		if sidecar == nil {
+			return DecodedTx{}, &RPCError{Code: TxErrorCodeTypeNotAllowed, Err: errTxBlobWithoutSidecar}
+		}
+		err = sidecar.ValidateBlobCommitmentHashes(tx.BlobHashes())
+		if err != nil {
+			return DecodedTx{}, &RPCError{Code: TxErrorCodeTypeNotAllowed, Err: errors.Join(errTxBlobSidecarInvalid, err)}
+		}
+	case types.SetCodeTxType:
+		authorizations := len(tx.SetCodeAuthorizations())
+		if authorizations == 0 {
+			return DecodedTx{}, &RPCError{Code: TxErrorCodeTypeNotAllowed, Err: errTxNoAuthorizations}
+		}
+		if authorizations > v.maxAuthorizations {
+			return DecodedTx{}, &RPCError{Code: TxErrorCodeTypeNotAllowed, Err: errTxTooManyAuthorizations}
+		}
+	}
+
+	if v.chainID != nil && tx.Protected() && tx.ChainId().Cmp(v.chainID) != 0 {
+		return DecodedTx{}, &RPCError{Code: TxErrorCodeInvalidChainID, Err: errTxWrongChainID}
+	}
+	if systemEndpoint {
+		return DecodedTx{Tx: tx, Hash: tx.Hash()}, nil
+	}
+
+	signer := v.signer
+	if v.chainID == nil {
+		signer = types.LatestSignerForChainID(tx.ChainId())
+	}
+	sender, err := types.Sender(signer, tx)
+	if err != nil {
+		return DecodedTx{}, &RPCError{Code: TxErrorCodeInvalidSignature, Err: errors.Join(errTxInvalidSignature, err)}
+	}
+
+	return DecodedTx{Tx: tx, Hash: tx.Hash(), Sender: sender}, nil
+}
+
+// DecodeTxs decodes all txs, the error mentions the index of the first invalid tx
+func (v *TxValidator) DecodeTxs(raws []hexutil.Bytes, systemEndpoint bool) ([]DecodedTx, error) {
+	if len(raws) == 0 {
+		return nil, nil
+	}
+	txs := make([]DecodedTx, 0, len(raws))
+	for i, raw := range raws {
+		tx, err := v.DecodeTx(raw, systemEndpoint)
+		if err != nil {
+			return nil, wrapTxError(err, i)
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+// DecodeMevBundleTxs decodes txs of mev_sendBundle including nested bundles, bundle hashes are skipped
+func (v *TxValidator) DecodeMevBundleTxs(bundle *rpctypes.MevSendBundleArgs, systemEndpoint bool) ([]DecodedTx, error) {
+	var txs []DecodedTx
+	for i, body := range bundle.Body {
+		if body.Tx != nil {
+			tx, err := v.DecodeTx(*body.Tx, systemEndpoint)
+			if err != nil {
+				return nil, wrapTxError(err, i)
+			}
+			txs = append(txs, tx)
+		}
+		if body.Bundle != nil {
+			nested, err := v.DecodeMevBundleTxs(body.Bundle, systemEndpoint)
+			if err != nil {
+				return nil, err
+			}
+			txs = append(txs, nested...)
+		}
+	}
+	return txs, nil
+}
+
+func wrapTxError(err error, index int) error {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return &RPCError{Code: rpcErr.Code, Err: fmt.Errorf("tx %d: %w", index, rpcErr.Err)}
+	}
+	return fmt.Errorf("tx %d: %w", index, err)
+}
+
+type rpcErrorCodeKey struct{}
+
+// rpcErrorCode is filled by the method so the handler can write the code of the returned RPCError
+type rpcErrorCode struct {
+	code int
+}
+
+// withRPCErrorCode records the code if err is RPCError, rpcserver writes all method errors with the same code
+func withRPCErrorCode(ctx context.Context, err error) error {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		if slot, ok := ctx.Value(rpcErrorCodeKey{}).(*rpcErrorCode); ok {
+			slot.code = rpcErr.Code
+		}
+	}
+	return err
+}
+
+// RPCErrorCodeMiddleware rewrites the code of the JSON-RPC error response if the method returned RPCError
+func RPCErrorCodeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		slot := &rpcErrorCode{}
+		rw := &batchElementResponseWriter{header: w.Header(), code: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), rpcErrorCodeKey{}, slot)))
+
+		res := rw.body.Bytes()
+		if slot.code != 0 {
+			res = patchRPCErrorCode(res, slot.code)
+		}
+		w.WriteHeader(rw.code)
+		_, _ = w.Write(res)
+	})
+}
+
+func patchRPCErrorCode(res []byte, code int) []byte {
+	var resp jsonrpc.JSONRPCResponse
+	err := json.Unmarshal(res, &resp)
+	if err != nil || resp.Error == nil {
+		return res
+	}
+	resp.Error.Code = code
+	patched, err := json.Marshal(resp)
+	if err != nil {
+		return res
+	}
+	return patched
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/flashbots/go-utils/signature"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxValidator(t *testing.T) {
+	privateKey, err := crypto.HexToECDSA("c7589782d55a642c8ced7794ddcb24b62d4ebefbb81001034cb46545ff80e39e")
+	require.NoError(t, err)
+	sender := crypto.PubkeyToAddress(privateKey.PublicKey)
+
+	signTx := func(txData types.TxData, chainID int64) []byte {
+		t.Helper()
+		tx, err := types.SignNewTx(privateKey, types.LatestSignerForChainID(big.NewInt(chainID)), txData)
+		require.NoError(t, err)
+		raw, err := tx.MarshalBinary()
+		require.NoError(t, err)
+		return raw
+	}
+	dynamicFeeTx := func(chainID int64, data []byte) types.TxData {
+		return &types.DynamicFeeTx{ChainID: big.NewInt(chainID), Gas: 21000, To: &common.Address{}, Data: data}
+	}
+
+	validator := NewTxValidator(TxValidationConfig{ChainID: 1, MaxCalldataSizeBytes: 100, MaxAuthorizations: 1})
+
+	valid := signTx(dynamicFeeTx(1, nil), 1)
+	tx, err := validator.DecodeTx(valid, false)
+	require.NoError(t, err)
+	require.Equal(t, sender, tx.Sender)
+	require.Equal(t, crypto.Keccak256Hash(valid), tx.Hash)
+
+	unsigned, err := types.NewTx(dynamicFeeTx(1, nil)).MarshalBinary()
+	require.NoError(t, err)
+
+	authorization, err := types.SignSetCode(privateKey, types.SetCodeAuthorization{ChainID: *uint256.NewInt(1)})
+	require.NoError(t, err)
+	setCodeTx := func(authorizations []types.SetCodeAuthorization) types.TxData {
+		return &types.SetCodeTx{ChainID: uint256.NewInt(1), Gas: 21000, AuthList: authorizations}
+	}
+
+	testCases := []struct {
+		name string
+		raw  []byte
+		code int
+		err  error
+	}{
+		{"malformed", []byte{0x12, 0x34}, TxErrorCodeMalformed, errTxMalformed},
+		{"wrong chain id", signTx(dynamicFeeTx(5, nil), 5), TxErrorCodeInvalidChainID, errTxWrongChainID},
+		{"invalid signature", unsigned, TxErrorCodeInvalidSignature, errTxInvalidSignature},
+		{"calldata too big", signTx(dynamicFeeTx(1, make([]byte, 101)), 1), TxErrorCodeTooBig, errTxCalldataTooBig},
+		{"blob without sidecar", signTx(&types.BlobTx{ChainID: uint256.NewInt(1), Gas: 21000, BlobHashes: []common.Hash{{0x01}}}, 1), TxErrorCodeTypeNotAllowed, errTxBlobWithoutSidecar},
+		{"no authorizations", signTx(setCodeTx(nil), 1), TxErrorCodeTypeNotAllowed, errTxNoAuthorizations},
+		{"too many authorizations", signTx(setCodeTx([]types.SetCodeAuthorization{authorization, authorization}), 1), TxErrorCodeTypeNotAllowed, errTxTooManyAuthorizations},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := validator.DecodeTx(tc.raw, false)
+			require.ErrorIs(t, err, tc.err)
+			var rpcErr *RPCError
+			require.ErrorAs(t, err, &rpcErr)
+			require.Equal(t, tc.code, rpcErr.Code)
+		})
+	}
+
+	_, err = validator.DecodeTx(signTx(setCodeTx([]types.SetCodeAuthorization{authorization}), 1), false)
+	require.NoError(t, err)
+
+	_, err = validator.DecodeTxs([]hexutil.Bytes{valid, {0x12}}, false)
+	require.ErrorContains(t, err, "tx 1: ")
+
+	// sender is not recovered for txs from the system endpoint
+	tx, err = validator.DecodeTx(unsigned, true)
+	require.NoError(t, err)
+	require.Equal(t, common.Address{}, tx.Sender)
+	_, err = validator.DecodeTx(signTx(dynamicFeeTx(5, nil), 5), true)
+	require.ErrorIs(t, err, errTxWrongChainID)
+
+	// error code is returned to the user
+	body := `{"method":"eth_sendRawTransaction","params":["0x1234"],"id":1,"jsonrpc":"2.0"}`
+	header, err := flashbotsSigner.Create([]byte(body))
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	req.Header.Set(signature.HTTPHeader, header)
+	rr := httptest.NewRecorder()
+	proxies[0].proxy.UserHandler.ServeHTTP(rr, req)
+	var resp struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Equal(t, TxErrorCodeMalformed, resp.Error.Code)
+	require.Contains(t, resp.Error.Message, errTxMalformed.Error())
+}
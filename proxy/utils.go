@@ -13,8 +13,10 @@ import (
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/flashbots/go-utils/cli"
+	"github.com/flashbots/go-utils/jsonrpc"
 	"github.com/flashbots/go-utils/rpcclient"
 	"github.com/flashbots/go-utils/signature"
+	"github.com/goccy/go-json"
 	"github.com/valyala/fasthttp"
 	"golang.org/x/net/http2"
 )
@@ -174,3 +176,31 @@ func (bs *BlockNumberSource) BlockNumber() (uint64, error) {
 	bs.cacheMu.RUnlock()
 	return res, nil
 }
+
+// CachedBlockNumber returns block number if it was updated within maxAge, it never makes requests
+func (bs *BlockNumberSource) CachedBlockNumber(maxAge time.Duration) (uint64, bool) {
+	bs.cacheMu.RLock()
+	defer bs.cacheMu.RUnlock()
+	if bs.cacheTimestamp.IsZero() || time.Since(bs.cacheTimestamp) > maxAge {
+		return 0, false
+	}
+	return bs.cachedNumber, true
+}
+
+const jsonRPCInvalidRequestCode = -32600
+
+// writeJSONRPCError writes JSON-RPC error response for requests rejected before reaching rpcserver handler
+func writeJSONRPCError(w http.ResponseWriter, id any, code int, message string) {
+	res, err := json.Marshal(jsonrpc.JSONRPCResponse{
+		ID:      id,
+		Error:   &jsonrpc.JSONRPCError{Code: code, Message: message},
+		Version: "2.0",
+	})
+	if err != nil {
+		http.Error(w, message, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(res)
+}